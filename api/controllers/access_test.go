@@ -0,0 +1,263 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/middleware"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// accessFixture has two users: bob owns a personal note and an
+// organization with a note, alice is the caller trying to reach them
+type accessFixture struct {
+	router   *gin.Engine
+	orgRepo  repository.OrgRepo
+	noteRepo repository.NoteRepo
+	alice    models.User
+	bob      models.User
+	org      models.Organization
+	bobNote  models.Note
+	orgNote  models.Note
+	aliceJWT string
+	bobJWT   string
+}
+
+func newAccessFixture(t *testing.T) *accessFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	err := auth.Init(config.Auth{JWTSecret: "access-test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := repository.NewUserRepo(db)
+	noteRepo := repository.NewNoteRepo(db)
+	orgRepo := repository.NewOrgRepo(db)
+
+	f := &accessFixture{orgRepo: orgRepo, noteRepo: noteRepo}
+	ctx := context.Background()
+	f.alice = models.User{Username: "alice", Email: "alice@example.com", Role: "user", Level: 1}
+	f.bob = models.User{Username: "bob", Email: "bob@example.com", Role: "user", Level: 1}
+	for _, user := range []*models.User{&f.alice, &f.bob} {
+		err := userRepo.CreateUser(ctx, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.org = models.Organization{Name: "bobs"}
+	err = orgRepo.CreateOrg(ctx, &f.org, f.bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.bobNote = models.Note{Title: "bob", Content: "personal", UserID: &f.bob.ID, Username: f.bob.Username}
+	f.orgNote = models.Note{Title: "bobs", Content: "shared", UserID: &f.bob.ID, Username: f.bob.Username, OrgID: &f.org.ID}
+	for _, note := range []*models.Note{&f.bobNote, &f.orgNote} {
+		err := noteRepo.Create(ctx, note)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notes := NewNote(noteRepo)
+	orgs := NewOrg(orgRepo, noteRepo, userRepo, mailer.NewMailer(config.Mail{}))
+	f.router = gin.New()
+	api := f.router.Group("/api", middleware.JWTAuth())
+	api.GET("/notes/:id", notes.Read)
+	api.PUT("/notes/:id", notes.Update)
+	api.DELETE("/notes/:id", notes.Delete)
+	api.GET("/orgs/:org/notes/:id", orgs.ReadNote)
+	api.PUT("/orgs/:org/notes/:id", orgs.UpdateNote)
+	api.DELETE("/orgs/:org/notes/:id", orgs.DeleteNote)
+
+	f.aliceJWT = signTestToken(t, f.alice)
+	f.bobJWT = signTestToken(t, f.bob)
+	return f
+}
+
+func signTestToken(t *testing.T, user models.User) string {
+	t.Helper()
+	now := time.Now()
+	token, err := auth.SignToken(&models.Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Level:    user.Level,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// unchanged checks that the refused requests left the note as it was
+func (f *accessFixture) unchanged(t *testing.T, want models.Note) {
+	t.Helper()
+	note := models.Note{ID: want.ID}
+	err := f.noteRepo.Read(context.Background(), &note)
+	if err != nil {
+		t.Fatalf("note %d after the refused requests: %v", want.ID, err)
+	}
+	if note.Title != want.Title || note.Content != want.Content {
+		t.Errorf("note %d = %q %q, want %q %q", want.ID, note.Title, note.Content, want.Title, want.Content)
+	}
+}
+
+// do sends a request with the token and returns the status
+func (f *accessFixture) do(method, path, token string) int {
+	var body *strings.Reader
+	if method == http.MethodPut {
+		body = strings.NewReader(`{"title":"changed"}`)
+	} else {
+		body = strings.NewReader("")
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestPersonalNotesOfOthersAreNotFound(t *testing.T) {
+	f := newAccessFixture(t)
+	bobNote := fmt.Sprintf("/api/notes/%d", f.bobNote.ID)
+	orgNote := fmt.Sprintf("/api/notes/%d", f.orgNote.ID)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"read", http.MethodGet, bobNote},
+		{"update", http.MethodPut, bobNote},
+		{"delete", http.MethodDelete, bobNote},
+		{"read organization note", http.MethodGet, orgNote},
+		{"update organization note", http.MethodPut, orgNote},
+		{"delete organization note", http.MethodDelete, orgNote},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.do(tt.method, tt.path, f.aliceJWT); got != http.StatusNotFound {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, http.StatusNotFound)
+			}
+		})
+	}
+
+	// the notes of bob are left as they were
+	f.unchanged(t, f.bobNote)
+	f.unchanged(t, f.orgNote)
+	if got := f.do(http.MethodGet, bobNote, f.bobJWT); got != http.StatusOK {
+		t.Errorf("owner read = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestOrganizationNotesAreRefused(t *testing.T) {
+	f := newAccessFixture(t)
+	path := fmt.Sprintf("/api/orgs/%s/notes/%d", f.org.Name, f.orgNote.ID)
+
+	// organizations are hidden from non-members
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		if got := f.do(method, path, f.aliceJWT); got != http.StatusNotFound {
+			t.Errorf("non-member %s = %d, want %d", method, got, http.StatusNotFound)
+		}
+	}
+
+	// viewers read but don't change the notes
+	membership := models.Membership{
+		OrgID:  f.org.ID,
+		UserID: f.alice.ID,
+		Role:   models.OrgRoleViewer,
+	}
+	err := f.orgRepo.SaveMembership(context.Background(), &membership)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		method string
+		want   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPut, http.StatusForbidden},
+		{http.MethodDelete, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := f.do(tt.method, path, f.aliceJWT); got != tt.want {
+			t.Errorf("viewer %s = %d, want %d", tt.method, got, tt.want)
+		}
+	}
+	f.unchanged(t, f.orgNote)
+
+	// editors change them
+	membership.Role = models.OrgRoleEditor
+	err = f.orgRepo.SaveMembership(context.Background(), &membership)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.do(http.MethodPut, path, f.aliceJWT); got != http.StatusOK {
+		t.Errorf("editor %s = %d, want %d", http.MethodPut, got, http.StatusOK)
+	}
+	if got := f.do(http.MethodGet, path, f.bobJWT); got != http.StatusOK {
+		t.Errorf("owner read = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestCanAccessOrganizationNote(t *testing.T) {
+	f := newAccessFixture(t)
+	alice := auth.Principal{UserID: f.alice.ID, Username: f.alice.Username}
+	member := func(role string) *models.Membership {
+		return &models.Membership{OrgID: f.org.ID, UserID: f.alice.ID, Role: role}
+	}
+
+	tests := []struct {
+		name       string
+		membership *models.Membership
+		want       map[auth.Action]bool
+	}{
+		{"no membership", nil, nil},
+		{"viewer", member(models.OrgRoleViewer), map[auth.Action]bool{auth.ActionRead: true}},
+		{"editor", member(models.OrgRoleEditor), map[auth.Action]bool{auth.ActionRead: true, auth.ActionUpdate: true, auth.ActionDelete: true}},
+		{"owner", member(models.OrgRoleOwner), map[auth.Action]bool{auth.ActionRead: true, auth.ActionUpdate: true, auth.ActionDelete: true}},
+		{"unknown role", member("admin"), nil},
+		{"membership of another organization", &models.Membership{OrgID: f.org.ID + 1, UserID: f.alice.ID, Role: models.OrgRoleOwner}, nil},
+		{"membership of another user", &models.Membership{OrgID: f.org.ID, UserID: f.bob.ID, Role: models.OrgRoleOwner}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, action := range []auth.Action{auth.ActionRead, auth.ActionUpdate, auth.ActionDelete, "share"} {
+				if got := auth.CanAccessNote(alice, f.orgNote, tt.membership, action); got != tt.want[action] {
+					t.Errorf("CanAccessNote(%s) = %v, want %v", action, got, tt.want[action])
+				}
+			}
+		})
+	}
+
+	// a membership never opens a personal note, even of its own user
+	own := models.Note{ID: f.bobNote.ID, UserID: &f.alice.ID}
+	if auth.CanAccessNote(alice, own, member(models.OrgRoleOwner), auth.ActionRead) {
+		t.Error("CanAccessNote() of a personal note with a membership = true, want false")
+	}
+	if !auth.CanAccessNote(alice, own, nil, auth.ActionRead) {
+		t.Error("CanAccessNote() of an own personal note = false, want true")
+	}
+}
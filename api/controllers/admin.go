@@ -0,0 +1,345 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+const (
+	// maxAdminPageSize is the maximum number of users listed at once
+	maxAdminPageSize = 200
+	// maxLevel is the highest user level
+	maxLevel = 4
+	// maxSignupDays is the longest period of recent signups listed
+	maxSignupDays = 90
+)
+
+// Admin is a controller for user management by admins
+type Admin interface {
+	// ListUsers lists and searches users
+	ListUsers(ctx *gin.Context)
+	// ViewUser returns a user with its storage usage
+	ViewUser(ctx *gin.Context)
+	// SuspendUser suspends a user
+	SuspendUser(ctx *gin.Context)
+	// UnsuspendUser lifts the suspension of a user
+	UnsuspendUser(ctx *gin.Context)
+	// UpdateUser changes the role and the level of a user
+	UpdateUser(ctx *gin.Context)
+	// ForceReset requires a user to reset the password
+	ForceReset(ctx *gin.Context)
+	// Storage returns the storage used by each user
+	Storage(ctx *gin.Context)
+	// Signups returns the recent signups
+	Signups(ctx *gin.Context)
+}
+
+// admin is a controller for user management by admins
+type admin struct {
+	userRepo  repository.UserRepo
+	noteRepo  repository.NoteRepo
+	tokenRepo repository.TokenRepo
+	mailer    mailer.Mailer
+}
+
+// ListUsers lists and searches users
+func (a *admin) ListUsers(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.ListUsers")
+	defer span.End()
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	users, total, err := a.userRepo.SearchUsers(ctx, strings.TrimSpace(ctx.Query("q")), offset, limit)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		list = append(list, adminUserInfo(user))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"users":  list,
+		"total":  total,
+	})
+}
+
+// ViewUser returns a user with its storage usage
+func (a *admin) ViewUser(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.ViewUser")
+	defer span.End()
+	user, ok := a.targetUser(ctx)
+	if !ok {
+		return
+	}
+	usage, err := a.noteRepo.UsageByUserName(ctx, user.Username)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"user":   adminUserInfo(user),
+		"usage":  usage,
+	})
+}
+
+// SuspendUser suspends a user
+func (a *admin) SuspendUser(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.SuspendUser")
+	defer span.End()
+	user, ok := a.targetUser(ctx)
+	if !ok || !notSelf(ctx, user) {
+		return
+	}
+	if !user.SuspendedAt.Valid {
+		user.SuspendedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		// the user is signed out, and stays so when the suspension is lifted
+		user.SessionsRevokedAt = user.SuspendedAt
+		if !a.save(ctx, &user) {
+			return
+		}
+	}
+	logger.Ctx(ctx).Info().Msgf("admin: %s suspended %s", adminName(ctx), user.Username)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "user suspended",
+		"user":    adminUserInfo(user),
+	})
+}
+
+// UnsuspendUser lifts the suspension of a user
+func (a *admin) UnsuspendUser(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.UnsuspendUser")
+	defer span.End()
+	user, ok := a.targetUser(ctx)
+	if !ok {
+		return
+	}
+	if user.SuspendedAt.Valid {
+		user.SuspendedAt = sql.NullTime{}
+		if !a.save(ctx, &user) {
+			return
+		}
+	}
+	logger.Ctx(ctx).Info().Msgf("admin: %s unsuspended %s", adminName(ctx), user.Username)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "user unsuspended",
+		"user":    adminUserInfo(user),
+	})
+}
+
+// UpdateUser changes the role and the level of a user
+func (a *admin) UpdateUser(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.UpdateUser")
+	defer span.End()
+	var body struct {
+		Role  *string `json:"role"`
+		Level *int    `json:"level"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	user, ok := a.targetUser(ctx)
+	if !ok || !notSelf(ctx, user) {
+		return
+	}
+
+	if body.Role != nil {
+		if *body.Role != "user" && *body.Role != "admin" {
+			apierror.BadRequest(ctx, "role should be user or admin")
+			return
+		}
+		user.Role = *body.Role
+	}
+	if body.Level != nil {
+		if *body.Level < 1 || *body.Level > maxLevel {
+			apierror.BadRequest(ctx, "level should be between 1 and "+strconv.Itoa(maxLevel))
+			return
+		}
+		user.Level = *body.Level
+	}
+	if !a.save(ctx, &user) {
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("admin: %s set role %s and level %d of %s", adminName(ctx), user.Role, user.Level, user.Username)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "user updated",
+		"user":    adminUserInfo(user),
+	})
+}
+
+// ForceReset requires a user to reset the password and mails a reset link
+func (a *admin) ForceReset(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.ForceReset")
+	defer span.End()
+	user, ok := a.targetUser(ctx)
+	if !ok {
+		return
+	}
+	user.ResetRequired = true
+	// the sessions opened with the old password are signed out
+	user.SessionsRevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if !a.save(ctx, &user) {
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("admin: %s forced a password reset of %s", adminName(ctx), user.Username)
+
+	message := "password reset required"
+	if user.Email != "" {
+		err := sendUserToken(ctx, a.tokenRepo, a.mailer, user, models.TokenPurposeReset)
+		if err != nil {
+			logger.Ctx(ctx).Error().Err(err).Msg("failed to send password reset email")
+		} else {
+			message = "password reset required, a reset link has been sent"
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": message,
+		"user":    adminUserInfo(user),
+	})
+}
+
+// Storage returns the storage used by each user, largest first, with the total
+func (a *admin) Storage(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.Storage")
+	defer span.End()
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxAdminPageSize {
+		limit = maxAdminPageSize
+	}
+	usage, err := a.noteRepo.UsageByUser(ctx, limit)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	total, err := a.noteRepo.UsageByUser(ctx, -1)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	var sum models.Usage
+	for _, u := range total {
+		sum.Notes += u.Notes
+		sum.Bytes += u.Bytes
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"usage":   sum,
+		"storage": usage,
+	})
+}
+
+// Signups returns the users who signed up in the last days
+func (a *admin) Signups(ctx *gin.Context) {
+	span := startSpan(ctx, "admin.Signups")
+	defer span.End()
+	days, err := strconv.Atoi(ctx.DefaultQuery("days", "7"))
+	if err != nil || days <= 0 || days > maxSignupDays {
+		days = maxSignupDays
+	}
+	users, err := a.userRepo.RecentUsers(ctx, time.Now().AddDate(0, 0, -days), maxAdminPageSize)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	list := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		list = append(list, adminUserInfo(user))
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"users":  list,
+		"total":  len(list),
+	})
+}
+
+// targetUser returns the user named in the path
+func (a *admin) targetUser(ctx *gin.Context) (models.User, bool) {
+	username := strings.ToLower(strings.TrimSpace(ctx.Param("username")))
+	user, err := a.userRepo.GetUserByUsername(ctx, username)
+	if err != nil || user.DeletedAt.Valid {
+		apierror.NotFound(ctx, "user not found")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// save updates the user
+func (a *admin) save(ctx *gin.Context, user *models.User) bool {
+	err := a.userRepo.UpdateUser(ctx, user)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return false
+	}
+	return true
+}
+
+// notSelf prevents admins from locking themselves out
+func notSelf(ctx *gin.Context, user models.User) bool {
+	principal, _ := auth.PrincipalFrom(ctx)
+	if user.ID == principal.UserID {
+		apierror.BadRequest(ctx, "you can't change your own account")
+		return false
+	}
+	return true
+}
+
+// adminName returns the username of the admin set by the admin middleware
+func adminName(ctx *gin.Context) string {
+	principal, _ := auth.PrincipalFrom(ctx)
+	return principal.Username
+}
+
+// adminUserInfo returns the user details shown to admins
+func adminUserInfo(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              user.ID,
+		"username":        user.Username,
+		"email":           user.Email,
+		"email_verified":  user.EmailVerified,
+		"first_name":      user.FirstName,
+		"middle_name":     user.MiddleName,
+		"last_name":       user.LastName,
+		"role":            user.Role,
+		"level":           user.Level,
+		"suspended_at":    user.SuspendedAt,
+		"deletion_due_at": user.DeletionDueAt,
+		"reset_required":  user.ResetRequired,
+		"created_at":      user.CreatedAt,
+		"updated_at":      user.UpdatedAt,
+	}
+}
+
+// NewAdmin initializes the admin controller
+func NewAdmin(userRepo repository.UserRepo, noteRepo repository.NoteRepo, tokenRepo repository.TokenRepo, mailer mailer.Mailer) Admin {
+	return &admin{
+		userRepo:  userRepo,
+		noteRepo:  noteRepo,
+		tokenRepo: tokenRepo,
+		mailer:    mailer,
+	}
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/middleware"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// adminFixture has an admin and bob, whose session was opened before the
+// admin acts on his account
+type adminFixture struct {
+	router   *gin.Engine
+	adminJWT string
+	bobJWT   string
+}
+
+func newAdminFixture(t *testing.T) *adminFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	err := auth.Init(config.Auth{JWTSecret: "admin-test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := repository.NewUserRepo(db)
+	noteRepo := repository.NewNoteRepo(db)
+	middleware.UseUsers(userRepo)
+	t.Cleanup(func() { middleware.UseUsers(nil) })
+
+	root := models.User{Username: "root", Email: "root@example.com", Role: "admin", Level: 1}
+	bob := models.User{Username: "bob", Email: "bob@example.com", Role: "user", Level: 1}
+	for _, user := range []*models.User{&root, &bob} {
+		err := userRepo.CreateUser(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := NewAdmin(userRepo, noteRepo, repository.NewTokenRepo(db), mailer.NewMailer(config.Mail{}))
+	notes := NewNote(noteRepo)
+	f := &adminFixture{
+		adminJWT: signTestToken(t, root),
+		bobJWT:   signTokenIssuedAt(t, bob, time.Now().Add(-time.Minute)),
+	}
+	f.router = gin.New()
+	admins := f.router.Group("/admin", middleware.JWTAuthAdmin())
+	admins.POST("/users/:username/suspend", admin.SuspendUser)
+	admins.POST("/users/:username/unsuspend", admin.UnsuspendUser)
+	admins.POST("/users/:username/reset", admin.ForceReset)
+	f.router.GET("/api/notes", middleware.JWTAuth(), notes.ReadAll)
+	return f
+}
+
+// signTokenIssuedAt signs a token of the user issued at the given time
+func signTokenIssuedAt(t *testing.T, user models.User, issuedAt time.Time) string {
+	t.Helper()
+	token, err := auth.SignToken(&models.Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Level:    user.Level,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(5 * time.Minute)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// do sends a request with the token and returns the status
+func (f *adminFixture) do(method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestSuspendRefusesExistingTokens(t *testing.T) {
+	f := newAdminFixture(t)
+	if got := f.do(http.MethodGet, "/api/notes", f.bobJWT); got != http.StatusOK {
+		t.Fatalf("notes before the suspension = %d, want %d", got, http.StatusOK)
+	}
+
+	if got := f.do(http.MethodPost, "/admin/users/bob/suspend", f.adminJWT); got != http.StatusOK {
+		t.Fatalf("suspend = %d, want %d", got, http.StatusOK)
+	}
+	if got := f.do(http.MethodGet, "/api/notes", f.bobJWT); got != http.StatusUnauthorized {
+		t.Errorf("notes of the suspended user = %d, want %d", got, http.StatusUnauthorized)
+	}
+
+	// lifting the suspension doesn't bring the old sessions back
+	if got := f.do(http.MethodPost, "/admin/users/bob/unsuspend", f.adminJWT); got != http.StatusOK {
+		t.Fatalf("unsuspend = %d, want %d", got, http.StatusOK)
+	}
+	if got := f.do(http.MethodGet, "/api/notes", f.bobJWT); got != http.StatusUnauthorized {
+		t.Errorf("notes with a token from before the suspension = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestForceResetRefusesExistingTokens(t *testing.T) {
+	f := newAdminFixture(t)
+	if got := f.do(http.MethodPost, "/admin/users/bob/reset", f.adminJWT); got != http.StatusOK {
+		t.Fatalf("reset = %d, want %d", got, http.StatusOK)
+	}
+	if got := f.do(http.MethodGet, "/api/notes", f.bobJWT); got != http.StatusUnauthorized {
+		t.Errorf("notes with a token from before the reset = %d, want %d", got, http.StatusUnauthorized)
+	}
+	// the admin is left signed in
+	if got := f.do(http.MethodPost, "/admin/users/bob/unsuspend", f.adminJWT); got != http.StatusOK {
+		t.Errorf("admin request after the reset = %d, want %d", got, http.StatusOK)
+	}
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/logger"
+)
+
+var (
+	// appURL is the public URL of the server used in links, no link is
+	// mailed when empty
+	appURL string
+	// setupToken creates the first admin, generated when empty
+	setupToken string
+)
+
+// Configure sets the account, registration and sign in settings of the
+// controllers
+func Configure(cfg *config.Config) {
+	appURL = cfg.Server.AppURL
+	setupToken = cfg.Auth.SetupToken
+	requireVerifiedEmail = cfg.Auth.RequireEmailVerification
+	deletionGracePeriod = cfg.Auth.DeletionGracePeriod
+	registrationMode = cfg.Auth.RegistrationMode
+	ssoAllowedDomains = cfg.OIDC.AllowedDomains
+	ssoRedirectURL = cfg.OIDC.RedirectURL
+	if appURL == "" {
+		logger.Base().Warn().Msg("APP_URL is not set, the password reset and email verification links won't be mailed")
+	}
+}
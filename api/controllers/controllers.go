@@ -1,17 +1,17 @@
 package controllers
 
 import (
-	"encoding/json"
-	"io/ioutil"
-	"log"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/metrics"
 	"github.com/mrinjamul/gnote/models"
 	"github.com/mrinjamul/gnote/repository"
-	"github.com/mrinjamul/gnote/utils"
 )
 
 type Note interface {
@@ -29,50 +29,32 @@ type note struct {
 
 // Create creates a new note
 func (n *note) Create(ctx *gin.Context) {
-	bytes, err := ioutil.ReadAll(ctx.Request.Body)
-	if err != nil {
-		log.Fatal(err)
+	span := startSpan(ctx, "note.Create")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
+		return
 	}
 	var note models.Note
-	err = json.Unmarshal(bytes, &note)
-	if err != nil {
-		log.Fatal(err)
-	}
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
+	err := ctx.ShouldBindJSON(&note)
 	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+		logger.Ctx(ctx).Debug().Err(err).Msg("invalid note")
+		apierror.BadRequest(ctx, "bad request")
 		return
 	}
 
-	note.Username = claims.Username
+	note.ID = 0
+	note.UserID = &principal.UserID
+	note.Username = principal.Username
+	// notes of organizations are created with their own endpoint
+	note.OrgID = nil
 
 	err = n.noteRepo.Create(ctx, &note)
 	if err != nil {
-		ctx.JSON(200, gin.H{
-			"message": err,
-			"note":    note,
-		})
+		apierror.FromRepository(ctx, err)
+		return
 	}
+	metrics.NotesCreated.Inc()
 	ctx.JSON(200, gin.H{
 		"message": "success",
 		"note":    note,
@@ -81,50 +63,15 @@ func (n *note) Create(ctx *gin.Context) {
 
 // Read reads a note
 func (n *note) Read(ctx *gin.Context) {
-	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+	span := startSpan(ctx, "note.Read")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
 		return
 	}
-
-	note := models.Note{
-		ID:       uint64(id),
-		Username: claims.Username,
-	}
-
-	err = n.noteRepo.Read(ctx, &note)
-	if err != nil {
-		ctx.JSON(
-			http.StatusOK,
-			gin.H{
-				"message": err,
-			},
-		)
+	note, ok := n.visibleNote(ctx, principal, auth.ActionRead)
+	if !ok {
+		return
 	}
 	ctx.JSON(
 		http.StatusOK,
@@ -137,41 +84,16 @@ func (n *note) Read(ctx *gin.Context) {
 
 // ReadAll reads all notes
 func (n *note) ReadAll(ctx *gin.Context) {
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+	span := startSpan(ctx, "note.ReadAll")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
 		return
 	}
-
-	notes, err := n.noteRepo.ReadByUserName(ctx, claims.Username)
+	notes, err := n.noteRepo.ReadByUser(ctx, principal.UserID)
 	if err != nil {
-		ctx.JSON(
-			http.StatusOK,
-			gin.H{
-				"message": err,
-				"notes":   "",
-			},
-		)
+		apierror.FromRepository(ctx, err)
+		return
 	}
 	ctx.JSON(
 		http.StatusOK,
@@ -184,30 +106,24 @@ func (n *note) ReadAll(ctx *gin.Context) {
 
 // Update updates a note
 func (n *note) Update(ctx *gin.Context) {
-	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	bytes, err := ioutil.ReadAll(ctx.Request.Body)
-	if err != nil {
-		log.Fatal(err)
+	span := startSpan(ctx, "note.Update")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
+		return
 	}
-
 	var note models.Note
-	err = json.Unmarshal(bytes, &note)
+	err := ctx.ShouldBindJSON(&note)
 	if err != nil {
-		log.Fatal(err)
+		logger.Ctx(ctx).Debug().Err(err).Msg("invalid note")
+		apierror.BadRequest(ctx, "bad request")
+		return
 	}
 
-	// Get existing note
-	existingNote := models.Note{
-		ID: uint64(id),
-	}
-	// get existing note
-	err = n.noteRepo.Read(ctx, &existingNote)
-	if err != nil {
-		log.Fatal(err)
+	// notes of organizations are updated with their own endpoint
+	existingNote, ok := n.visibleNote(ctx, principal, auth.ActionUpdate)
+	if !ok {
+		return
 	}
 
 	if note.Title != "" {
@@ -216,61 +132,14 @@ func (n *note) Update(ctx *gin.Context) {
 	if note.Content != "" {
 		existingNote.Content = note.Content
 	}
-	if note.Username != "" {
-		existingNote.Username = note.Username
-	}
 	if note.Archived {
 		existingNote.Archived = note.Archived
 	}
 
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
-		return
-	}
-
-	note.ID = uint64(id)
-
-	// if username is not same as login
-	if claims.Username != existingNote.Username {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error":     "you are not the owner of this note",
-			"note":      note,
-			"orig_note": existingNote,
-		})
-		ctx.Abort()
-		return
-	}
-
 	note, err = n.noteRepo.Update(ctx, existingNote)
 	if err != nil {
-		ctx.JSON(
-			http.StatusOK,
-			gin.H{
-				"message": err,
-				"note":    note,
-			},
-		)
+		apierror.FromRepository(ctx, err)
+		return
 	}
 	ctx.JSON(
 		http.StatusOK,
@@ -283,51 +152,21 @@ func (n *note) Update(ctx *gin.Context) {
 
 // Delete deletes a note
 func (n *note) Delete(ctx *gin.Context) {
-	id, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+	span := startSpan(ctx, "note.Delete")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
 		return
 	}
-
-	note := models.Note{
-		ID:       uint64(id),
-		Username: claims.Username,
+	note, ok := n.visibleNote(ctx, principal, auth.ActionDelete)
+	if !ok {
+		return
 	}
 
-	err = n.noteRepo.Delete(ctx, &note)
+	err := n.noteRepo.Delete(ctx, &note)
 	if err != nil {
-		ctx.JSON(
-			http.StatusOK,
-			gin.H{
-				"message": err,
-				"note":    note,
-			},
-		)
+		apierror.FromRepository(ctx, err)
+		return
 	}
 
 	ctx.JSON(
@@ -341,66 +180,34 @@ func (n *note) Delete(ctx *gin.Context) {
 
 // DeleteByUsername deletes all notes by username
 func (n *note) DeleteByUsername(ctx *gin.Context) {
-	var user map[string]string
-	err := ctx.BindJSON(&user)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "internal server error",
-		})
-		ctx.Abort()
+	span := startSpan(ctx, "note.DeleteByUsername")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
 		return
 	}
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
+	var user map[string]string
+	err := ctx.ShouldBindJSON(&user)
 	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "bad request")
 		return
 	}
 
-	valid, err := n.noteRepo.VerifyPassword(ctx, claims.Username, user["password"])
+	valid, err := n.noteRepo.VerifyPassword(ctx, principal.Username, user["password"])
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "internal server error",
-		})
-		ctx.Abort()
+		apierror.Internal(ctx, err)
 		return
 	}
 
 	if !valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid password",
-		})
-		ctx.Abort()
+		apierror.Unauthorized(ctx, "invalid password")
 		return
 	}
 
-	err = n.noteRepo.DeleteAllByUserName(ctx, claims.Username)
+	err = n.noteRepo.DeleteAllByUser(ctx, principal.UserID)
 	if err != nil {
-		ctx.JSON(
-			http.StatusOK,
-			gin.H{
-				"status":  "error",
-				"message": err,
-			})
+		apierror.FromRepository(ctx, err)
+		return
 	}
 	ctx.JSON(
 		http.StatusOK,
@@ -410,6 +217,39 @@ func (n *note) DeleteByUsername(ctx *gin.Context) {
 		})
 }
 
+// visibleNote returns the personal note with the id in the path when the
+// policy allows the action, other notes are answered as not found
+func (n *note) visibleNote(ctx *gin.Context, principal auth.Principal, action auth.Action) (models.Note, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Abort(ctx, http.StatusNotFound, apierror.CodeNotFound, "note not found", gin.H{
+			"id": "not a note id",
+		})
+		return models.Note{}, false
+	}
+	note := models.Note{ID: id}
+	err = n.noteRepo.Read(ctx, &note)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		apierror.Internal(ctx, err)
+		return models.Note{}, false
+	}
+	if err != nil || !auth.CanAccessNote(principal, note, nil, action) {
+		apierror.NotFound(ctx, "note not found")
+		return models.Note{}, false
+	}
+	return note, true
+}
+
+// caller returns the principal set by the auth middleware
+func caller(ctx *gin.Context) (auth.Principal, bool) {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		apierror.Unauthorized(ctx, "invalid token")
+		return auth.Principal{}, false
+	}
+	return principal, true
+}
+
 // NewNote initializes note
 func NewNote(noteRepo repository.NoteRepo) Note {
 	return &note{
@@ -6,7 +6,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/health"
 )
 
 const (
@@ -75,25 +75,37 @@ func NewCheck(status string, failures map[string]string, startTime time.Time, bo
 
 type HealthCheck interface {
 	HealthCheck(ctx *gin.Context, startTime time.Time, bootTime time.Duration)
+	Livez(ctx *gin.Context, startTime time.Time)
+	Readyz(ctx *gin.Context)
 }
 
 type healthCheck struct {
+	checker *health.Checker
 }
 
 // Health Check
 func (m *healthCheck) HealthCheck(ctx *gin.Context, startTime time.Time, bootTime time.Duration) {
-	status := StatusUnavailable
-	failures := make(map[string]string)
+	report := m.checker.Run(ctx.Request.Context())
 
-	if database.IsConnected {
-		status = StatusOK
-	} else {
+	code := http.StatusOK
+	status := StatusOK
+	failures := make(map[string]string)
+	for name, result := range report.Checks {
+		if !result.OK {
+			failures[name] = result.Error
+		}
+	}
+	// answered as the readiness probe, monitors only reading the status
+	// code see the outage
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+		status = StatusUnavailable
+	} else if report.Degraded {
 		status = StatusPartiallyAvailable
-		failures["postgres"] = "failed during postgresql health check"
 	}
 
 	ctx.Header("Cache-Control", "no-cache, no-store, must-revalidate")
-	ctx.JSON(http.StatusOK, NewCheck(
+	ctx.JSON(code, NewCheck(
 		status,
 		failures,
 		startTime,
@@ -101,6 +113,40 @@ func (m *healthCheck) HealthCheck(ctx *gin.Context, startTime time.Time, bootTim
 	))
 }
 
-func NewHealthCheck() HealthCheck {
-	return &healthCheck{}
+// Livez reports that the process is up, without checking its dependencies
+func (m *healthCheck) Livez(ctx *gin.Context, startTime time.Time) {
+	ctx.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": StatusOK,
+		"uptime": time.Since(startTime).String(),
+	})
+}
+
+// Readyz runs the checks of the dependencies, a failed critical check makes
+// the server unavailable
+func (m *healthCheck) Readyz(ctx *gin.Context) {
+	report := m.checker.Run(ctx.Request.Context())
+
+	code := http.StatusOK
+	status := StatusOK
+	if !report.Ready {
+		code = http.StatusServiceUnavailable
+		status = StatusUnavailable
+	} else if report.Degraded {
+		status = StatusPartiallyAvailable
+	}
+
+	ctx.Header("Cache-Control", "no-cache, no-store, must-revalidate")
+	ctx.JSON(code, gin.H{
+		"status": status,
+		"checks": report.Checks,
+	})
+}
+
+// NewHealthCheck initializes the health checks on the checks registered by
+// the subsystems
+func NewHealthCheck(checker *health.Checker) HealthCheck {
+	return &healthCheck{
+		checker: checker,
+	}
 }
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/health"
+)
+
+func TestHealthStatusFollowsReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	failing := func(ctx context.Context) error { return errors.New("unreachable") }
+	passing := func(ctx context.Context) error { return nil }
+
+	tests := []struct {
+		name     string
+		checks   []health.Check
+		code     int
+		status   string
+		failures int
+	}{
+		{"ready", []health.Check{{Name: "database", Critical: true, Run: passing}}, http.StatusOK, StatusOK, 0},
+		{"degraded", []health.Check{
+			{Name: "database", Critical: true, Run: passing},
+			{Name: "mailer", Run: failing},
+		}, http.StatusOK, StatusPartiallyAvailable, 1},
+		{"not ready", []health.Check{
+			{Name: "database", Critical: true, Run: failing},
+			{Name: "mailer", Run: passing},
+		}, http.StatusServiceUnavailable, StatusUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker()
+			for _, check := range tt.checks {
+				checker.Register(check)
+			}
+			h := NewHealthCheck(checker)
+			router := gin.New()
+			router.GET("/api/health", func(ctx *gin.Context) {
+				h.HealthCheck(ctx, time.Now(), time.Second)
+			})
+			router.GET("/readyz", h.Readyz)
+
+			for _, path := range []string{"/api/health", "/readyz"} {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				var body struct {
+					Status   string            `json:"status"`
+					Failures map[string]string `json:"failures"`
+				}
+				err := json.Unmarshal(rec.Body.Bytes(), &body)
+				if err != nil || rec.Code != tt.code || body.Status != tt.status {
+					t.Errorf("%s = %d %q, %v, want %d %q", path, rec.Code, body.Status, err, tt.code, tt.status)
+				}
+				if path == "/api/health" && len(body.Failures) != tt.failures {
+					t.Errorf("%s failures = %v, want %d", path, body.Failures, tt.failures)
+				}
+			}
+		})
+	}
+}
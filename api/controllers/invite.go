@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+const (
+	// RegistrationOpen lets anyone sign up
+	RegistrationOpen = "open"
+	// RegistrationInviteOnly requires an invite code to sign up
+	RegistrationInviteOnly = "invite-only"
+	// RegistrationClosed disables sign up
+	RegistrationClosed = "closed"
+)
+
+var (
+	// registrationMode is one of the Registration modes
+	registrationMode = RegistrationOpen
+)
+
+var (
+	errRegistrationClosed = refusal("registration is closed")
+	errInviteRequired     = refusal("an invite code is required")
+	errInvalidInvite      = refusal("invalid invite code")
+)
+
+// registrationInvite checks if a new account can be created in the
+// registration mode, whatever the way of signing up, and returns the invite
+// of the code when one is given
+func registrationInvite(ctx context.Context, inviteRepo repository.InviteRepo, code string) (models.Invite, error) {
+	if registrationMode == RegistrationClosed {
+		return models.Invite{}, errRegistrationClosed
+	}
+	if code == "" {
+		if registrationMode == RegistrationInviteOnly {
+			return models.Invite{}, errInviteRequired
+		}
+		return models.Invite{}, nil
+	}
+	invite, err := inviteRepo.GetInvite(ctx, utils.HashToken(utils.NormalizeInviteCode(code)))
+	if err != nil {
+		return models.Invite{}, errInvalidInvite
+	}
+	return invite, nil
+}
+
+// Invites is a controller for invite codes
+type Invites interface {
+	// Registration returns the registration mode
+	Registration(ctx *gin.Context)
+	// Create creates an invite code
+	Create(ctx *gin.Context)
+	// List lists the invite codes
+	List(ctx *gin.Context)
+	// Revoke revokes an invite code
+	Revoke(ctx *gin.Context)
+}
+
+// invites is a controller for invite codes
+type invites struct {
+	inviteRepo repository.InviteRepo
+}
+
+// applyInvite gives a new user the level of its invite. Only the user role
+// is given, the admin invites created before it was refused grant no more.
+func applyInvite(user *models.User, invite models.Invite) {
+	if invite.Role == "user" {
+		user.Role = invite.Role
+	}
+	user.Level = invite.Level
+}
+
+// Registration returns the registration mode, used by the sign up page
+func (i *invites) Registration(ctx *gin.Context) {
+	span := startSpan(ctx, "invites.Registration")
+	defer span.End()
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"mode":   registrationMode,
+	})
+}
+
+// Create creates an invite code, the code is only returned once
+func (i *invites) Create(ctx *gin.Context) {
+	span := startSpan(ctx, "invites.Create")
+	defer span.End()
+	var body struct {
+		MaxUses   int    `json:"max_uses"`
+		ExpiresIn string `json:"expires_in"`
+		Role      string `json:"role"`
+		Level     int    `json:"level"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+
+	invite := models.Invite{
+		Role:      body.Role,
+		Level:     body.Level,
+		MaxUses:   body.MaxUses,
+		CreatedBy: adminName(ctx),
+	}
+	if invite.Role == "" {
+		invite.Role = "user"
+	}
+	if invite.Level == 0 {
+		invite.Level = 1
+	}
+	// an invite is no way to become admin, admins are promoted explicitly
+	if invite.Role != "user" {
+		apierror.BadRequest(ctx, "role should be user, admins are promoted with the admin users endpoint")
+		return
+	}
+	if invite.Level < 1 || invite.Level > maxLevel {
+		apierror.BadRequest(ctx, "level should be between 1 and "+strconv.Itoa(maxLevel))
+		return
+	}
+	if invite.MaxUses < 0 {
+		apierror.BadRequest(ctx, "max uses should be positive, or 0 for unlimited")
+		return
+	}
+	if body.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(body.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			apierror.BadRequest(ctx, "invalid expiry, use a duration like 72h")
+			return
+		}
+		invite.ExpiresAt = sql.NullTime{Time: time.Now().Add(expiresIn), Valid: true}
+	}
+
+	code, err := utils.GenerateInviteCode()
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	invite.Hash = utils.HashToken(utils.NormalizeInviteCode(code))
+	invite.Hint = code[:4]
+	err = i.inviteRepo.CreateInvite(ctx, &invite)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("admin: %s created invite %d", invite.CreatedBy, invite.ID)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"code":   code,
+		"invite": invite,
+	})
+}
+
+// List lists the invite codes, newest first
+func (i *invites) List(ctx *gin.Context) {
+	span := startSpan(ctx, "invites.List")
+	defer span.End()
+	list, err := i.inviteRepo.GetInvites(ctx)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"invites": list,
+	})
+}
+
+// Revoke revokes an invite code
+func (i *invites) Revoke(ctx *gin.Context) {
+	span := startSpan(ctx, "invites.Revoke")
+	defer span.End()
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		apierror.BadRequest(ctx, "invalid invite id")
+		return
+	}
+	err = i.inviteRepo.RevokeInvite(ctx, uint(id))
+	if err != nil {
+		apierror.NotFound(ctx, "invite not found")
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("admin: %s revoked invite %d", adminName(ctx), id)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "invite revoked",
+	})
+}
+
+// NewInvites initializes the invites controller
+func NewInvites(inviteRepo repository.InviteRepo) Invites {
+	return &invites{
+		inviteRepo: inviteRepo,
+	}
+}
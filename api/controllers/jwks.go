@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/auth"
+)
+
+// Keys is a controller for the public signing keys
+type Keys interface {
+	// JWKS returns the public keys used to verify tokens
+	JWKS(ctx *gin.Context)
+}
+
+type keys struct {
+}
+
+// JWKS returns the public keys used to verify tokens
+func (k *keys) JWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, auth.PublicKeys())
+}
+
+// NewKeys initializes the keys controller
+func NewKeys() Keys {
+	return &keys{}
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Metrics interface {
+	Metrics(ctx *gin.Context)
+}
+
+type metricsEndpoint struct {
+	token   string
+	handler http.Handler
+}
+
+// Metrics serves the metrics in the Prometheus text format, to the holders
+// of the token when one is configured
+func (m *metricsEndpoint) Metrics(ctx *gin.Context) {
+	if m.token != "" {
+		token := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.token)) != 1 {
+			ctx.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			apierror.Unauthorized(ctx, "invalid metrics token")
+			return
+		}
+	}
+	m.handler.ServeHTTP(ctx.Writer, ctx.Request)
+}
+
+// NewMetrics initializes the metrics endpoint and registers the runtime
+// metrics of the health check
+func NewMetrics(cfg config.Metrics) Metrics {
+	metrics.MustRegister(systemCollector{})
+	return &metricsEndpoint{
+		token:   cfg.Token,
+		handler: metrics.Handler(),
+	}
+}
+
+var (
+	goInfoDesc = prometheus.NewDesc("gnote_go_info",
+		"Version of Go of the server.", []string{"version"}, nil)
+	goroutinesDesc = prometheus.NewDesc("gnote_go_goroutines",
+		"Number of goroutines.", nil, nil)
+	totalAllocDesc = prometheus.NewDesc("gnote_go_total_alloc_bytes_total",
+		"Total bytes allocated.", nil, nil)
+	heapObjectsDesc = prometheus.NewDesc("gnote_go_heap_objects",
+		"Number of objects in the heap.", nil, nil)
+	allocDesc = prometheus.NewDesc("gnote_go_alloc_bytes",
+		"Bytes allocated and not yet freed.", nil, nil)
+)
+
+// systemCollector exposes the runtime statistics of NewSystemMetrics, read
+// once per scrape
+type systemCollector struct{}
+
+func (systemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- goInfoDesc
+	ch <- goroutinesDesc
+	ch <- totalAllocDesc
+	ch <- heapObjectsDesc
+	ch <- allocDesc
+}
+
+func (systemCollector) Collect(ch chan<- prometheus.Metric) {
+	s := NewSystemMetrics()
+	ch <- prometheus.MustNewConstMetric(goInfoDesc, prometheus.GaugeValue, 1, s.Version)
+	ch <- prometheus.MustNewConstMetric(goroutinesDesc, prometheus.GaugeValue, float64(s.GoroutinesCount))
+	ch <- prometheus.MustNewConstMetric(totalAllocDesc, prometheus.CounterValue, float64(s.TotalAllocBytes))
+	ch <- prometheus.MustNewConstMetric(heapObjectsDesc, prometheus.GaugeValue, float64(s.HeapObjectsCount))
+	ch <- prometheus.MustNewConstMetric(allocDesc, prometheus.GaugeValue, float64(s.AllocBytes))
+}
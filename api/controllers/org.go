@@ -0,0 +1,627 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/metrics"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+const (
+	// orgInviteTTL is the validity of an organization invite
+	orgInviteTTL = 7 * 24 * time.Hour
+)
+
+// Org is a controller for organizations and their notes
+type Org interface {
+	// Create creates an organization
+	Create(ctx *gin.Context)
+	// List lists the organizations of the user
+	List(ctx *gin.Context)
+	// Delete deletes an organization
+	Delete(ctx *gin.Context)
+	// Members lists the members of an organization
+	Members(ctx *gin.Context)
+	// UpdateMember changes the role of a member
+	UpdateMember(ctx *gin.Context)
+	// RemoveMember removes a member, members can remove themselves
+	RemoveMember(ctx *gin.Context)
+	// Invite invites to join an organization
+	Invite(ctx *gin.Context)
+	// Invites lists the pending invites
+	Invites(ctx *gin.Context)
+	// RevokeInvite revokes a pending invite
+	RevokeInvite(ctx *gin.Context)
+	// Join accepts an invite
+	Join(ctx *gin.Context)
+	// ReadNotes reads all notes of an organization
+	ReadNotes(ctx *gin.Context)
+	// CreateNote creates a note in an organization
+	CreateNote(ctx *gin.Context)
+	// ReadNote reads a note of an organization
+	ReadNote(ctx *gin.Context)
+	// UpdateNote updates a note of an organization
+	UpdateNote(ctx *gin.Context)
+	// DeleteNote deletes a note of an organization
+	DeleteNote(ctx *gin.Context)
+}
+
+// org is a controller for organizations and their notes
+type org struct {
+	orgRepo  repository.OrgRepo
+	noteRepo repository.NoteRepo
+	userRepo repository.UserRepo
+	mailer   mailer.Mailer
+}
+
+// Create creates an organization owned by the user
+func (o *org) Create(ctx *gin.Context) {
+	span := startSpan(ctx, "org.Create")
+	defer span.End()
+	var body struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"display_name"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	user, ok := o.caller(ctx)
+	if !ok {
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(body.Name))
+	if !utils.IsValidUserName(name) {
+		apierror.BadRequest(ctx, "invalid organization name")
+		return
+	}
+
+	organization := models.Organization{
+		Name:        name,
+		DisplayName: strings.TrimSpace(body.DisplayName),
+	}
+	if organization.DisplayName == "" {
+		organization.DisplayName = name
+	}
+	err = o.orgRepo.CreateOrg(ctx, &organization, user.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"org":    organization,
+	})
+}
+
+// List lists the organizations of the user with the user's role
+func (o *org) List(ctx *gin.Context) {
+	span := startSpan(ctx, "org.List")
+	defer span.End()
+	user, ok := o.caller(ctx)
+	if !ok {
+		return
+	}
+	workspaces, err := o.orgRepo.GetWorkspaces(ctx, user.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"orgs":   workspaces,
+	})
+}
+
+// Delete deletes an organization with all its notes
+func (o *org) Delete(ctx *gin.Context) {
+	span := startSpan(ctx, "org.Delete")
+	defer span.End()
+	organization, _, _, ok := o.member(ctx, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	err := o.orgRepo.DeleteOrg(ctx, organization.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "organization deleted",
+	})
+}
+
+// Members lists the members of an organization
+func (o *org) Members(ctx *gin.Context) {
+	span := startSpan(ctx, "org.Members")
+	defer span.End()
+	organization, _, _, ok := o.member(ctx, models.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	members, err := o.orgRepo.GetMembers(ctx, organization.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"members": members,
+	})
+}
+
+// UpdateMember changes the role of a member
+func (o *org) UpdateMember(ctx *gin.Context) {
+	span := startSpan(ctx, "org.UpdateMember")
+	defer span.End()
+	var body struct {
+		Role string `json:"role"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	if !auth.ValidOrgRole(body.Role) {
+		apierror.BadRequest(ctx, "role should be owner, editor or viewer")
+		return
+	}
+	organization, _, _, ok := o.member(ctx, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	membership, ok := o.targetMember(ctx, organization)
+	if !ok {
+		return
+	}
+	if membership.Role == models.OrgRoleOwner && body.Role != models.OrgRoleOwner && !o.otherOwners(ctx, organization) {
+		return
+	}
+	membership.Role = body.Role
+	err = o.orgRepo.SaveMembership(ctx, &membership)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "member updated",
+	})
+}
+
+// RemoveMember removes a member, owners can remove anyone and members can
+// leave, using "me" as username
+func (o *org) RemoveMember(ctx *gin.Context) {
+	span := startSpan(ctx, "org.RemoveMember")
+	defer span.End()
+	organization, user, membership, ok := o.member(ctx, models.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	username := strings.ToLower(ctx.Param("username"))
+	if username == "me" || username == user.Username {
+		if membership.Role == models.OrgRoleOwner && !o.otherOwners(ctx, organization) {
+			return
+		}
+		o.removeMember(ctx, organization, membership)
+		return
+	}
+	if membership.Role != models.OrgRoleOwner {
+		apierror.Forbidden(ctx, "only owners can remove members")
+		return
+	}
+	target, ok := o.targetMember(ctx, organization)
+	if !ok {
+		return
+	}
+	if target.Role == models.OrgRoleOwner && !o.otherOwners(ctx, organization) {
+		return
+	}
+	o.removeMember(ctx, organization, target)
+}
+
+// removeMember deletes a membership
+func (o *org) removeMember(ctx *gin.Context, organization models.Organization, membership models.Membership) {
+	err := o.orgRepo.DeleteMembership(ctx, organization.ID, membership.UserID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "member removed",
+	})
+}
+
+// Invite creates an invite code, mailed to the invitee when an email is given
+func (o *org) Invite(ctx *gin.Context) {
+	span := startSpan(ctx, "org.Invite")
+	defer span.End()
+	var body struct {
+		Role  string `json:"role"`
+		Email string `json:"email"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	if body.Role == "" {
+		body.Role = models.OrgRoleEditor
+	}
+	if !auth.ValidOrgRole(body.Role) {
+		apierror.BadRequest(ctx, "role should be owner, editor or viewer")
+		return
+	}
+	organization, user, _, ok := o.member(ctx, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+
+	code, err := utils.GenerateInviteCode()
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	invite := models.OrgInvite{
+		OrgID:     organization.ID,
+		Hash:      utils.HashToken(utils.NormalizeInviteCode(code)),
+		Email:     strings.ToLower(strings.TrimSpace(body.Email)),
+		Role:      body.Role,
+		CreatedBy: user.Username,
+		ExpiresAt: time.Now().Add(orgInviteTTL),
+	}
+	err = o.orgRepo.CreateOrgInvite(ctx, &invite)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+
+	if invite.Email != "" {
+		err = o.mailer.Send(mailer.Message{
+			To:      invite.Email,
+			Subject: "Join " + organization.DisplayName + " on gnote",
+			Body: "Hi,\n\n" +
+				user.Username + " invited you to join " + organization.DisplayName + " on gnote as " + invite.Role + ".\n" +
+				"Run the command below within a week to accept:\n\n" +
+				"gnote org join " + organization.Name + " " + code + "\n",
+		})
+		if err != nil {
+			logger.Ctx(ctx).Error().Err(err).Msg("failed to send organization invite")
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"code":   code,
+		"invite": invite,
+	})
+}
+
+// Invites lists the pending invites of an organization
+func (o *org) Invites(ctx *gin.Context) {
+	span := startSpan(ctx, "org.Invites")
+	defer span.End()
+	organization, _, _, ok := o.member(ctx, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	invites, err := o.orgRepo.GetOrgInvites(ctx, organization.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"invites": invites,
+	})
+}
+
+// RevokeInvite revokes a pending invite
+func (o *org) RevokeInvite(ctx *gin.Context) {
+	span := startSpan(ctx, "org.RevokeInvite")
+	defer span.End()
+	organization, _, _, ok := o.member(ctx, models.OrgRoleOwner)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil || id <= 0 {
+		apierror.BadRequest(ctx, "invalid invite id")
+		return
+	}
+	err = o.orgRepo.DeleteOrgInvite(ctx, organization.ID, uint(id))
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "invite revoked",
+	})
+}
+
+// Join accepts an invite to an organization
+func (o *org) Join(ctx *gin.Context) {
+	span := startSpan(ctx, "org.Join")
+	defer span.End()
+	var body struct {
+		Code string `json:"code"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil || body.Code == "" {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	user, ok := o.caller(ctx)
+	if !ok {
+		return
+	}
+	// the codes of unknown organizations are as invalid as the wrong ones
+	organization, err := o.orgRepo.GetOrg(ctx, strings.ToLower(ctx.Param("org")))
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		apierror.Internal(ctx, err)
+		return
+	}
+	var membership models.Membership
+	if err == nil {
+		membership, err = o.orgRepo.AcceptOrgInvite(ctx, organization.ID, utils.HashToken(utils.NormalizeInviteCode(body.Code)), user.ID, user.Username)
+	}
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrValidation) {
+		apierror.Forbidden(ctx, "invalid invite code")
+		return
+	}
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"org": models.Workspace{
+			Name:        organization.Name,
+			DisplayName: organization.DisplayName,
+			Role:        membership.Role,
+		},
+	})
+}
+
+// ReadNotes reads all notes of an organization
+func (o *org) ReadNotes(ctx *gin.Context) {
+	span := startSpan(ctx, "org.ReadNotes")
+	defer span.End()
+	organization, _, _, ok := o.member(ctx, models.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	notes, err := o.noteRepo.ReadByOrg(ctx, organization.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"notes":  notes,
+	})
+}
+
+// CreateNote creates a note in an organization
+func (o *org) CreateNote(ctx *gin.Context) {
+	span := startSpan(ctx, "org.CreateNote")
+	defer span.End()
+	var note models.Note
+	err := ctx.ShouldBindJSON(&note)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	organization, user, _, ok := o.member(ctx, models.OrgRoleEditor)
+	if !ok {
+		return
+	}
+	note = models.Note{
+		Title:    note.Title,
+		Content:  note.Content,
+		UserID:   &user.ID,
+		Username: user.Username,
+		OrgID:    &organization.ID,
+	}
+	err = o.noteRepo.Create(ctx, &note)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	metrics.NotesCreated.Inc()
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"note":   note,
+	})
+}
+
+// ReadNote reads a note of an organization
+func (o *org) ReadNote(ctx *gin.Context) {
+	span := startSpan(ctx, "org.ReadNote")
+	defer span.End()
+	organization, _, membership, ok := o.member(ctx, models.OrgRoleViewer)
+	if !ok {
+		return
+	}
+	note, ok := o.targetNote(ctx, organization, membership, auth.ActionRead)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"note":   note,
+	})
+}
+
+// UpdateNote updates a note of an organization
+func (o *org) UpdateNote(ctx *gin.Context) {
+	span := startSpan(ctx, "org.UpdateNote")
+	defer span.End()
+	var body models.Note
+	err := ctx.ShouldBindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	organization, _, membership, ok := o.member(ctx, models.OrgRoleEditor)
+	if !ok {
+		return
+	}
+	note, ok := o.targetNote(ctx, organization, membership, auth.ActionUpdate)
+	if !ok {
+		return
+	}
+	if body.Title != "" {
+		note.Title = body.Title
+	}
+	if body.Content != "" {
+		note.Content = body.Content
+	}
+	if body.Archived {
+		note.Archived = body.Archived
+	}
+	note, err = o.noteRepo.Update(ctx, note)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"note":   note,
+	})
+}
+
+// DeleteNote deletes a note of an organization
+func (o *org) DeleteNote(ctx *gin.Context) {
+	span := startSpan(ctx, "org.DeleteNote")
+	defer span.End()
+	organization, _, membership, ok := o.member(ctx, models.OrgRoleEditor)
+	if !ok {
+		return
+	}
+	note, ok := o.targetNote(ctx, organization, membership, auth.ActionDelete)
+	if !ok {
+		return
+	}
+	err := o.noteRepo.Delete(ctx, &note)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"note":   note,
+	})
+}
+
+// caller returns the user of the principal set by the auth middleware
+func (o *org) caller(ctx *gin.Context) (models.User, bool) {
+	principal, ok := caller(ctx)
+	if !ok {
+		return models.User{}, false
+	}
+	user, err := o.userRepo.GetUser(ctx, int(principal.UserID))
+	if err != nil || user.DeletedAt.Valid {
+		apierror.Unauthorized(ctx, "invalid token")
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// member checks the user has at least the given role in the organization
+// of the path. Organizations are hidden from non-members.
+func (o *org) member(ctx *gin.Context, role string) (models.Organization, models.User, models.Membership, bool) {
+	user, ok := o.caller(ctx)
+	if !ok {
+		return models.Organization{}, models.User{}, models.Membership{}, false
+	}
+	organization, err := o.orgRepo.GetOrg(ctx, strings.ToLower(ctx.Param("org")))
+	var membership models.Membership
+	if err == nil {
+		membership, err = o.orgRepo.GetMembership(ctx, organization.ID, user.ID)
+	}
+	if err != nil {
+		apierror.NotFound(ctx, "organization not found")
+		return models.Organization{}, models.User{}, models.Membership{}, false
+	}
+	if !auth.HasOrgRole(membership.Role, role) {
+		apierror.Forbidden(ctx, "this requires the "+role+" role")
+		return models.Organization{}, models.User{}, models.Membership{}, false
+	}
+	return organization, user, membership, true
+}
+
+// targetMember returns the membership of the user named in the path
+func (o *org) targetMember(ctx *gin.Context, organization models.Organization) (models.Membership, bool) {
+	user, err := o.userRepo.GetUserByUsername(ctx, strings.ToLower(ctx.Param("username")))
+	var membership models.Membership
+	if err == nil {
+		membership, err = o.orgRepo.GetMembership(ctx, organization.ID, user.ID)
+	}
+	if err != nil {
+		apierror.NotFound(ctx, "member not found")
+		return models.Membership{}, false
+	}
+	return membership, true
+}
+
+// otherOwners prevents removing the last owner of an organization
+func (o *org) otherOwners(ctx *gin.Context, organization models.Organization) bool {
+	owners, err := o.orgRepo.CountOwners(ctx, organization.ID)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return false
+	}
+	if owners < 2 {
+		apierror.BadRequest(ctx, "an organization needs at least one owner")
+		return false
+	}
+	return true
+}
+
+// targetNote returns the note of the organization with the id in the path
+// when the policy allows the action
+func (o *org) targetNote(ctx *gin.Context, organization models.Organization, membership models.Membership, action auth.Action) (models.Note, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		apierror.Abort(ctx, http.StatusNotFound, apierror.CodeNotFound, "note not found", gin.H{
+			"id": "not a note id",
+		})
+		return models.Note{}, false
+	}
+	note, err := o.noteRepo.ReadInOrg(ctx, organization.ID, id)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		apierror.Internal(ctx, err)
+		return models.Note{}, false
+	}
+	principal, _ := auth.PrincipalFrom(ctx)
+	if err != nil || !auth.CanAccessNote(principal, note, &membership, action) {
+		apierror.NotFound(ctx, "note not found")
+		return models.Note{}, false
+	}
+	return note, true
+}
+
+// NewOrg initializes the organization controller
+func NewOrg(orgRepo repository.OrgRepo, noteRepo repository.NoteRepo, userRepo repository.UserRepo, mailer mailer.Mailer) Org {
+	return &org{
+		orgRepo:  orgRepo,
+		noteRepo: noteRepo,
+		userRepo: userRepo,
+		mailer:   mailer,
+	}
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// purgeInterval is the time between two purges of deleted accounts
+const purgeInterval = 1 * time.Hour
+
+// PurgeDeletedUsers deletes the accounts whose grace period has ended,
+// it runs forever and is meant to be started in its own goroutine
+func PurgeDeletedUsers(userRepo repository.UserRepo) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		purgeDeletedUsers(context.Background(), userRepo, time.Now())
+		<-ticker.C
+	}
+}
+
+// purgeDeletedUsers deletes the accounts due for deletion at the given time
+func purgeDeletedUsers(ctx context.Context, userRepo repository.UserRepo, now time.Time) {
+	users, err := userRepo.GetUsersDueForDeletion(ctx, now)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("failed to list the accounts due for deletion")
+		return
+	}
+	for _, user := range users {
+		err = userRepo.DeleteUser(ctx, user.ID)
+		if err != nil {
+			logger.Ctx(ctx).Error().Err(err).Msgf("failed to purge user %s", user.Username)
+			continue
+		}
+		logger.Ctx(ctx).Info().Msgf("user %s purged", user.Username)
+	}
+}
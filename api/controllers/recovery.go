@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+var (
+	// ErrNoAppURL is returned when mailing a link without APP_URL
+	ErrNoAppURL = errors.New("APP_URL is required to mail links")
+)
+
+const (
+	// resetTokenTTL is the lifetime of password reset tokens
+	resetTokenTTL = 1 * time.Hour
+	// verifyTokenTTL is the lifetime of email verification tokens
+	verifyTokenTTL = 24 * time.Hour
+	// mailQueueSize bounds the links waiting to be mailed, more are dropped
+	mailQueueSize = 100
+)
+
+// mailJob is a link waiting to be mailed to a user
+type mailJob struct {
+	ctx     context.Context
+	user    models.User
+	purpose string
+}
+
+// ForgotPassword sends a password reset link to the user's email
+func (u *user) ForgotPassword(ctx *gin.Context) {
+	span := startSpan(ctx, "user.ForgotPassword")
+	defer span.End()
+	var creds models.Credentials
+	err := ctx.BindJSON(&creds)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+
+	user, err := u.findUser(ctx, creds)
+	if err == nil && !user.DeletedAt.Valid && user.Email != "" {
+		u.queueUserToken(ctx, user, models.TokenPurposeReset)
+	}
+
+	// Always answer the same, so that accounts can't be discovered
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "if the account exists, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using a reset token
+func (u *user) ResetPassword(ctx *gin.Context) {
+	span := startSpan(ctx, "user.ResetPassword")
+	defer span.End()
+	var body map[string]string
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+
+	if !utils.IsValidPassword(body["password"]) {
+		apierror.BadRequest(ctx, "bad password")
+		return
+	}
+
+	user, token, err := u.useUserToken(ctx, body["token"], models.TokenPurposeReset)
+	if err != nil {
+		apierror.BadRequest(ctx, "invalid or expired token")
+		return
+	}
+
+	user.Password, err = utils.HashAndSalt(body["password"])
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	// Following the link proves the ownership of the email as well
+	user.EmailVerified = true
+	user.ResetRequired = false
+	// whoever knew the old password is signed out
+	user.SessionsRevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+
+	err = u.userRepo.UpdateUser(ctx, &user)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	// Invalidate the remaining reset links
+	err = u.tokenRepo.DeleteTokens(ctx, user.ID, token.Purpose)
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("failed to delete reset tokens")
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "password has been reset",
+	})
+}
+
+// VerifyEmail verifies the user's email using a verification token
+func (u *user) VerifyEmail(ctx *gin.Context) {
+	span := startSpan(ctx, "user.VerifyEmail")
+	defer span.End()
+	user, _, err := u.useUserToken(ctx, ctx.Query("token"), models.TokenPurposeVerify)
+	if err != nil {
+		apierror.BadRequest(ctx, "invalid or expired token")
+		return
+	}
+
+	user.EmailVerified = true
+	err = u.userRepo.UpdateUser(ctx, &user)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "email verified",
+	})
+}
+
+// ResendVerification sends a new email verification link
+func (u *user) ResendVerification(ctx *gin.Context) {
+	span := startSpan(ctx, "user.ResendVerification")
+	defer span.End()
+	var creds models.Credentials
+	err := ctx.BindJSON(&creds)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+
+	user, err := u.findUser(ctx, creds)
+	if err == nil && !user.DeletedAt.Valid && !user.EmailVerified && user.Email != "" {
+		u.queueUserToken(ctx, user, models.TokenPurposeVerify)
+	}
+
+	// Always answer the same, so that accounts can't be discovered
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "if the account exists, a verification link has been sent",
+	})
+}
+
+// findUser looks up a user by the email or the username of the credentials
+func (u *user) findUser(ctx context.Context, creds models.Credentials) (models.User, error) {
+	email := strings.ToLower(strings.TrimSpace(creds.Email))
+	if email != "" {
+		return u.userRepo.GetUserByEmail(ctx, email)
+	}
+	username := strings.ToLower(strings.TrimSpace(creds.Username))
+	if username != "" {
+		return u.userRepo.GetUserByUsername(ctx, username)
+	}
+	return models.User{}, errors.New("email or username is required")
+}
+
+// queueUserToken mails a link to the user in the background, so that the
+// answer takes the same time whether the account exists or not
+func (u *user) queueUserToken(ctx *gin.Context, user models.User, purpose string) {
+	select {
+	case u.mails <- mailJob{ctx: logger.Detach(ctx), user: user, purpose: purpose}:
+	default:
+		logger.Ctx(ctx).Warn().Msgf("mail queue is full, the %s link is not sent", purpose)
+	}
+}
+
+// sendMails mails the queued links one at a time, it runs for the life of
+// the controller
+func (u *user) sendMails() {
+	for job := range u.mails {
+		err := sendUserToken(job.ctx, u.tokenRepo, u.mailer, job.user, job.purpose)
+		if err != nil {
+			logger.Ctx(job.ctx).Error().Err(err).Msgf("failed to send the %s email", job.purpose)
+		}
+	}
+}
+
+// sendUserToken issues a new single-use token and mails its link to the user
+func sendUserToken(ctx context.Context, tokenRepo repository.TokenRepo, m mailer.Mailer, user models.User, purpose string) error {
+	base, err := baseURL()
+	if err != nil {
+		return err
+	}
+	token, hash, err := utils.GenerateSignedToken(auth.Secret(), purpose)
+	if err != nil {
+		return err
+	}
+	ttl := verifyTokenTTL
+	if purpose == models.TokenPurposeReset {
+		ttl = resetTokenTTL
+	}
+	// Only the latest link stays valid
+	err = tokenRepo.DeleteTokens(ctx, user.ID, purpose)
+	if err != nil {
+		return err
+	}
+	err = tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{To: user.Email}
+	switch purpose {
+	case models.TokenPurposeReset:
+		link := base + "/reset?token=" + url.QueryEscape(token)
+		msg.Subject = "Reset your gnote password"
+		msg.Body = "Hi " + user.Username + ",\n\n" +
+			"Someone asked to reset the password of your gnote account.\n" +
+			"Follow the link below within an hour to choose a new one:\n\n" +
+			link + "\n\n" +
+			"If it wasn't you, you can ignore this email.\n"
+	default:
+		link := base + "/auth/verify?token=" + url.QueryEscape(token)
+		msg.Subject = "Verify your gnote email"
+		msg.Body = "Hi " + user.Username + ",\n\n" +
+			"Follow the link below to verify your email address:\n\n" +
+			link + "\n\n" +
+			"If you didn't sign up for gnote, you can ignore this email.\n"
+	}
+	return m.Send(msg)
+}
+
+// useUserToken verifies a token, marks it as used and returns its user
+func (u *user) useUserToken(ctx context.Context, tokenString, purpose string) (models.User, models.UserToken, error) {
+	hash, err := utils.VerifySignedToken(auth.Secret(), purpose, tokenString)
+	if err != nil {
+		return models.User{}, models.UserToken{}, err
+	}
+	token, err := u.tokenRepo.GetToken(ctx, hash, purpose)
+	if err != nil {
+		return models.User{}, models.UserToken{}, err
+	}
+	err = u.tokenRepo.UseToken(ctx, token.ID)
+	if err != nil {
+		return models.User{}, models.UserToken{}, err
+	}
+	user, err := u.userRepo.GetUser(ctx, int(token.UserID))
+	if err != nil {
+		return models.User{}, models.UserToken{}, err
+	}
+	if user.DeletedAt.Valid {
+		return models.User{}, models.UserToken{}, errors.New("user is deleted")
+	}
+	return user, token, nil
+}
+
+// baseURL returns the public URL of the server used in links. It is never
+// taken from the request, whose Host header could send the tokens to another
+// site.
+func baseURL() (string, error) {
+	if appURL == "" {
+		return "", ErrNoAppURL
+	}
+	return strings.TrimSuffix(appURL, "/"), nil
+}
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/lockout"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/mailer/mailtest"
+	"github.com/mrinjamul/gnote/middleware"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+// userFixture serves the account routes, alice has an unverified email and
+// the mails go to a capture server
+type userFixture struct {
+	router    *gin.Engine
+	mail      *mailtest.Server
+	userRepo  repository.UserRepo
+	tokenRepo repository.TokenRepo
+	alice     models.User
+}
+
+// alicePassword is the password of alice in the fixtures
+const alicePassword = "Alice-passw0rd"
+
+func newUserFixture(t *testing.T, cfg config.Lockout) *userFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	err := auth.Init(config.Auth{JWTSecret: "user-test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := appURL
+	appURL = "https://gnote.example.com"
+	t.Cleanup(func() { appURL = base })
+
+	f := &userFixture{
+		mail:      mailtest.NewServer(t),
+		userRepo:  repository.NewUserRepo(db),
+		tokenRepo: repository.NewTokenRepo(db),
+	}
+	hash, err := utils.HashAndSalt(alicePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.alice = models.User{Username: "alice", Email: "alice@example.com", Password: hash, Role: "user", Level: 1}
+	err = f.userRepo.CreateUser(context.Background(), &f.alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := mailer.NewSMTPMailer(f.mail.Host, f.mail.Port, "", "", "gnote <noreply@example.com>")
+	users := NewUser(f.userRepo, f.tokenRepo, repository.NewInviteRepo(db), repository.NewOrgRepo(db), m, lockout.NewGuard(cfg))
+	f.router = gin.New()
+	f.router.POST("/auth/login", users.SignIn)
+	f.router.POST("/auth/forgot", users.ForgotPassword)
+	f.router.POST("/auth/reset", users.ResetPassword)
+	f.router.GET("/auth/verify", users.VerifyEmail)
+	f.router.POST("/auth/verify", users.ResendVerification)
+	f.router.POST("/auth/restore", users.RestoreUser)
+	f.router.GET("/api/user", middleware.JWTAuth(), users.UserDetails)
+	f.router.DELETE("/api/user", middleware.JWTAuth(), users.DeleteUser)
+	return f
+}
+
+// do sends a request with the JSON body, when not empty
+func (f *userFixture) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	// the links must not follow the Host header
+	req.Host = "evil.example.com"
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// linkPattern matches the emailed links
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+// nextLink waits for the next email and returns the token of its link, the
+// link must be under the path
+func (f *userFixture) nextLink(t *testing.T, subject, path string) string {
+	t.Helper()
+	msg, err := f.mail.Next(t).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("To"); got != f.alice.Email {
+		t.Errorf("mail to %q, want %q", got, f.alice.Email)
+	}
+	if got := msg.Header.Get("Subject"); got != subject {
+		t.Errorf("mail subject = %q, want %q", got, subject)
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := url.Parse(linkPattern.FindString(string(body)))
+	if err != nil || link.Scheme+"://"+link.Host+link.Path != appURL+path {
+		t.Fatalf("mailed link = %v, %v, want one to %s%s in\n%s", link, err, appURL, path, body)
+	}
+	return link.Query().Get("token")
+}
+
+func TestPasswordResetLifecycle(t *testing.T) {
+	f := newUserFixture(t, config.Lockout{})
+	ctx := context.Background()
+
+	// unknown accounts get the same answer and no email
+	unknown := f.do(http.MethodPost, "/auth/forgot", `{"email":"nobody@example.com"}`)
+	known := f.do(http.MethodPost, "/auth/forgot", `{"email":"Alice@Example.com"}`)
+	if unknown.Code != http.StatusOK || known.Code != http.StatusOK || unknown.Body.String() != known.Body.String() {
+		t.Errorf("forgot = %d %s and %d %s, want the same answer", unknown.Code, unknown.Body, known.Code, known.Body)
+	}
+	first := f.nextLink(t, "Reset your gnote password", "/reset")
+
+	// only the latest link is valid
+	f.do(http.MethodPost, "/auth/forgot", `{"username":"alice"}`)
+	token := f.nextLink(t, "Reset your gnote password", "/reset")
+	if rec := f.do(http.MethodPost, "/auth/reset", `{"token":"`+first+`","password":"New-passw0rd"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reset with a replaced link = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// a weak password doesn't use up the link
+	if rec := f.do(http.MethodPost, "/auth/reset", `{"token":"`+token+`","password":"weak"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reset with a weak password = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := f.do(http.MethodPost, "/auth/reset", `{"token":"`+token+`","password":"New-passw0rd"}`); rec.Code != http.StatusOK {
+		t.Fatalf("reset = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	alice, err := f.userRepo.GetUser(ctx, int(f.alice.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.VerifyHash("New-passw0rd", alice.Password) || utils.VerifyHash(alicePassword, alice.Password) {
+		t.Error("the password is not replaced")
+	}
+	if !alice.EmailVerified || !alice.SessionsRevokedAt.Valid {
+		t.Errorf("email verified %v, sessions revoked %v, want both", alice.EmailVerified, alice.SessionsRevokedAt.Valid)
+	}
+
+	// the link is used up
+	if rec := f.do(http.MethodPost, "/auth/reset", `{"token":"`+token+`","password":"Other-passw0rd"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reset with a used link = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExpiredAndForeignResetTokens(t *testing.T) {
+	f := newUserFixture(t, config.Lockout{})
+	ctx := context.Background()
+
+	expired, hash, err := utils.GenerateSignedToken(auth.Secret(), models.TokenPurposeReset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.tokenRepo.CreateToken(ctx, &models.UserToken{
+		UserID:    f.alice.ID,
+		Purpose:   models.TokenPurposeReset,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := f.do(http.MethodPost, "/auth/reset", `{"token":"`+expired+`","password":"New-passw0rd"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reset with an expired link = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// a verification link doesn't reset the password
+	f.do(http.MethodPost, "/auth/verify", `{"email":"alice@example.com"}`)
+	verify := f.nextLink(t, "Verify your gnote email", "/auth/verify")
+	if rec := f.do(http.MethodPost, "/auth/reset", `{"token":"`+verify+`","password":"New-passw0rd"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("reset with a verification link = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	alice, err := f.userRepo.GetUser(ctx, int(f.alice.ID))
+	if err != nil || !utils.VerifyHash(alicePassword, alice.Password) {
+		t.Errorf("the password changed, %v", err)
+	}
+}
+
+func TestEmailVerificationLifecycle(t *testing.T) {
+	f := newUserFixture(t, config.Lockout{})
+	ctx := context.Background()
+
+	if rec := f.do(http.MethodPost, "/auth/verify", `{"username":"alice"}`); rec.Code != http.StatusOK {
+		t.Fatalf("resend = %d, want %d", rec.Code, http.StatusOK)
+	}
+	token := f.nextLink(t, "Verify your gnote email", "/auth/verify")
+
+	if rec := f.do(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), ""); rec.Code != http.StatusOK {
+		t.Fatalf("verify = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	alice, err := f.userRepo.GetUser(ctx, int(f.alice.ID))
+	if err != nil || !alice.EmailVerified {
+		t.Errorf("email verified = %v, %v, want true", alice.EmailVerified, err)
+	}
+	if rec := f.do(http.MethodGet, "/auth/verify?token="+url.QueryEscape(token), ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("verify with a used link = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// a verified email gets no new link, the next email is the reset one
+	f.do(http.MethodPost, "/auth/verify", `{"username":"alice"}`)
+	f.do(http.MethodPost, "/auth/forgot", `{"username":"alice"}`)
+	f.nextLink(t, "Reset your gnote password", "/reset")
+}
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/oidc"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+var (
+	// ErrAdminExists is returned when bootstrapping an instance which already has an admin
+	ErrAdminExists = errors.New("an admin already exists")
+
+	errInvalidUsername = errors.New("invalid username")
+	errBadPassword     = errors.New("bad password")
+)
+
+// BootstrapAdmin creates the first admin, it fails once any admin exists
+func BootstrapAdmin(ctx context.Context, userRepo repository.UserRepo, username, email, password string) (models.User, error) {
+	exists, err := userRepo.AdminExists(ctx)
+	if err != nil {
+		return models.User{}, err
+	}
+	if exists {
+		return models.User{}, ErrAdminExists
+	}
+
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !utils.IsValidUserName(username) {
+		return models.User{}, errInvalidUsername
+	}
+	if !utils.IsValidPassword(password) {
+		return models.User{}, errBadPassword
+	}
+	hash, err := utils.HashAndSalt(password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{
+		Username: username,
+		Email:    strings.ToLower(strings.TrimSpace(email)),
+		Password: hash,
+		Role:     "admin",
+		Level:    maxLevel,
+		// the operator owns the address
+		EmailVerified: email != "",
+	}
+	err = userRepo.CreateUser(ctx, &user)
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// Setup is a controller to create the first admin with a setup token
+type Setup interface {
+	// Setup creates the first admin
+	Setup(ctx *gin.Context)
+}
+
+// setup is a controller to create the first admin with a setup token
+type setup struct {
+	userRepo repository.UserRepo
+	token    string
+	// mu serializes setups so only one admin can be bootstrapped
+	mu sync.Mutex
+}
+
+// Setup creates the first admin, the request must carry the setup token
+func (s *setup) Setup(ctx *gin.Context) {
+	span := startSpan(ctx, "setup.Setup")
+	defer span.End()
+	var body struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(body.Token), []byte(s.token)) != 1 {
+		apierror.Forbidden(ctx, "invalid setup token")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, err := BootstrapAdmin(ctx, s.userRepo, body.Username, body.Email, body.Password)
+	if err == ErrAdminExists {
+		apierror.Conflict(ctx, err.Error())
+		return
+	}
+	if err == errInvalidUsername || err == errBadPassword {
+		apierror.BadRequest(ctx, err.Error())
+		return
+	}
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("setup: created the first admin %s", user.Username)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "admin created successfully",
+	})
+}
+
+// NewSetup initializes the setup controller. The setup token is the
+// configured one, or generated and logged when no admin exists yet.
+func NewSetup(userRepo repository.UserRepo) Setup {
+	token := setupToken
+	if token == "" {
+		exists, err := userRepo.AdminExists(context.Background())
+		if err == nil && !exists {
+			token, err = oidc.RandomString()
+			if err != nil {
+				token = ""
+			} else {
+				logger.Base().Info().Msgf("setup: no admin exists, create one with `gnote admin bootstrap` or POST /auth/setup with the setup token %s", token)
+			}
+		}
+	}
+	return &setup{
+		userRepo: userRepo,
+		token:    token,
+	}
+}
@@ -0,0 +1,416 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/metrics"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/oidc"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+const (
+	// ssoCookie holds the state, nonce and PKCE verifier of a running sign in
+	ssoCookie = "oidc_flow"
+	// ssoInviteCookie holds the invite code of a sign in creating an account
+	ssoInviteCookie = "oidc_invite"
+	// ssoCookieMaxAge is the time given to the user to sign in at the provider
+	ssoCookieMaxAge = 10 * 60
+)
+
+var (
+	// ssoAllowedDomains restricts single sign-on to these email domains
+	ssoAllowedDomains []string
+	// ssoRedirectURL is the callback URL registered at the provider, derived
+	// from the public URL when empty
+	ssoRedirectURL string
+)
+
+// SSO is a controller for OpenID Connect sign in
+type SSO interface {
+	// Login redirects to the provider
+	Login(ctx *gin.Context)
+	// Callback completes the authorization code flow
+	Callback(ctx *gin.Context)
+	// DeviceAuthorize starts the device flow for the CLI
+	DeviceAuthorize(ctx *gin.Context)
+	// DeviceToken completes the device flow for the CLI
+	DeviceToken(ctx *gin.Context)
+}
+
+// sso is a controller for OpenID Connect sign in
+type sso struct {
+	provider     *oidc.Provider
+	userRepo     repository.UserRepo
+	identityRepo repository.IdentityRepo
+	inviteRepo   repository.InviteRepo
+}
+
+// Login redirects to the provider using the authorization code flow with PKCE
+func (s *sso) Login(ctx *gin.Context) {
+	span := startSpan(ctx, "sso.Login")
+	defer span.End()
+	if !s.configured(ctx) {
+		return
+	}
+	var flow [3]string
+	for i := range flow {
+		value, err := oidc.RandomString()
+		if err != nil {
+			apierror.Internal(ctx, err)
+			return
+		}
+		flow[i] = value
+	}
+	state, nonce, verifier := flow[0], flow[1], flow[2]
+
+	redirect, err := redirectURL()
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("oidc redirect URL is not configured")
+		apierror.Internal(ctx, nil)
+		return
+	}
+	authURL, err := s.provider.AuthCodeURL(redirect, state, nonce, verifier)
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Msg("oidc request failed")
+		apierror.Abort(ctx, http.StatusBadGateway, apierror.CodeUnavailable, "identity provider is unavailable", nil)
+		return
+	}
+	secure := ctx.Request.TLS != nil || utils.SecureCookies
+	ctx.SetCookie(ssoCookie, strings.Join(flow[:], "."), ssoCookieMaxAge, "/auth/oidc", "", secure, true)
+	// the invite code is needed when the sign in creates the account
+	if invite := ctx.Query("invite"); invite != "" {
+		ctx.SetCookie(ssoInviteCookie, invite, ssoCookieMaxAge, "/auth/oidc", "", secure, true)
+	} else {
+		ctx.SetCookie(ssoInviteCookie, "", -1, "/auth/oidc", "", secure, true)
+	}
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes the authorization code flow and signs the user in
+func (s *sso) Callback(ctx *gin.Context) {
+	span := startSpan(ctx, "sso.Callback")
+	defer span.End()
+	if !s.configured(ctx) {
+		return
+	}
+	cookie, err := ctx.Cookie(ssoCookie)
+	invite, _ := ctx.Cookie(ssoInviteCookie)
+	// the flow can only be completed once
+	secure := ctx.Request.TLS != nil || utils.SecureCookies
+	ctx.SetCookie(ssoCookie, "", -1, "/auth/oidc", "", secure, true)
+	ctx.SetCookie(ssoInviteCookie, "", -1, "/auth/oidc", "", secure, true)
+	flow := strings.Split(cookie, ".")
+	if err != nil || len(flow) != 3 || flow[0] != ctx.Query("state") {
+		apierror.BadRequest(ctx, "invalid state")
+		return
+	}
+	if ctx.Query("error") != "" {
+		apierror.Unauthorized(ctx, ctx.Query("error"))
+		return
+	}
+
+	redirect, err := redirectURL()
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("oidc redirect URL is not configured")
+		apierror.Internal(ctx, nil)
+		return
+	}
+	tokens, err := s.provider.Exchange(ctx.Query("code"), redirect, flow[2])
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Msg("oidc request failed")
+		apierror.Unauthorized(ctx, "sign in failed")
+		return
+	}
+	claims, err := s.provider.Verify(tokens.IDToken, flow[1])
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Msg("oidc request failed")
+		apierror.Unauthorized(ctx, "sign in failed")
+		return
+	}
+
+	user, err := s.resolveUser(ctx, claims, invite)
+	if err != nil {
+		abortRefused(ctx, err)
+		return
+	}
+	_, err = issueToken(ctx, user)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	metrics.Logins.WithLabelValues("sso").Inc()
+	ctx.Redirect(http.StatusFound, "/")
+}
+
+// DeviceAuthorize starts the device flow at the provider for the CLI
+func (s *sso) DeviceAuthorize(ctx *gin.Context) {
+	span := startSpan(ctx, "sso.DeviceAuthorize")
+	defer span.End()
+	if !s.configured(ctx) {
+		return
+	}
+	device, err := s.provider.DeviceAuthorize()
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Msg("oidc request failed")
+		apierror.Abort(ctx, http.StatusBadGateway, apierror.CodeUnavailable, "identity provider is unavailable", nil)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"device": device,
+	})
+}
+
+// DeviceToken completes the device flow, it is polled by the CLI
+func (s *sso) DeviceToken(ctx *gin.Context) {
+	span := startSpan(ctx, "sso.DeviceToken")
+	defer span.End()
+	if !s.configured(ctx) {
+		return
+	}
+	var body map[string]string
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+
+	tokens, err := s.provider.DeviceToken(body["device_code"])
+	if err == oidc.ErrAuthorizationPending || err == oidc.ErrSlowDown {
+		apierror.BadRequest(ctx, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Msg("oidc request failed")
+		apierror.Unauthorized(ctx, "sign in failed")
+		return
+	}
+	claims, err := s.provider.Verify(tokens.IDToken, "")
+	if err != nil {
+		logger.Ctx(ctx).Warn().Err(err).Msg("oidc request failed")
+		apierror.Unauthorized(ctx, "sign in failed")
+		return
+	}
+
+	user, err := s.resolveUser(ctx, claims, body["invite"])
+	if err != nil {
+		abortRefused(ctx, err)
+		return
+	}
+	tokenString, err := issueToken(ctx, user)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	metrics.Logins.WithLabelValues("sso").Inc()
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"token":  tokenString,
+	})
+}
+
+// configured answers with not found when single sign-on is not configured
+func (s *sso) configured(ctx *gin.Context) bool {
+	if s.provider == nil {
+		apierror.NotFound(ctx, "single sign-on is not configured")
+		return false
+	}
+	return true
+}
+
+// resolveUser returns the user of an external identity. Unknown identities
+// are linked to the user with the same verified email, or a new user is
+// created on first sign in when the registration mode and the invite code
+// allow it.
+func (s *sso) resolveUser(ctx context.Context, claims *oidc.Claims, inviteCode string) (models.User, error) {
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+	if len(ssoAllowedDomains) > 0 && !allowedDomain(email) {
+		return models.User{}, refusal("email domain is not allowed")
+	}
+
+	identity, err := s.identityRepo.GetIdentity(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		user, err := s.userRepo.GetUser(ctx, int(identity.UserID))
+		if err != nil {
+			return models.User{}, refusal("user is deleted")
+		}
+		// the same accounts are refused as at password sign in
+		err = accountBlocked(user)
+		if err != nil {
+			return models.User{}, err
+		}
+		return user, nil
+	}
+
+	if email == "" || !claims.IsEmailVerified() {
+		return models.User{}, refusal("email is not verified by the identity provider")
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err == nil {
+		err = accountBlocked(user)
+		if err != nil {
+			return models.User{}, err
+		}
+		if !user.EmailVerified {
+			user.EmailVerified = true
+			err = s.userRepo.UpdateUser(ctx, &user)
+			if err != nil {
+				return models.User{}, err
+			}
+		}
+	} else {
+		user, err = s.createUser(ctx, claims, email, inviteCode)
+		if err != nil {
+			return models.User{}, err
+		}
+	}
+
+	err = s.identityRepo.CreateIdentity(ctx, &models.Identity{
+		UserID:  user.ID,
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   email,
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// createUser creates the user of a new external identity, it is refused as
+// a sign up would be
+func (s *sso) createUser(ctx context.Context, claims *oidc.Claims, email, inviteCode string) (models.User, error) {
+	invite, err := registrationInvite(ctx, s.inviteRepo, inviteCode)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The password is unknown to everyone, it can be set with a reset link
+	password, err := oidc.RandomString()
+	if err != nil {
+		return models.User{}, err
+	}
+	hash, err := utils.HashAndSalt(password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	candidate := claims.PreferredUsername
+	if candidate == "" || strings.Contains(candidate, "@") {
+		candidate = strings.Split(email, "@")[0]
+	}
+	username, err := s.uniqueUsername(ctx, candidate)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	user := models.User{
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+		Username:      username,
+		Email:         email,
+		EmailVerified: true,
+		Password:      hash,
+		Role:          "user",
+		Level:         1,
+	}
+	if user.FirstName == "" {
+		user.FirstName = claims.Name
+	}
+	if invite.ID != 0 {
+		applyInvite(&user, invite)
+		// Count the use of the invite, it may have been used up meanwhile
+		err = s.inviteRepo.UseInvite(ctx, invite.ID)
+		if err != nil {
+			return models.User{}, errInvalidInvite
+		}
+	}
+	err = s.userRepo.CreateUser(ctx, &user)
+	if err != nil {
+		if invite.ID != 0 {
+			if err := s.inviteRepo.ReleaseInvite(ctx, invite.ID); err != nil {
+				logger.Ctx(ctx).Error().Err(err).Msg("failed to release invite")
+			}
+		}
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// uniqueUsername derives a valid username which isn't taken yet
+func (s *sso) uniqueUsername(ctx context.Context, candidate string) (string, error) {
+	var base strings.Builder
+	for _, char := range strings.ToLower(candidate) {
+		if unicode.IsLetter(char) || unicode.IsNumber(char) {
+			base.WriteRune(char)
+		}
+	}
+	name := base.String()
+	if len(name) < 3 || utils.IsRestrictedUser(name) {
+		name = "user" + name
+	}
+	for i := 0; i < 100; i++ {
+		username := name
+		if i > 0 {
+			username += strconv.Itoa(i)
+		}
+		// the old usernames of renamed users are reserved as at sign up
+		available, err := s.userRepo.UsernameAvailable(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if available {
+			return username, nil
+		}
+	}
+	return "", refusal("no username available")
+}
+
+// allowedDomain checks if the domain of the email is allowed
+func allowedDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, allowed := range ssoAllowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectURL returns the callback URL registered at the provider, it is
+// never taken from the request
+func redirectURL() (string, error) {
+	if ssoRedirectURL != "" {
+		return ssoRedirectURL, nil
+	}
+	base, err := baseURL()
+	if err != nil {
+		return "", err
+	}
+	return base + "/auth/oidc/callback", nil
+}
+
+// NewSSO initializes the single sign-on controller, provider is nil when
+// single sign-on is not configured
+func NewSSO(provider *oidc.Provider, userRepo repository.UserRepo, identityRepo repository.IdentityRepo, inviteRepo repository.InviteRepo) SSO {
+	return &sso{
+		provider:     provider,
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		inviteRepo:   inviteRepo,
+	}
+}
@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/oidc"
+	"github.com/mrinjamul/gnote/oidc/oidctest"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// ssoFixture signs in at a local issuer, alice already has an account
+type ssoFixture struct {
+	router       *gin.Engine
+	issuer       *oidctest.Issuer
+	userRepo     repository.UserRepo
+	identityRepo repository.IdentityRepo
+	alice        models.User
+}
+
+func newSSOFixture(t *testing.T) *ssoFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	err := auth.Init(config.Auth{JWTSecret: "sso-test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, mode := ssoRedirectURL, registrationMode
+	ssoRedirectURL, registrationMode = "http://gnote.test/auth/oidc/callback", RegistrationOpen
+	t.Cleanup(func() { ssoRedirectURL, registrationMode = redirect, mode })
+
+	f := &ssoFixture{
+		issuer:       oidctest.NewIssuer(t, "gnote"),
+		userRepo:     repository.NewUserRepo(db),
+		identityRepo: repository.NewIdentityRepo(db),
+	}
+	f.alice = models.User{Username: "alice", Email: "alice@example.com", Role: "user", Level: 1}
+	err = f.userRepo.CreateUser(context.Background(), &f.alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sso := NewSSO(f.issuer.Provider(), f.userRepo, f.identityRepo, repository.NewInviteRepo(db))
+	f.router = gin.New()
+	f.router.GET("/auth/oidc/login", sso.Login)
+	f.router.GET("/auth/oidc/callback", sso.Callback)
+	f.router.POST("/auth/oidc/device", sso.DeviceAuthorize)
+	f.router.POST("/auth/oidc/device/token", sso.DeviceToken)
+	return f
+}
+
+// login starts a sign in, it returns the authorization URL and the cookies
+// of the flow
+func (f *ssoFixture) login(t *testing.T) (string, []*http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login = %d, want %d", rec.Code, http.StatusFound)
+	}
+	return rec.Header().Get("Location"), rec.Result().Cookies()
+}
+
+// callback returns from the provider with the state and the code
+func (f *ssoFixture) callback(state, code string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	q := url.Values{"state": {state}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+q.Encode(), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// signIn runs the code flow with the claims signed in at the provider
+func (f *ssoFixture) signIn(t *testing.T, claims oidc.Claims) *httptest.ResponseRecorder {
+	t.Helper()
+	authURL, cookies := f.login(t)
+	code := f.issuer.Authorize(authURL, claims)
+	return f.callback(stateOf(t, authURL), code, cookies)
+}
+
+// stateOf returns the state of the authorization URL
+func stateOf(t *testing.T, authURL string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("state")
+}
+
+// identityClaims are the claims of a subject with a verified email
+func identityClaims(subject, email, username string) oidc.Claims {
+	return oidc.Claims{
+		Email:             email,
+		EmailVerified:     true,
+		PreferredUsername: username,
+		RegisteredClaims:  jwt.RegisteredClaims{Subject: subject},
+	}
+}
+
+// signedInAs checks that the response signed the user in
+func signedInAs(t *testing.T, rec *httptest.ResponseRecorder, want models.User) {
+	t.Helper()
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("callback = %d to %q, want %d to /", rec.Code, rec.Header().Get("Location"), http.StatusFound)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name != "token" {
+			continue
+		}
+		claims := &models.Claims{}
+		_, err := auth.ParseToken(cookie.Value, claims)
+		if err != nil || claims.UserID != want.ID || claims.Username != want.Username {
+			t.Errorf("token of %q (%d), %v, want %q (%d)", claims.Username, claims.UserID, err, want.Username, want.ID)
+		}
+		return
+	}
+	t.Error("no token cookie is set")
+}
+
+func TestSSOCallbackCreatesUser(t *testing.T) {
+	f := newSSOFixture(t)
+	ctx := context.Background()
+
+	rec := f.signIn(t, identityClaims("dana-sub", "Dana@example.com", "dana"))
+	dana, err := f.userRepo.GetUserByUsername(ctx, "dana")
+	if err != nil {
+		t.Fatalf("GetUserByUsername(dana) = %v", err)
+	}
+	signedInAs(t, rec, dana)
+	if dana.Email != "dana@example.com" || !dana.EmailVerified {
+		t.Errorf("user email = %q, verified %v, want dana@example.com verified", dana.Email, dana.EmailVerified)
+	}
+	identity, err := f.identityRepo.GetIdentity(ctx, f.issuer.URL, "dana-sub")
+	if err != nil || identity.UserID != dana.ID {
+		t.Errorf("identity user = %d, %v, want %d", identity.UserID, err, dana.ID)
+	}
+
+	// the identity signs in again even when the email changed
+	rec = f.signIn(t, identityClaims("dana-sub", "dana@elsewhere.example.com", "dana"))
+	signedInAs(t, rec, dana)
+}
+
+func TestSSOCallbackLinksVerifiedEmail(t *testing.T) {
+	f := newSSOFixture(t)
+	ctx := context.Background()
+
+	// an email the provider didn't verify is not linked
+	claims := identityClaims("alice-sub", "alice@example.com", "")
+	claims.EmailVerified = "false"
+	rec := f.signIn(t, claims)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("unverified email = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	_, err := f.identityRepo.GetIdentity(ctx, f.issuer.URL, "alice-sub")
+	if err == nil {
+		t.Error("the unverified identity is linked")
+	}
+
+	rec = f.signIn(t, identityClaims("alice-sub", "alice@example.com", "someone"))
+	signedInAs(t, rec, f.alice)
+	identity, err := f.identityRepo.GetIdentity(ctx, f.issuer.URL, "alice-sub")
+	if err != nil || identity.UserID != f.alice.ID {
+		t.Errorf("identity user = %d, %v, want %d", identity.UserID, err, f.alice.ID)
+	}
+	alice, err := f.userRepo.GetUser(ctx, int(f.alice.ID))
+	if err != nil || !alice.EmailVerified {
+		t.Errorf("alice email verified = %v, %v, want true", alice.EmailVerified, err)
+	}
+	_, err = f.userRepo.GetUserByUsername(ctx, "someone")
+	if err == nil {
+		t.Error("a new user is created for the linked identity")
+	}
+}
+
+func TestSSOCallbackChecksFlow(t *testing.T) {
+	f := newSSOFixture(t)
+	claims := identityClaims("mallory-sub", "mallory@example.com", "mallory")
+
+	// the state must be the one of the cookie
+	authURL, cookies := f.login(t)
+	code := f.issuer.Authorize(authURL, claims)
+	if rec := f.callback("forged", code, cookies); rec.Code != http.StatusBadRequest {
+		t.Errorf("other state = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := f.callback(stateOf(t, authURL), code, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("no flow cookie = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// the code is bound to the PKCE verifier of the cookie
+	authURL, cookies = f.login(t)
+	code = f.issuer.Authorize(authURL, claims)
+	for _, cookie := range cookies {
+		if cookie.Name == ssoCookie {
+			flow := strings.Split(cookie.Value, ".")
+			cookie.Value = flow[0] + "." + flow[1] + ".forged"
+		}
+	}
+	if rec := f.callback(stateOf(t, authURL), code, cookies); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other verifier = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	// the ID token is bound to the nonce of the cookie
+	claims.Nonce = "replayed"
+	if rec := f.signIn(t, claims); rec.Code != http.StatusUnauthorized {
+		t.Errorf("other nonce = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	_, err := f.userRepo.GetUserByUsername(context.Background(), "mallory")
+	if err == nil {
+		t.Error("a refused sign in created the user")
+	}
+}
+
+func TestSSOSkipsReservedUsernames(t *testing.T) {
+	f := newSSOFixture(t)
+	ctx := context.Background()
+	// alice renamed herself, her old username redirects to her
+	err := f.userRepo.ChangeUsername(ctx, f.alice.ID, "alicia", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := f.signIn(t, identityClaims("other-sub", "other@example.com", "alice"))
+	user, err := f.userRepo.GetUserByEmail(ctx, "other@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail() = %v", err)
+	}
+	signedInAs(t, rec, user)
+	if user.Username != "alice1" {
+		t.Errorf("username = %q, want alice1", user.Username)
+	}
+}
+
+func TestSSODeviceFlow(t *testing.T) {
+	f := newSSOFixture(t)
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		f.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post("/auth/oidc/device", "")
+	var started struct {
+		Device oidc.DeviceAuth `json:"device"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &started)
+	if rec.Code != http.StatusOK || err != nil || started.Device.DeviceCode == "" {
+		t.Fatalf("device = %d %s, want a device code", rec.Code, rec.Body)
+	}
+	body := `{"device_code":"` + started.Device.DeviceCode + `"}`
+
+	rec = post("/auth/oidc/device/token", body)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "authorization_pending") {
+		t.Errorf("pending token = %d %s, want %d authorization_pending", rec.Code, rec.Body, http.StatusBadRequest)
+	}
+
+	f.issuer.Approve(started.Device.DeviceCode, identityClaims("alice-sub", "alice@example.com", ""))
+	rec = post("/auth/oidc/device/token", body)
+	var signedIn struct {
+		Token string `json:"token"`
+	}
+	err = json.Unmarshal(rec.Body.Bytes(), &signedIn)
+	if rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("approved token = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	claims := &models.Claims{}
+	_, err = auth.ParseToken(signedIn.Token, claims)
+	if err != nil || claims.UserID != f.alice.ID {
+		t.Errorf("token of user %d, %v, want %d", claims.UserID, err, f.alice.ID)
+	}
+
+	// the device code is used up
+	rec = post("/auth/oidc/device/token", body)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("used device code = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the span of a controller in the trace of the request, the
+// repositories called with the gin context continue it
+func startSpan(ctx *gin.Context, name string) trace.Span {
+	spanCtx, span := tracing.Tracer().Start(ctx.Request.Context(), name)
+	ctx.Request = ctx.Request.WithContext(spanCtx)
+	return span
+}
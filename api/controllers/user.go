@@ -4,28 +4,38 @@ package controllers
 // To implement Multi-level Authentication
 
 import (
+	"database/sql"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/lockout"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/metrics"
 	"github.com/mrinjamul/gnote/models"
 	"github.com/mrinjamul/gnote/repository"
 	"github.com/mrinjamul/gnote/utils"
+	"gorm.io/gorm"
 )
 
 var (
-	jwtKey string
+	// requireVerifiedEmail denies sign in until the email is verified
+	requireVerifiedEmail bool
+	// deletionGracePeriod is the time given to restore a deleted account
+	deletionGracePeriod = 30 * 24 * time.Hour
+	// usernameRedirectPeriod is the time old profile URLs keep redirecting
+	// after a rename, the old username can't be registered meanwhile
+	usernameRedirectPeriod = 90 * 24 * time.Hour
 )
 
-func init() {
-	jwtKey = utils.GetEnv("JWT_SECRET")
-	// if jwtKey == "" {
-	// 	panic("JWT_SECRET not set")
-	// }
-}
-
 // User is a controller for users
 type User interface {
 	// SignUp creates a new user
@@ -42,34 +52,64 @@ type User interface {
 	ViewUser(ctx *gin.Context)
 	// UpdateUser updates the user details
 	UpdateUser(ctx *gin.Context)
-	// DeleteUser deletes a user
+	// ChangeUsername renames the user
+	ChangeUsername(ctx *gin.Context)
+	// ChangePassword changes the password of the user
+	ChangePassword(ctx *gin.Context)
+	// DeleteUser schedules the deletion of a user
 	DeleteUser(ctx *gin.Context)
+	// RestoreUser cancels the scheduled deletion of a user
+	RestoreUser(ctx *gin.Context)
+	// ForgotPassword sends a password reset link
+	ForgotPassword(ctx *gin.Context)
+	// ResetPassword sets a new password using a reset token
+	ResetPassword(ctx *gin.Context)
+	// VerifyEmail verifies the email using a verification token
+	VerifyEmail(ctx *gin.Context)
+	// ResendVerification sends a new verification link
+	ResendVerification(ctx *gin.Context)
+	// UnlockUser lifts the login lockout of a user
+	UnlockUser(ctx *gin.Context)
 }
 
 // user is a controller for users
 type user struct {
-	userRepo repository.UserRepo
+	userRepo   repository.UserRepo
+	tokenRepo  repository.TokenRepo
+	inviteRepo repository.InviteRepo
+	orgRepo    repository.OrgRepo
+	mailer     mailer.Mailer
+	guard      *lockout.Guard
+	// mails are the recovery links waiting to be mailed
+	mails chan mailJob
 }
 
 // SignUp creates a new user
 func (u *user) SignUp(ctx *gin.Context) {
-	var user models.User
+	span := startSpan(ctx, "user.SignUp")
+	defer span.End()
+	var body struct {
+		models.User
+		Invite string `json:"invite"`
+	}
 	// Get the JSON body and decode into user struct
-	err := ctx.BindJSON(&user)
+	err := ctx.BindJSON(&body)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "bad request",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	user := body.User
+
+	// check the registration mode and the invite code
+	invite, err := registrationInvite(ctx, u.inviteRepo, body.Invite)
+	if err != nil {
+		apierror.Forbidden(ctx, err.Error())
 		return
 	}
 
 	// check if valid username
 	if !utils.IsValidUserName(user.Username) {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid username",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "invalid username")
 		return
 	}
 	user.Username = strings.ToLower(user.Username)
@@ -82,45 +122,50 @@ func (u *user) SignUp(ctx *gin.Context) {
 	// Validate Password
 	ok := utils.IsValidPassword(user.Password)
 	if !ok {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error":   "bad request",
-			"message": "bad password",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "bad password")
 		return
 	}
 
 	user.Role = "user"
 	user.Level = 1
+	user.EmailVerified = false
+	if invite.ID != 0 {
+		applyInvite(&user, invite)
+	}
 
 	// Hash the password before storing
 	user.Password, err = utils.HashAndSalt(user.Password)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+		apierror.Internal(ctx, err)
 		return
 	}
 
+	// Count the use of the invite, it may have been used up meanwhile
+	if invite.ID != 0 {
+		err = u.inviteRepo.UseInvite(ctx, invite.ID)
+		if err != nil {
+			apierror.Forbidden(ctx, "invalid invite code")
+			return
+		}
+	}
+
 	// Create the user
-	err = u.userRepo.CreateUser(&user)
+	err = u.userRepo.CreateUser(ctx, &user)
 	if err != nil {
-		ctx.JSON(http.StatusConflict, gin.H{
-			"error": err.Error(),
-		})
+		if invite.ID != 0 {
+			if err := u.inviteRepo.ReleaseInvite(ctx, invite.ID); err != nil {
+				logger.Ctx(ctx).Error().Err(err).Msg("failed to release invite")
+			}
+		}
+		apierror.FromRepository(ctx, err)
 		return
 	}
 
-	// First User will be admin
-	if user.ID == 1 {
-		user.Role = "admin"
-		user.Level = 4
-		err = u.userRepo.UpdateUser(&user)
+	// Send the verification link, the account is created anyway
+	if user.Email != "" {
+		err = sendUserToken(ctx, u.tokenRepo, u.mailer, user, models.TokenPurposeVerify)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Internal Server Error",
-			})
+			logger.Ctx(ctx).Error().Err(err).Msg("failed to send verification email")
 		}
 	}
 
@@ -145,67 +190,132 @@ func (u *user) SignUp(ctx *gin.Context) {
 
 // SignIn logs in a user
 func (u *user) SignIn(ctx *gin.Context) {
+	span := startSpan(ctx, "user.SignIn")
+	defer span.End()
 	var creds models.Credentials
 	var user models.User
 	// Get the JSON body and decode into creds struct
 	err := ctx.BindJSON(&creds)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		apierror.BadRequest(ctx, "bad request")
 		return
 	}
 
 	// Trim spaces
-	user.Username = strings.ToLower(user.Username)
-	user.Username = strings.TrimSpace(user.Username)
-	user.Email = strings.ToLower(user.Email)
-	user.Email = strings.TrimSpace(user.Email)
+	creds.Username = strings.ToLower(creds.Username)
+	creds.Username = strings.TrimSpace(creds.Username)
+	creds.Email = strings.ToLower(creds.Email)
+	creds.Email = strings.TrimSpace(creds.Email)
 
 	if (creds.Email == "" && creds.Username == "") || creds.Password == "" {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "email or password cannot be empty",
-		})
+		apierror.Unauthorized(ctx, "email or password cannot be empty")
 		return
 	}
-	// Get the expected password from the database
-	user, err = u.userRepo.GetUserByUsername(creds.Username)
-	if err != nil || user.ID == 0 {
-		user, err = u.userRepo.GetUserByEmail(creds.Email) // error
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid credentials",
-			})
-			ctx.Abort()
-			return
-		}
+
+	// Refuse the attempt while the client is backing off
+	clientIP := ctx.ClientIP()
+	if !u.allowLogin(ctx, "", clientIP) {
+		metrics.FailedLogins.WithLabelValues("locked_out").Inc()
+		return
+	}
+
+	// Get the expected password from the database, the email is only tried
+	// when sent, or an unknown username would match the users without email
+	err = repository.ErrNotFound
+	if creds.Username != "" {
+		user, err = u.userRepo.GetUserByUsername(ctx, creds.Username)
+	}
+	if err != nil && creds.Email != "" {
+		user, err = u.userRepo.GetUserByEmail(ctx, creds.Email)
+	}
+	if err != nil {
+		u.guard.Fail(ctx, "", clientIP)
+		metrics.FailedLogins.WithLabelValues("invalid_credentials").Inc()
+		apierror.Unauthorized(ctx, "invalid credentials")
+		return
+	}
+
+	// the failures are counted by account, whether it is named by its
+	// username or its email
+	account := lockoutKey(user)
+	if !u.allowLogin(ctx, account, clientIP) {
+		metrics.FailedLogins.WithLabelValues("locked_out").Inc()
+		return
 	}
 
 	// if user is deleted then return unauthorized
 	if user.DeletedAt.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "user is deleted",
-		})
-		ctx.Abort()
+		u.guard.Fail(ctx, account, clientIP)
+		metrics.FailedLogins.WithLabelValues("invalid_credentials").Inc()
+		apierror.Unauthorized(ctx, "user is deleted")
 		return
 	}
 
 	// Validate the password
 	valid := utils.VerifyHash(creds.Password, user.Password)
 	if !valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid Password",
-		})
-		ctx.Abort()
+		u.guard.Fail(ctx, account, clientIP)
+		metrics.FailedLogins.WithLabelValues("invalid_credentials").Inc()
+		apierror.Unauthorized(ctx, "Invalid Password")
 		return
 	}
+	u.guard.Succeed(account)
 
+	// upgrade the hash while the password is at hand
+	if utils.NeedsRehash(user.Password) {
+		hash, err := utils.HashAndSalt(creds.Password)
+		if err == nil {
+			err = u.userRepo.UpdatePassword(ctx, user.ID, hash)
+		}
+		if err != nil {
+			logger.Ctx(ctx).Error().Err(err).Msgf("failed to rehash the password of %s", user.Username)
+		}
+	}
+
+	// suspended users can't sign in, and the account has to be restored
+	// first during the grace period
+	if err := accountBlocked(user); err != nil {
+		var details interface{}
+		if user.DeletionDueAt.Valid {
+			details = gin.H{"deletion_due_at": user.DeletionDueAt.Time}
+		}
+		apierror.Abort(ctx, http.StatusForbidden, apierror.CodeForbidden, err.Error(), details)
+		return
+	}
+	// the password has to be reset first when an admin requested it
+	if user.ResetRequired {
+		apierror.Forbidden(ctx, "password reset required")
+		return
+	}
+
+	// if email verification is required then return forbidden
+	if requireVerifiedEmail && !user.EmailVerified {
+		apierror.Forbidden(ctx, "email is not verified")
+		return
+	}
+
+	// Create the token and set the cookie
+	tokenString, err := issueToken(ctx, user)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	metrics.Logins.WithLabelValues("password").Inc()
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"token":  tokenString,
+	})
+}
+
+// issueToken creates a new token for the user and sets it as cookie
+func issueToken(ctx *gin.Context, user models.User) (string, error) {
 	// expires in  5 minutes
 	issuedAt := time.Now()
 	expiresAt := time.Now().Add(5 * time.Minute)
 	// Create the JWT claims, which includes the username and expiry time
 	claims := &models.Claims{
-		Username: creds.Username,
+		UserID:   user.ID,
+		Username: user.Username,
 		Role:     user.Role,
 		Level:    user.Level,
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -214,15 +324,10 @@ func (u *user) SignIn(ctx *gin.Context) {
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
-	// Declare the token with the algorithm used for signing, and the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// Create the JWT string
-	tokenString, err := token.SignedString([]byte(jwtKey))
+	// Sign the claims with the current signing key
+	tokenString, err := auth.SignToken(claims)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
-		return
+		return "", err
 	}
 
 	// Get Hostname
@@ -233,108 +338,47 @@ func (u *user) SignIn(ctx *gin.Context) {
 	// Finally, we set the client cookie for "token" as the JWT we just generated
 	// we also set an expiry time which is the same as the token itself
 	// set cookie with name "token" and value "tokenString"
-	ctx.SetCookie("token", tokenString, utils.ToMaxAge(expiresAt), "/", hostname, false, true)
-	ctx.JSON(http.StatusOK, gin.H{
-		"status": "success",
-		"token":  tokenString,
-	})
+	ctx.SetCookie("token", tokenString, utils.ToMaxAge(expiresAt), "/", hostname, utils.SecureCookies, true)
+	return tokenString, nil
 }
 
 // RefreshToken refreshes the token
 func (u *user) RefreshToken(ctx *gin.Context) {
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	// if err != nil {
-	// 	if err == http.ErrNoCookie {
-	// 		ctx.JSON(http.StatusUnauthorized, gin.H{
-	// 			"error": "cookie not found",
-	// 		})
-	// 		return
-	// 	}
-	// 	ctx.JSON(http.StatusBadRequest, gin.H{
-	// 		"error": "bad cookie",
-	// 	})
-	// }
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+	span := startSpan(ctx, "user.RefreshToken")
+	defer span.End()
+	principal, ok := caller(ctx)
+	if !ok {
 		return
 	}
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
 
-			return
-		}
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "bad token",
-		})
-		ctx.Abort()
+	// We ensure that a new token is not issued until enough time has elapsed
+	// In this case, a new token will only be issued if the old token is within
+	// 60 seconds of expiry. Otherwise, return a bad request status
+	if time.Until(principal.ExpiresAt) > 60*time.Second {
+		apierror.Unauthorized(ctx, "bad request")
 		return
 	}
 
-	// check if token is expired
-	if time.Now().Unix() > claims.ExpiresAt.Unix() {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "token expired",
-		})
-		ctx.Abort()
+	// Suspended or deleted users and the users who have to reset their
+	// password don't get a new token, role and level changes made by an
+	// admin apply from now on
+	user, err := u.userRepo.GetUser(ctx, int(principal.UserID))
+	if err != nil || accountBlocked(user) != nil || user.ResetRequired {
+		apierror.Unauthorized(ctx, "invalid token")
 		return
 	}
-
-	// We ensure that a new token is not issued until enough time has elapsed
-	// In this case, a new token will only be issued if the old token is within
-	// 30 seconds of expiry. Otherwise, return a bad request status
-	if time.Until(claims.ExpiresAt.Time) > 60*time.Second {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "bad request",
-		})
-		ctx.Abort()
+	// sessions are revoked by a password change
+	if principal.Revoked(user) {
+		apierror.Unauthorized(ctx, "invalid token")
 		return
 	}
 
 	// Now, create a new token for the current use, with a renewed expiration time
-	issuedAt := time.Now()
-	expiresAt := time.Now().Add(5 * time.Minute)
-	claims.IssuedAt = jwt.NewNumericDate(issuedAt)
-	claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err = token.SignedString([]byte(jwtKey))
+	tokenString, err := issueToken(ctx, user)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+		apierror.Internal(ctx, err)
 		return
 	}
-
-	hostname := ctx.Request.Host
-	if strings.Contains(hostname, ":") {
-		hostname = strings.Split(hostname, ":")[0]
-	}
-	// Set cookie with name "token" and value "tokenString"
-	ctx.SetCookie("token", tokenString, utils.ToMaxAge(expiresAt), "/", hostname, false, true)
 	ctx.JSON(http.StatusOK, gin.H{
 		"status": "success",
 		"token":  tokenString,
@@ -343,13 +387,15 @@ func (u *user) RefreshToken(ctx *gin.Context) {
 
 // SignOut logs out a user
 func (u *user) SignOut(ctx *gin.Context) {
+	span := startSpan(ctx, "user.SignOut")
+	defer span.End()
 
 	hostname := ctx.Request.Host
 	if strings.Contains(hostname, ":") {
 		hostname = strings.Split(hostname, ":")[0]
 	}
 	// remove the token from the cookies
-	ctx.SetCookie("token", "", -1, "/", hostname, false, true)
+	ctx.SetCookie("token", "", -1, "/", hostname, utils.SecureCookies, true)
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "user logged out",
 	})
@@ -359,180 +405,82 @@ func (u *user) SignOut(ctx *gin.Context) {
 
 // UserDetails returns the user details
 func (u *user) UserDetails(ctx *gin.Context) {
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
-		return
-	}
-
-	user, err := u.userRepo.GetUserByUsername(claims.Username)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+	span := startSpan(ctx, "user.UserDetails")
+	defer span.End()
+	user, ok := u.caller(ctx)
+	if !ok {
 		return
 	}
-
-	userinfo := map[string]interface{}{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"first_name":  user.FirstName,
-		"middle_name": user.MiddleName,
-		"last_name":   user.LastName,
-		"full_name":   strings.TrimSpace(user.FirstName + " " + user.MiddleName + " " + user.LastName),
-		"dob":         user.DOB,
-		"created_at":  user.CreatedAt,
-		"deleted_at":  user.DeletedAt,
-	}
-
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  "success",
 		"message": "Welcome, " + user.FirstName + "!",
-		"user":    userinfo,
+		"user":    userDetails(user),
 	})
 }
 
-// ViewUser returns the public user details
+// ViewUser returns the public user details, the users see their own
+// details in full
 func (u *user) ViewUser(ctx *gin.Context) {
+	span := startSpan(ctx, "user.ViewUser")
+	defer span.End()
 	// get the username param from context
 	username := ctx.Param("username")
 	// get the user from the database
-	user, err := u.userRepo.GetUserByUsername(username)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "user not found",
-			"log":   err,
-		})
-		ctx.Abort()
-		return
-	}
-
-	// Check if the user is logged in
-	tokenString, _ := ctx.Cookie("token")
-	if tokenString == "" {
-		tkn, _ := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		tokenString = tkn
-	}
-
-	// check if user is found or not
-	if user.ID > 0 && !user.DeletedAt.Valid {
-		// if user is found, return the user info
-		if tokenString != "" {
-			claims := &models.Claims{}
-			token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(jwtKey), nil
-			})
-			if !token.Valid {
-				ctx.JSON(http.StatusUnauthorized, gin.H{
-					"error": "invalid token",
-				})
-				ctx.Abort()
+	user, err := u.userRepo.GetUserByUsername(ctx, username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// old usernames of renamed users redirect for a while
+		redirect, rerr := u.userRepo.GetUsernameRedirect(ctx, username)
+		if rerr == nil {
+			renamed, rerr := u.userRepo.GetUser(ctx, int(redirect.UserID))
+			if rerr == nil {
+				ctx.Redirect(http.StatusFound, "/user/"+renamed.Username)
 				return
 			}
-			if claims.Username == username {
-				userinfo := map[string]interface{}{
-					"id":          user.ID,
-					"username":    user.Username,
-					"email":       user.Email,
-					"first_name":  user.FirstName,
-					"middle_name": user.MiddleName,
-					"last_name":   user.LastName,
-					"full_name":   strings.TrimSpace(user.FirstName + " " + user.MiddleName + " " + user.LastName),
-					"dob":         user.DOB,
-					"created_at":  user.CreatedAt,
-					"deleted_at":  user.DeletedAt,
-				}
-
-				ctx.JSON(http.StatusOK, gin.H{
-					"status":  "success",
-					"message": "Welcome, " + user.FirstName + "!",
-					"user":    userinfo,
-				})
-			}
-		}
-		userinfo := map[string]string{
-			"username":   user.Username,
-			"full_name":  user.FirstName + " " + user.MiddleName + " " + user.LastName,
-			"email":      user.Email,
-			"created_at": user.CreatedAt.Format("2006-01-02 15:04:05"),
 		}
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && user.DeletedAt.Valid) {
+		apierror.NotFound(ctx, "user not found")
+		return
+	}
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+
+	// the principal is set by the optional auth middleware
+	if principal, ok := auth.PrincipalFrom(ctx); ok && principal.UserID == user.ID {
 		ctx.JSON(http.StatusOK, gin.H{
-			"status": "success",
-			"user":   userinfo,
+			"status":  "success",
+			"message": "Welcome, " + user.FirstName + "!",
+			"user":    userDetails(user),
 		})
+		return
 	}
-
-	// if user is not found, return error
-	ctx.JSON(http.StatusNotFound, gin.H{
-		"error": "user not found",
+	userinfo := map[string]string{
+		"username":   user.Username,
+		"full_name":  user.FirstName + " " + user.MiddleName + " " + user.LastName,
+		"email":      user.Email,
+		"created_at": user.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"user":   userinfo,
 	})
 }
 
 // UpdateUser updates the user details
 func (u *user) UpdateUser(ctx *gin.Context) {
+	span := startSpan(ctx, "user.UpdateUser")
+	defer span.End()
 	var userinfo map[string]interface{}
 	// Get the JSON body and decode into userinfo struct
 	err := ctx.BindJSON(&userinfo)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
-		return
-	}
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
-	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		tokenString = tkn
-	}
-
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
-	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "bad request")
 		return
 	}
-
-	user, err := u.userRepo.GetUserByUsername(claims.Username)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+	user, ok := u.caller(ctx)
+	if !ok {
 		return
 	}
 
@@ -545,163 +493,376 @@ func (u *user) UpdateUser(ctx *gin.Context) {
 	if userinfo["last_name"] != nil {
 		user.LastName = userinfo["last_name"].(string)
 	}
-	if userinfo["email"] != nil {
+	if userinfo["email"] != nil && userinfo["email"].(string) != user.Email {
 		user.Email = userinfo["email"].(string)
+		user.EmailVerified = false
 	}
-	if userinfo["username"] != nil {
-		user.Username = userinfo["username"].(string)
+	// renaming moves the notes and keeps the old profile URL, it has its
+	// own endpoint
+	if userinfo["username"] != nil && userinfo["username"] != user.Username {
+		apierror.BadRequest(ctx, "use PATCH /user/me/username to change the username")
+		return
 	}
 	if userinfo["dob"] != nil {
 		user.DOB = userinfo["dob"].(time.Time)
 	}
 
 	// Update the user
-	err = u.userRepo.UpdateUser(&user)
+	err = u.userRepo.UpdateUser(ctx, &user)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+		apierror.FromRepository(ctx, err)
 		return
 	}
-	// Return the updated user
-	userinfo = map[string]interface{}{
-		"id":          user.ID,
-		"username":    user.Username,
-		"email":       user.Email,
-		"first_name":  user.FirstName,
-		"middle_name": user.MiddleName,
-		"last_name":   user.LastName,
-		"full_name":   strings.TrimSpace(user.FirstName + " " + user.MiddleName + " " + user.LastName),
-		"dob":         user.DOB,
-		"created_at":  user.CreatedAt,
-		"deleted_at":  user.DeletedAt,
+	// the token carries the user details, issue a new one
+	tokenString, err := issueToken(ctx, user)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
 	}
 
-	// Generate new JWT Token
-	issuedAt := time.Now()
-	expiresAt := time.Now().Add(5 * time.Minute)
-	// Create the JWT claims, which includes the username and expiry time
-	claims = &models.Claims{
-		Username: user.Username,
-		Role:     user.Role,
-		Level:    user.Level,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt: jwt.NewNumericDate(issuedAt),
-			// In JWT, the expiry time is expressed as unix milliseconds
-			ExpiresAt: jwt.NewNumericDate(expiresAt),
-		},
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "User updated successfully",
+		"token":   tokenString,
+		"user":    userDetails(user),
+	})
+}
+
+// ChangeUsername renames the user, its notes follow since they are owned by
+// id and the old profile URL redirects to the new one for a while
+func (u *user) ChangeUsername(ctx *gin.Context) {
+	span := startSpan(ctx, "user.ChangeUsername")
+	defer span.End()
+	var body struct {
+		Username string `json:"username"`
 	}
-	// Declare the token with the algorithm used for signing, and the claims
-	token = jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	// Create the JWT string
-	tokenString, err = token.SignedString([]byte(jwtKey))
+	err := ctx.BindJSON(&body)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	username := strings.ToLower(strings.TrimSpace(body.Username))
+	if !utils.IsValidUserName(username) {
+		apierror.BadRequest(ctx, "invalid username")
 		return
 	}
 
-	hostname := ctx.Request.Host
-	if strings.Contains(hostname, ":") {
-		hostname = strings.Split(hostname, ":")[0]
+	user, ok := u.caller(ctx)
+	if !ok {
+		return
+	}
+	if username == user.Username {
+		apierror.BadRequest(ctx, "this is already your username")
+		return
+	}
+
+	oldUsername := user.Username
+	err = u.userRepo.ChangeUsername(ctx, user.ID, username, time.Now().Add(usernameRedirectPeriod))
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
 	}
-	// Set cookie with name "token" and value "tokenString"
-	ctx.SetCookie("token", tokenString, utils.ToMaxAge(expiresAt), "/", hostname, false, true)
+	logger.Ctx(ctx).Info().Msgf("user %s renamed to %s", oldUsername, username)
 
+	// the token carries the username, issue a new one
+	user.Username = username
+	tokenString, err := issueToken(ctx, user)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"message":  "username changed",
+		"username": username,
+		"token":    tokenString,
+	})
+}
+
+// ChangePassword changes the password of the user, it requires the current
+// password and signs out the other sessions
+func (u *user) ChangePassword(ctx *gin.Context) {
+	span := startSpan(ctx, "user.ChangePassword")
+	defer span.End()
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+	err := ctx.BindJSON(&body)
+	if err != nil {
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+
+	user, ok := u.caller(ctx)
+	if !ok {
+		return
+	}
+
+	// the current password is guessed as hard as at sign in
+	clientIP := ctx.ClientIP()
+	account := lockoutKey(user)
+	if !u.allowLogin(ctx, account, clientIP) {
+		return
+	}
+	if !utils.VerifyHash(body.CurrentPassword, user.Password) {
+		u.guard.Fail(ctx, account, clientIP)
+		apierror.Unauthorized(ctx, "Invalid Password")
+		return
+	}
+	u.guard.Succeed(account)
+
+	if !utils.IsValidPassword(body.Password) {
+		apierror.BadRequest(ctx, "bad password")
+		return
+	}
+	user.Password, err = utils.HashAndSalt(body.Password)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
+	user.SessionsRevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	err = u.userRepo.UpdateUser(ctx, &user)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("user %s changed the password", user.Username)
+
+	// keep this session, the token is issued after the revocation
+	tokenString, err := issueToken(ctx, user)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return
+	}
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": "User updated successfully",
+		"message": "password changed",
 		"token":   tokenString,
-		"user":    userinfo,
 	})
 }
 
 // DeleteUser deletes a user
 func (u *user) DeleteUser(ctx *gin.Context) {
+	span := startSpan(ctx, "user.DeleteUser")
+	defer span.End()
 	var creds models.Credentials
-	var user models.User
 	// Get the JSON body and decode into creds struct
 	err := ctx.BindJSON(&creds)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-		})
+		apierror.BadRequest(ctx, "bad request")
 		return
 	}
-	// Get cookie "token"
-	tokenString, err := ctx.Cookie("token")
+	user, ok := u.caller(ctx)
+	if !ok {
+		return
+	}
+
+	// Verify the password before deleting the user
+	if creds.Password == "" {
+		apierror.BadRequest(ctx, "password is required")
+		return
+	}
+	valid := utils.VerifyHash(creds.Password, user.Password)
+	if !valid {
+		apierror.Unauthorized(ctx, "Invalid Password")
+		return
+	}
+
+	// organizations would be left without owner
+	orgs, err := u.orgRepo.GetSoleOwnedOrgs(ctx, user.ID)
 	if err != nil {
-		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-		if err != nil {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	if len(orgs) > 0 {
+		names := make([]string, 0, len(orgs))
+		for _, org := range orgs {
+			names = append(names, org.Name)
 		}
-		tokenString = tkn
+		apierror.Abort(ctx, http.StatusConflict, apierror.CodeConflict,
+			"transfer the ownership or delete these organizations first: "+strings.Join(names, ", "),
+			gin.H{"organizations": names})
+		return
 	}
 
-	claims := &models.Claims{}
-	token, _ := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtKey), nil
+	// The account is purged at the end of the grace period
+	dueAt := time.Now().Add(deletionGracePeriod)
+	err = u.userRepo.ScheduleDeletion(ctx, user.ID, dueAt)
+	if err != nil {
+		apierror.FromRepository(ctx, err)
+		return
+	}
+	logger.Ctx(ctx).Info().Msgf("user %s scheduled for deletion on %s", user.Username, dueAt.Format(time.RFC3339))
+	// remove the token from the cookies
+	ctx.SetCookie("token", "", -1, "/", "", utils.SecureCookies, true)
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":          "success",
+		"message":         "User scheduled for deletion",
+		"deletion_due_at": dueAt,
 	})
-	if !token.Valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "invalid token",
-		})
-		ctx.Abort()
+}
+
+// accountBlocked returns why the account of a user can't sign in or get
+// tokens, whatever the way, nil when it can
+func accountBlocked(user models.User) error {
+	switch {
+	case user.DeletedAt.Valid:
+		return refusal("user is deleted")
+	case user.SuspendedAt.Valid:
+		return refusal("user is suspended")
+	case user.DeletionDueAt.Valid:
+		return refusal("account is scheduled for deletion")
+	}
+	return nil
+}
+
+// refusal is an error refusing a request, its message is shown to the user
+type refusal string
+
+func (r refusal) Error() string {
+	return string(r)
+}
+
+// abortRefused answers 403 with the message of a refusal, and the other
+// errors as the repository errors
+func abortRefused(ctx *gin.Context, err error) {
+	var r refusal
+	if errors.As(err, &r) {
+		apierror.Forbidden(ctx, r.Error())
 		return
 	}
+	apierror.FromRepository(ctx, err)
+}
+
+// caller returns the user of the principal set by the auth middleware. The
+// accounts which can't sign in are refused, an account scheduled for
+// deletion is only restored with its credentials.
+func (u *user) caller(ctx *gin.Context) (models.User, bool) {
+	principal, ok := caller(ctx)
+	if !ok {
+		return models.User{}, false
+	}
+	user, err := u.userRepo.GetUser(ctx, int(principal.UserID))
+	if err != nil || accountBlocked(user) != nil {
+		apierror.Unauthorized(ctx, "invalid token")
+		return models.User{}, false
+	}
+	return user, true
+}
 
-	user, err = u.userRepo.GetUserByUsername(claims.Username)
+// userDetails returns the user details shown to the user
+func userDetails(user models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          user.ID,
+		"username":    user.Username,
+		"email":       user.Email,
+		"first_name":  user.FirstName,
+		"middle_name": user.MiddleName,
+		"last_name":   user.LastName,
+		"full_name":   strings.TrimSpace(user.FirstName + " " + user.MiddleName + " " + user.LastName),
+		"dob":         user.DOB,
+		"created_at":  user.CreatedAt,
+		"deleted_at":  user.DeletedAt,
+	}
+}
+
+// RestoreUser cancels the scheduled deletion of a user, it takes the
+// credentials since the user can't sign in meanwhile
+func (u *user) RestoreUser(ctx *gin.Context) {
+	span := startSpan(ctx, "user.RestoreUser")
+	defer span.End()
+	var creds models.Credentials
+	err := ctx.BindJSON(&creds)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+		apierror.BadRequest(ctx, "bad request")
+		return
+	}
+	clientIP := ctx.ClientIP()
+	if !u.allowLogin(ctx, "", clientIP) {
 		return
 	}
 
-	// Verify the password before deleting the user
-	if creds.Password == "" {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "password is required",
-		})
-		ctx.Abort()
+	user, err := u.findUser(ctx, creds)
+	if err != nil {
+		u.guard.Fail(ctx, "", clientIP)
+		apierror.Unauthorized(ctx, "Invalid Password")
 		return
 	}
-	valid := utils.VerifyHash(creds.Password, user.Password)
-	if !valid {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid Password",
-		})
-		ctx.Abort()
+	account := lockoutKey(user)
+	if !u.allowLogin(ctx, account, clientIP) {
 		return
 	}
+	if user.DeletedAt.Valid || !utils.VerifyHash(creds.Password, user.Password) {
+		u.guard.Fail(ctx, account, clientIP)
+		apierror.Unauthorized(ctx, "Invalid Password")
+		return
+	}
+	u.guard.Succeed(account)
 
-	err = u.userRepo.DeleteUser(user.ID)
+	if !user.DeletionDueAt.Valid {
+		apierror.BadRequest(ctx, "account is not scheduled for deletion")
+		return
+	}
+	err = u.userRepo.RestoreUser(ctx, user.ID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Internal Server Error",
-		})
-		ctx.Abort()
+		apierror.FromRepository(ctx, err)
 		return
 	}
+	logger.Ctx(ctx).Info().Msgf("user %s restored", user.Username)
 	ctx.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": "User deleted successfully",
+		"message": "account restored",
 	})
-	// Redirect to the home page
-	ctx.Redirect(http.StatusMovedPermanently, "/")
 }
 
-// NewUser initializes a new user controller
-func NewUser(userRepo repository.UserRepo) User {
-	return &user{
-		userRepo: userRepo,
+// lockoutKey returns the key of the login failures of a user, the same
+// whether it signs in with its username or its email
+func lockoutKey(user models.User) string {
+	return "user:" + strconv.FormatUint(uint64(user.ID), 10)
+}
+
+// allowLogin answers 429 while the account, when not empty, or the client
+// is backing off
+func (u *user) allowLogin(ctx *gin.Context, account, clientIP string) bool {
+	wait := u.guard.RetryAfter(account, clientIP)
+	if wait <= 0 {
+		return true
+	}
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	apierror.Abort(ctx, http.StatusTooManyRequests, apierror.CodeRateLimited, "too many login attempts, try again later", nil)
+	return false
+}
+
+// UnlockUser lifts the login lockout of a user
+func (u *user) UnlockUser(ctx *gin.Context) {
+	span := startSpan(ctx, "user.UnlockUser")
+	defer span.End()
+	username := strings.ToLower(strings.TrimSpace(ctx.Param("username")))
+	user, err := u.userRepo.GetUserByUsername(ctx, username)
+	if err != nil {
+		apierror.NotFound(ctx, "user not found")
+		return
 	}
+
+	u.guard.Unlock(ctx, lockoutKey(user))
+	ctx.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "user unlocked",
+	})
+}
+
+// NewUser initializes a new user controller
+func NewUser(userRepo repository.UserRepo, tokenRepo repository.TokenRepo, inviteRepo repository.InviteRepo, orgRepo repository.OrgRepo, mailer mailer.Mailer, guard *lockout.Guard) User {
+	u := &user{
+		userRepo:   userRepo,
+		tokenRepo:  tokenRepo,
+		inviteRepo: inviteRepo,
+		orgRepo:    orgRepo,
+		mailer:     mailer,
+		guard:      guard,
+		mails:      make(chan mailJob, mailQueueSize),
+	}
+	go u.sendMails()
+	return u
 }
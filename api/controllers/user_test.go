@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// lockoutPolicy locks an account out after two failures, without backoff
+// between the attempts
+var lockoutPolicy = config.Lockout{Threshold: 2, IPThreshold: 100, Duration: time.Hour, BackoffBase: time.Nanosecond}
+
+func TestSignInLocksOutTheAccount(t *testing.T) {
+	f := newUserFixture(t, lockoutPolicy)
+
+	for i := 0; i < 2; i++ {
+		if rec := f.do(http.MethodPost, "/auth/login", `{"email":"alice@example.com","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("wrong password = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	}
+	// the username and the email share the failures
+	if rec := f.do(http.MethodPost, "/auth/login", `{"username":"alice","password":"`+alicePassword+`"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("locked out sign in = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestSignInUnknownUsernameLocksOutNobody(t *testing.T) {
+	f := newUserFixture(t, lockoutPolicy)
+	// users created by an admin or the CLI may have no email
+	hash, err := utils.HashAndSalt(alicePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nomail := models.User{Username: "nomail", Password: hash, Role: "user", Level: 1}
+	err = f.userRepo.CreateUser(context.Background(), &nomail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rec := f.do(http.MethodPost, "/auth/login", `{"username":"ghost","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("unknown username = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	}
+	if rec := f.do(http.MethodPost, "/auth/login", `{"username":"nomail","password":"`+alicePassword+`"}`); rec.Code != http.StatusOK {
+		t.Errorf("sign in of the user without email = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+}
+
+func TestSignInUpgradesBcryptHashes(t *testing.T) {
+	f := newUserFixture(t, config.Lockout{})
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte(alicePassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.userRepo.UpdatePassword(ctx, f.alice.ID, string(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := f.do(http.MethodPost, "/auth/login", `{"username":"alice","password":"`+alicePassword+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("sign in = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	alice, err := f.userRepo.GetUser(ctx, int(f.alice.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(alice.Password, "$argon2id$") || !utils.VerifyHash(alicePassword, alice.Password) {
+		t.Errorf("password hash = %q, want the Argon2id hash of the password", alice.Password)
+	}
+}
+
+// signIn signs alice in and returns her token
+func (f *userFixture) signIn(t *testing.T) string {
+	t.Helper()
+	rec := f.do(http.MethodPost, "/auth/login", `{"username":"alice","password":"`+alicePassword+`"}`)
+	var body struct {
+		Token string `json:"token"`
+	}
+	err := json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || err != nil || body.Token == "" {
+		t.Fatalf("sign in = %d %s, want a token", rec.Code, rec.Body)
+	}
+	return body.Token
+}
+
+// doAs sends a request with the token and the JSON body, when not empty
+func (f *userFixture) doAs(token, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	f.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAccountScheduledForDeletion(t *testing.T) {
+	f := newUserFixture(t, config.Lockout{})
+	token := f.signIn(t)
+
+	if rec := f.doAs(token, http.MethodDelete, "/api/user", `{"password":"`+alicePassword+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("delete = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	// the account is frozen until restored or purged
+	if rec := f.doAs(token, http.MethodGet, "/api/user", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("details with the token of an account scheduled for deletion = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := f.doAs(token, http.MethodDelete, "/api/user", `{"password":"`+alicePassword+`"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("second delete = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := f.do(http.MethodPost, "/auth/login", `{"username":"alice","password":"`+alicePassword+`"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("sign in = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// the restore takes the credentials
+	if rec := f.do(http.MethodPost, "/auth/restore", `{"username":"alice","password":"wrong"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("restore with a wrong password = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := f.do(http.MethodPost, "/auth/restore", `{"username":"alice","password":"`+alicePassword+`"}`); rec.Code != http.StatusOK {
+		t.Fatalf("restore = %d %s, want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+	if rec := f.doAs(token, http.MethodGet, "/api/user", ""); rec.Code != http.StatusOK {
+		t.Errorf("details after the restore = %d, want %d", rec.Code, http.StatusOK)
+	}
+	f.signIn(t)
+}
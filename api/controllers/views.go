@@ -6,6 +6,8 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/models"
 	"github.com/mrinjamul/gnote/utils"
 )
 
@@ -14,10 +16,13 @@ type Views interface {
 	About(ctx *gin.Context, fsRoot fs.FS)
 	Login(ctx *gin.Context, fsRoot fs.FS)
 	Register(ctx *gin.Context, fsRoot fs.FS)
+	Forgot(ctx *gin.Context, fsRoot fs.FS)
+	Reset(ctx *gin.Context, fsRoot fs.FS)
 	MyAccount(ctx *gin.Context, fsRoot fs.FS)
 	NotFound(ctx *gin.Context, fsRoot fs.FS)
 	Delete(ctx *gin.Context, fsRoot fs.FS)
 	DeleteNote(ctx *gin.Context, fsRoot fs.FS)
+	Admin(ctx *gin.Context, fsRoot fs.FS)
 }
 
 type views struct {
@@ -146,6 +151,40 @@ func (v *views) Register(ctx *gin.Context, fsRoot fs.FS) {
 	ctx.Data(http.StatusOK, "text/html; charset=utf-8", b)
 }
 
+// Forgot returns a forgot password page
+func (v *views) Forgot(ctx *gin.Context, fsRoot fs.FS) {
+	// Get forgot.html from fsRoot
+	forgot, err := fsRoot.Open("forgot.html")
+	if err != nil {
+		panic(err)
+	}
+	defer forgot.Close()
+	// Read the file
+	b, err := ioutil.ReadAll(forgot)
+	if err != nil {
+		panic(err)
+	}
+	// Write the content to the response
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", b)
+}
+
+// Reset returns a reset password page
+func (v *views) Reset(ctx *gin.Context, fsRoot fs.FS) {
+	// Get reset.html from fsRoot
+	reset, err := fsRoot.Open("reset.html")
+	if err != nil {
+		panic(err)
+	}
+	defer reset.Close()
+	// Read the file
+	b, err := ioutil.ReadAll(reset)
+	if err != nil {
+		panic(err)
+	}
+	// Write the content to the response
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", b)
+}
+
 // MyAccount returns a my account page
 func (v *views) MyAccount(ctx *gin.Context, fsRoot fs.FS) {
 	// check if token is present
@@ -250,6 +289,47 @@ func (v *views) DeleteNote(ctx *gin.Context, fsRoot fs.FS) {
 	ctx.Data(http.StatusOK, "text/html; charset=utf-8", b)
 }
 
+// Admin returns the admin dashboard, only to admins
+func (v *views) Admin(ctx *gin.Context, fsRoot fs.FS) {
+	// check if token is present
+	// Get cookie "token"
+	tokenString, err := ctx.Cookie("token")
+	if err != nil {
+		tokenString, err = utils.ParseToken(ctx.Request.Header.Get("Authorization"))
+		if err != nil {
+			// redirect to login page if not logged in
+			ctx.Redirect(http.StatusFound, "/login")
+			return
+		}
+	}
+	claims := &models.Claims{}
+	token, err := auth.ParseToken(tokenString, claims)
+	if err != nil || !token.Valid {
+		// redirect to login page if the token is expired
+		ctx.Redirect(http.StatusFound, "/login")
+		return
+	}
+	// hide the dashboard from other users
+	if claims.Role != "admin" {
+		v.NotFound(ctx, fsRoot)
+		return
+	}
+	// Get admin.html from fsRoot
+	admin, err := fsRoot.Open("admin.html")
+	if err != nil {
+		panic(err)
+	}
+	defer admin.Close()
+	// Read the file
+	b, err := ioutil.ReadAll(admin)
+	if err != nil {
+		panic(err)
+	}
+	// Write the content to the response
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Data(http.StatusOK, "text/html; charset=utf-8", b)
+}
+
 // NewViews returns a new Views
 func NewViews() Views {
 	return &views{}
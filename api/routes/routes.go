@@ -9,7 +9,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mrinjamul/gnote/api/services"
+	"github.com/mrinjamul/gnote/config"
 	"github.com/mrinjamul/gnote/middleware"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 // ViewsFs for static files
@@ -18,11 +20,40 @@ var ViewsFs embed.FS
 var (
 	StartTime time.Time
 	BootTime  time.Duration
+	// Config is the configuration of the server
+	Config *config.Config
 )
 
-func InitRoutes(routes *gin.Engine) {
+func InitRoutes(routes *gin.Engine) error {
 	// Initialize services
-	svc := services.NewServices()
+	var svc services.Services
+	var err error
+	// the routes using the database answer 503 while it is unreachable, the
+	// demo mode has none
+	requireDatabase := middleware.RequireDatabase()
+	if Config.Server.Demo {
+		svc, err = services.NewDemoServices(Config)
+		requireDatabase = func(c *gin.Context) { c.Next() }
+	} else {
+		svc, err = services.NewServices(Config)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Trace, log and count every request, including the refused ones, then
+	// answer 500 when a handler panics
+	routes.Use(otelgin.Middleware(Config.Tracing.ServiceName))
+	routes.Use(middleware.RequestID())
+	routes.Use(middleware.Logger())
+	routes.Use(middleware.Metrics())
+	routes.Use(middleware.Recovery())
+
+	// Security headers and CORS apply to the views and the API, then the
+	// cookie sessions are protected from cross-site requests
+	routes.Use(middleware.SecurityHeaders())
+	routes.Use(middleware.CORSMiddleware())
+	routes.Use(middleware.CSRF())
 
 	// Serve the frontend
 	// This will ensure that the web pages are served correctly
@@ -67,6 +98,14 @@ func InitRoutes(routes *gin.Engine) {
 	routes.GET("/register", func(ctx *gin.Context) {
 		svc.ViewService().Register(ctx, fsRoot)
 	})
+	// Forgot Password Page
+	routes.GET("/forgot", func(ctx *gin.Context) {
+		svc.ViewService().Forgot(ctx, fsRoot)
+	})
+	// Reset Password Page
+	routes.GET("/reset", func(ctx *gin.Context) {
+		svc.ViewService().Reset(ctx, fsRoot)
+	})
 	// My Account Page
 	routes.GET("/account", func(ctx *gin.Context) {
 		svc.ViewService().MyAccount(ctx, fsRoot)
@@ -80,6 +119,11 @@ func InitRoutes(routes *gin.Engine) {
 		svc.ViewService().DeleteNote(ctx, fsRoot)
 	})
 
+	// Admin Dashboard
+	routes.GET("/admin", func(ctx *gin.Context) {
+		svc.ViewService().Admin(ctx, fsRoot)
+	})
+
 	// Add 404 page
 	routes.NoRoute(func(ctx *gin.Context) {
 		svc.ViewService().NotFound(ctx, fsRoot)
@@ -91,27 +135,81 @@ func InitRoutes(routes *gin.Engine) {
 	routes.GET("/api/health", func(c *gin.Context) {
 		svc.HealthCheckService().HealthCheck(c, StartTime, BootTime)
 	})
+	// liveness and readiness probes
+	routes.GET("/livez", func(c *gin.Context) {
+		svc.HealthCheckService().Livez(c, StartTime)
+	})
+	routes.GET("/readyz", func(c *gin.Context) {
+		svc.HealthCheckService().Readyz(c)
+	})
+	// Prometheus metrics
+	if Config.Metrics.Enabled {
+		routes.GET("/metrics", func(c *gin.Context) {
+			svc.MetricsService().Metrics(c)
+		})
+	}
+
+	// public keys to verify the tokens
+	routes.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		svc.KeyService().JWKS(c)
+	})
 
 	auth := routes.Group("/auth")
+	auth.Use(requireDatabase)
 	{
 		auth.POST("/signup", func(c *gin.Context) {
 			svc.UserService().SignUp(c)
 		})
+		auth.GET("/registration", func(c *gin.Context) {
+			svc.InviteService().Registration(c)
+		})
+		auth.POST("/setup", func(c *gin.Context) {
+			svc.SetupService().Setup(c)
+		})
 		auth.POST("/login", func(c *gin.Context) {
 			svc.UserService().SignIn(c)
 		})
-		auth.POST("/refresh", func(c *gin.Context) {
+		auth.POST("/refresh", middleware.JWTAuth(), func(c *gin.Context) {
 			svc.UserService().RefreshToken(c)
 		})
 		auth.POST("/logout", func(c *gin.Context) {
 			svc.UserService().SignOut(c)
 		})
+		auth.POST("/restore", func(c *gin.Context) {
+			svc.UserService().RestoreUser(c)
+		})
+		auth.POST("/forgot", func(c *gin.Context) {
+			svc.UserService().ForgotPassword(c)
+		})
+		auth.POST("/reset", func(c *gin.Context) {
+			svc.UserService().ResetPassword(c)
+		})
+		auth.GET("/verify", func(c *gin.Context) {
+			svc.UserService().VerifyEmail(c)
+		})
+		auth.POST("/verify", func(c *gin.Context) {
+			svc.UserService().ResendVerification(c)
+		})
+		// single sign-on
+		auth.GET("/oidc/login", func(c *gin.Context) {
+			svc.SSOService().Login(c)
+		})
+		auth.GET("/oidc/callback", func(c *gin.Context) {
+			svc.SSOService().Callback(c)
+		})
+		auth.POST("/oidc/device", func(c *gin.Context) {
+			svc.SSOService().DeviceAuthorize(c)
+		})
+		auth.POST("/oidc/device/token", func(c *gin.Context) {
+			svc.SSOService().DeviceToken(c)
+		})
 
 	}
 
 	userRoute := routes.Group("/user")
+	userRoute.Use(requireDatabase)
 	{
-		userRoute.GET("/:username", func(ctx *gin.Context) {
+		userRoute.GET("/:username", middleware.OptionalJWTAuth(), func(ctx *gin.Context) {
 			svc.UserService().ViewUser(ctx)
 		})
 		userRoute.GET("/search", func(ctx *gin.Context) {
@@ -127,13 +225,58 @@ func InitRoutes(routes *gin.Engine) {
 		userRoute.PATCH("/me", middleware.JWTAuth(), func(ctx *gin.Context) {
 			svc.UserService().UpdateUser(ctx)
 		})
+		userRoute.PATCH("/me/username", middleware.JWTAuth(), func(ctx *gin.Context) {
+			svc.UserService().ChangeUsername(ctx)
+		})
+		userRoute.POST("/me/password", middleware.JWTAuth(), func(ctx *gin.Context) {
+			svc.UserService().ChangePassword(ctx)
+		})
 		userRoute.DELETE("/me", middleware.JWTAuth(), func(ctx *gin.Context) {
 			svc.UserService().DeleteUser(ctx)
 		})
 	}
+	admin := routes.Group("/admin")
+	admin.Use(requireDatabase, middleware.JWTAuthAdmin())
+	{
+		admin.GET("/users", func(ctx *gin.Context) {
+			svc.AdminService().ListUsers(ctx)
+		})
+		admin.GET("/users/:username", func(ctx *gin.Context) {
+			svc.AdminService().ViewUser(ctx)
+		})
+		admin.PATCH("/users/:username", func(ctx *gin.Context) {
+			svc.AdminService().UpdateUser(ctx)
+		})
+		admin.POST("/users/:username/suspend", func(ctx *gin.Context) {
+			svc.AdminService().SuspendUser(ctx)
+		})
+		admin.POST("/users/:username/unsuspend", func(ctx *gin.Context) {
+			svc.AdminService().UnsuspendUser(ctx)
+		})
+		admin.POST("/users/:username/reset", func(ctx *gin.Context) {
+			svc.AdminService().ForceReset(ctx)
+		})
+		admin.POST("/users/:username/unlock", func(ctx *gin.Context) {
+			svc.UserService().UnlockUser(ctx)
+		})
+		admin.GET("/storage", func(ctx *gin.Context) {
+			svc.AdminService().Storage(ctx)
+		})
+		admin.GET("/signups", func(ctx *gin.Context) {
+			svc.AdminService().Signups(ctx)
+		})
+		admin.GET("/invites", func(ctx *gin.Context) {
+			svc.InviteService().List(ctx)
+		})
+		admin.POST("/invites", func(ctx *gin.Context) {
+			svc.InviteService().Create(ctx)
+		})
+		admin.DELETE("/invites/:id", func(ctx *gin.Context) {
+			svc.InviteService().Revoke(ctx)
+		})
+	}
 	api := routes.Group("/api")
-	api.Use(middleware.CORSMiddleware())
-	api.Use(middleware.JWTAuth())
+	api.Use(requireDatabase, middleware.JWTAuth())
 	{
 		api.GET("/notes", func(c *gin.Context) {
 			svc.NoteService().ReadAll(c)
@@ -153,5 +296,53 @@ func InitRoutes(routes *gin.Engine) {
 		api.DELETE("/notes", func(c *gin.Context) {
 			svc.NoteService().DeleteByUsername(c)
 		})
+
+		// organizations
+		api.GET("/orgs", func(c *gin.Context) {
+			svc.OrgService().List(c)
+		})
+		api.POST("/orgs", func(c *gin.Context) {
+			svc.OrgService().Create(c)
+		})
+		api.DELETE("/orgs/:org", func(c *gin.Context) {
+			svc.OrgService().Delete(c)
+		})
+		api.GET("/orgs/:org/members", func(c *gin.Context) {
+			svc.OrgService().Members(c)
+		})
+		api.PATCH("/orgs/:org/members/:username", func(c *gin.Context) {
+			svc.OrgService().UpdateMember(c)
+		})
+		api.DELETE("/orgs/:org/members/:username", func(c *gin.Context) {
+			svc.OrgService().RemoveMember(c)
+		})
+		api.GET("/orgs/:org/invites", func(c *gin.Context) {
+			svc.OrgService().Invites(c)
+		})
+		api.POST("/orgs/:org/invites", func(c *gin.Context) {
+			svc.OrgService().Invite(c)
+		})
+		api.DELETE("/orgs/:org/invites/:id", func(c *gin.Context) {
+			svc.OrgService().RevokeInvite(c)
+		})
+		api.POST("/orgs/:org/join", func(c *gin.Context) {
+			svc.OrgService().Join(c)
+		})
+		api.GET("/orgs/:org/notes", func(c *gin.Context) {
+			svc.OrgService().ReadNotes(c)
+		})
+		api.POST("/orgs/:org/notes", func(c *gin.Context) {
+			svc.OrgService().CreateNote(c)
+		})
+		api.GET("/orgs/:org/notes/:id", func(c *gin.Context) {
+			svc.OrgService().ReadNote(c)
+		})
+		api.PUT("/orgs/:org/notes/:id", func(c *gin.Context) {
+			svc.OrgService().UpdateNote(c)
+		})
+		api.DELETE("/orgs/:org/notes/:id", func(c *gin.Context) {
+			svc.OrgService().DeleteNote(c)
+		})
 	}
+	return nil
 }
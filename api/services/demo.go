@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+const (
+	// demoPassword is the password of the demo accounts
+	demoPassword = "Demo@1234"
+)
+
+// demoUsers are the accounts of the demo mode
+var demoUsers = []models.User{
+	{FirstName: "Demo", LastName: "Admin", Username: "admin", Email: "admin@demo.local", Role: "admin", Level: 4},
+	{FirstName: "Demo", LastName: "User", Username: "demo", Email: "demo@demo.local", Role: "user", Level: 1},
+}
+
+// demoNotes are the notes of the demo user
+var demoNotes = []models.Note{
+	{Title: "Welcome to gnote", Content: "This server runs in demo mode, everything is kept in memory and lost when it stops."},
+	{Title: "Shopping list", Content: "milk, eggs, bread"},
+	{Title: "Ideas", Content: "Try the command line client: gnote login, gnote list, gnote add."},
+}
+
+// seedDemo creates the demo accounts and notes, and prints how to sign in
+func seedDemo(repos repository.Repos) error {
+	hash, err := utils.HashAndSalt(demoPassword)
+	if err != nil {
+		return err
+	}
+	for _, user := range demoUsers {
+		user.Password = hash
+		user.EmailVerified = true
+		user.DOB = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+		err := repos.Users.CreateUser(context.Background(), &user)
+		if err != nil {
+			return fmt.Errorf("failed to seed the demo user %s: %w", user.Username, err)
+		}
+		if user.Role == "admin" {
+			continue
+		}
+		for _, note := range demoNotes {
+			userID := user.ID
+			note.UserID = &userID
+			note.Username = user.Username
+			err := repos.Notes.Create(context.Background(), &note)
+			if err != nil {
+				return fmt.Errorf("failed to seed the demo notes: %w", err)
+			}
+		}
+	}
+	fmt.Println("Demo mode: data is kept in memory and lost on exit.")
+	for _, user := range demoUsers {
+		fmt.Printf("  %s account: %s / %s\n", user.Role, user.Username, demoPassword)
+	}
+	return nil
+}
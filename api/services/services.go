@@ -1,33 +1,89 @@
 package services
 
 import (
+	"errors"
+	"log"
+	"path/filepath"
+
 	"github.com/mrinjamul/gnote/api/controllers"
+	"github.com/mrinjamul/gnote/config"
 	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/health"
+	"github.com/mrinjamul/gnote/lockout"
+	"github.com/mrinjamul/gnote/mailer"
+	"github.com/mrinjamul/gnote/metrics"
+	"github.com/mrinjamul/gnote/middleware"
+	"github.com/mrinjamul/gnote/oidc"
 	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/tracing"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
 )
 
 type Services interface {
+	AdminService() controllers.Admin
 	HealthCheckService() controllers.HealthCheck
+	InviteService() controllers.Invites
+	KeyService() controllers.Keys
+	MetricsService() controllers.Metrics
 	NoteService() controllers.Note
+	OrgService() controllers.Org
+	SetupService() controllers.Setup
+	SSOService() controllers.SSO
 	UserService() controllers.User
 	ViewService() controllers.Views
 }
 
 type services struct {
+	admin       controllers.Admin
 	healthCheck controllers.HealthCheck
+	invites     controllers.Invites
+	keys        controllers.Keys
+	metrics     controllers.Metrics
 	note        controllers.Note
+	org         controllers.Org
+	setup       controllers.Setup
+	sso         controllers.SSO
 	user        controllers.User
 	views       controllers.Views
 }
 
+func (svc *services) AdminService() controllers.Admin {
+	return svc.admin
+}
+
 func (svc *services) HealthCheckService() controllers.HealthCheck {
 	return svc.healthCheck
 }
 
+func (svc *services) InviteService() controllers.Invites {
+	return svc.invites
+}
+
+func (svc *services) KeyService() controllers.Keys {
+	return svc.keys
+}
+
+func (svc *services) MetricsService() controllers.Metrics {
+	return svc.metrics
+}
+
 func (svc *services) NoteService() controllers.Note {
 	return svc.note
 }
 
+func (svc *services) OrgService() controllers.Org {
+	return svc.org
+}
+
+func (svc *services) SetupService() controllers.Setup {
+	return svc.setup
+}
+
+func (svc *services) SSOService() controllers.SSO {
+	return svc.sso
+}
+
 func (svc *services) UserService() controllers.User {
 	return svc.user
 }
@@ -36,17 +92,121 @@ func (svc *services) ViewService() controllers.Views {
 	return svc.views
 }
 
-// NewServices initializes services
-func NewServices() Services {
-	db := database.GetDB()
+// NewServices initializes services. When the database is unreachable the
+// startup fails, unless the degraded mode is allowed: the services are then
+// built on the handle which connects once the database is back. The
+// degraded mode is also entered when the database drops later on.
+func NewServices(cfg *config.Config) (Services, error) {
+	db, err := database.GetDB(cfg.Database)
+	var connectErr *database.ConnectError
+	if errors.As(err, &connectErr) && db != nil && cfg.Database.AllowDegraded {
+		log.Printf("%v, starting in degraded mode", err)
+	} else if err != nil {
+		return nil, err
+	}
+	// reconnect in degraded mode, and enter it when the database drops
+	go database.Monitor(db, cfg.Database)
+	return newServices(cfg, db, repository.NewRepos(db)), nil
+}
+
+// NewDemoServices initializes services whose repositories share one store
+// kept in memory and seeded with demo data, nothing outlives the process
+func NewDemoServices(cfg *config.Config) (Services, error) {
+	repos := repository.NewMemoryRepos()
+	err := seedDemo(repos)
+	if err != nil {
+		return nil, err
+	}
+	return newServices(cfg, nil, repos), nil
+}
+
+// newServices initializes services on the repositories, db is the database
+// behind them, nil when they are kept in memory
+func newServices(cfg *config.Config, db *gorm.DB, repos repository.Repos) Services {
+	userRepo := repos.Users
+	noteRepo := repos.Notes
+	tokenRepo := repos.Tokens
+	inviteRepo := repos.Invites
+	orgRepo := repos.Orgs
+	mail := mailer.NewMailer(cfg.Mail)
+	checker := newChecker(cfg, db)
+	if db != nil {
+		instrument(db)
+	}
+	// purge the accounts whose deletion grace period has ended
+	go controllers.PurgeDeletedUsers(userRepo)
+	// the tokens of the revoked sessions are refused
+	middleware.UseUsers(userRepo)
 	return &services{
-		healthCheck: controllers.NewHealthCheck(),
+		admin: controllers.NewAdmin(
+			userRepo,
+			noteRepo,
+			tokenRepo,
+			mail,
+		),
+		healthCheck: controllers.NewHealthCheck(checker),
+		invites:     controllers.NewInvites(inviteRepo),
+		keys:        controllers.NewKeys(),
+		metrics:     controllers.NewMetrics(cfg.Metrics),
 		note: controllers.NewNote(
-			repository.NewNoteRepo(db),
+			noteRepo,
+		),
+		org: controllers.NewOrg(
+			orgRepo,
+			noteRepo,
+			userRepo,
+			mail,
+		),
+		setup: controllers.NewSetup(
+			userRepo,
+		),
+		sso: controllers.NewSSO(
+			oidc.NewProviderFromConfig(cfg.OIDC),
+			userRepo,
+			repos.Identities,
+			inviteRepo,
 		),
 		user: controllers.NewUser(
-			repository.NewUserRepo(db),
+			userRepo,
+			tokenRepo,
+			inviteRepo,
+			orgRepo,
+			mail,
+			lockout.NewGuard(cfg.Lockout),
 		),
 		views: controllers.NewViews(),
 	}
 }
+
+// newChecker registers the readiness checks of the dependencies
+func newChecker(cfg *config.Config, db *gorm.DB) *health.Checker {
+	checker := health.NewChecker()
+	if db == nil {
+		return checker
+	}
+	database.RegisterChecks(checker, db)
+	if cfg.Database.Driver == database.DriverSQLite {
+		checker.Register(health.WritableDir("storage", filepath.Dir(cfg.Database.Path), true))
+	}
+	if cfg.Mail.Mailer == "file" {
+		checker.Register(health.WritableDir("mailer", cfg.Mail.Dir, false))
+	}
+	return checker
+}
+
+// instrument traces the database queries and observes their latency and the
+// statistics of the connection pool
+func instrument(db *gorm.DB) {
+	for _, plugin := range []gorm.Plugin{tracing.GORMPlugin{}, metrics.GORMPlugin{}} {
+		err := db.Use(plugin)
+		if err != nil {
+			log.Printf("failed to instrument the database queries: %v", err)
+		}
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Printf("failed to instrument the database pool: %v", err)
+		return
+	}
+	metrics.MustRegister(collectors.NewDBStatsCollector(sqlDB, "gnote"))
+}
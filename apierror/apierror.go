@@ -0,0 +1,120 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// Code identifies the kind of an error for the clients, the message is meant
+// for the users
+type Code string
+
+const (
+	CodeBadRequest   Code = "bad_request"
+	CodeUnauthorized Code = "unauthorized"
+	CodeForbidden    Code = "forbidden"
+	CodeNotFound     Code = "not_found"
+	CodeConflict     Code = "conflict"
+	CodeValidation   Code = "validation_failed"
+	CodeRateLimited  Code = "rate_limited"
+	CodeInternal     Code = "internal"
+	CodeUnavailable  Code = "unavailable"
+)
+
+// Error is the envelope of the error responses, answered as
+// {"error": {"code": ..., "message": ..., "details": ..., "request_id": ...}}
+type Error struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	// Details describes the error further, like the invalid field
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// Response is the body of the error responses
+type Response struct {
+	Error Error `json:"error"`
+}
+
+// Abort answers an error and stops the handlers of the request
+func Abort(ctx *gin.Context, status int, code Code, message string, details interface{}) {
+	ctx.AbortWithStatusJSON(status, Response{
+		Error: Error{
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestID: logger.RequestID(ctx),
+		},
+	})
+}
+
+// BadRequest answers 400 for a request which can't be read
+func BadRequest(ctx *gin.Context, message string) {
+	Abort(ctx, http.StatusBadRequest, CodeBadRequest, message, nil)
+}
+
+// Unauthorized answers 401
+func Unauthorized(ctx *gin.Context, message string) {
+	Abort(ctx, http.StatusUnauthorized, CodeUnauthorized, message, nil)
+}
+
+// Forbidden answers 403
+func Forbidden(ctx *gin.Context, message string) {
+	Abort(ctx, http.StatusForbidden, CodeForbidden, message, nil)
+}
+
+// NotFound answers 404
+func NotFound(ctx *gin.Context, message string) {
+	Abort(ctx, http.StatusNotFound, CodeNotFound, message, nil)
+}
+
+// Conflict answers 409 for a request clashing with the current state
+func Conflict(ctx *gin.Context, message string) {
+	Abort(ctx, http.StatusConflict, CodeConflict, message, nil)
+}
+
+// Internal answers 500, the cause is logged but never sent to the client
+func Internal(ctx *gin.Context, err error) {
+	if err != nil {
+		logger.Ctx(ctx).Error().Err(err).Msg("internal error")
+	}
+	Abort(ctx, http.StatusInternalServerError, CodeInternal, "Internal Server Error", nil)
+}
+
+// FromRepository answers a repository error: 404 when not found, 409 on a
+// conflict, 422 when invalid and 500 otherwise. The messages of the typed
+// errors are sent, the others are replaced.
+func FromRepository(ctx *gin.Context, err error) {
+	var typed *repository.Error
+	message := ""
+	if errors.As(err, &typed) {
+		message = typed.Message
+	}
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		if message == "" {
+			message = "not found"
+		}
+		Abort(ctx, http.StatusNotFound, CodeNotFound, message, nil)
+	case errors.Is(err, repository.ErrConflict):
+		if message == "" {
+			message = repository.ErrConflict.Error()
+		}
+		Abort(ctx, http.StatusConflict, CodeConflict, message, nil)
+	case errors.Is(err, repository.ErrValidation):
+		if message == "" {
+			message = repository.ErrValidation.Error()
+		}
+		var details interface{}
+		if typed != nil && typed.Field != "" {
+			details = gin.H{"field": typed.Field}
+		}
+		Abort(ctx, http.StatusUnprocessableEntity, CodeValidation, message, details)
+	default:
+		Internal(ctx, err)
+	}
+}
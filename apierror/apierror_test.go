@@ -0,0 +1,151 @@
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// answer runs the handler in a request with an id and returns the response
+// and its decoded error
+func answer(t *testing.T, handler gin.HandlerFunc) (*httptest.ResponseRecorder, Error) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(rec)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Request = req.WithContext(logger.WithRequestID(req.Context(), "req-1"))
+	handler(ctx)
+	if !ctx.IsAborted() {
+		t.Error("the handlers of the request are not stopped")
+	}
+
+	var body Response
+	err := json.Unmarshal(rec.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("body %s: %v", rec.Body, err)
+	}
+	if body.Error.RequestID != "req-1" {
+		t.Errorf("request_id = %q, want req-1", body.Error.RequestID)
+	}
+	return rec, body.Error
+}
+
+func TestHelpers(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		status  int
+		code    Code
+		message string
+	}{
+		{"bad request", func(c *gin.Context) { BadRequest(c, "invalid body") }, http.StatusBadRequest, CodeBadRequest, "invalid body"},
+		{"unauthorized", func(c *gin.Context) { Unauthorized(c, "invalid token") }, http.StatusUnauthorized, CodeUnauthorized, "invalid token"},
+		{"forbidden", func(c *gin.Context) { Forbidden(c, "admins only") }, http.StatusForbidden, CodeForbidden, "admins only"},
+		{"not found", func(c *gin.Context) { NotFound(c, "note not found") }, http.StatusNotFound, CodeNotFound, "note not found"},
+		{"conflict", func(c *gin.Context) { Conflict(c, "already exists") }, http.StatusConflict, CodeConflict, "already exists"},
+		{"internal", func(c *gin.Context) { Internal(c, errors.New("pq: password authentication failed")) },
+			http.StatusInternalServerError, CodeInternal, "Internal Server Error"},
+		{"internal without cause", func(c *gin.Context) { Internal(c, nil) },
+			http.StatusInternalServerError, CodeInternal, "Internal Server Error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, got := answer(t, tt.handler)
+			if rec.Code != tt.status || got.Code != tt.code || got.Message != tt.message || got.Details != nil {
+				t.Errorf("answer = %d %+v, want %d %s %q without details", rec.Code, got, tt.status, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestAbortDetails(t *testing.T) {
+	rec, got := answer(t, func(c *gin.Context) {
+		Abort(c, http.StatusTooManyRequests, CodeRateLimited, "slow down", gin.H{"retry_after": 30})
+	})
+	details, _ := got.Details.(map[string]interface{})
+	if rec.Code != http.StatusTooManyRequests || got.Code != CodeRateLimited || details["retry_after"] != float64(30) {
+		t.Errorf("answer = %d %+v, want %d %s with retry_after", rec.Code, got, http.StatusTooManyRequests, CodeRateLimited)
+	}
+
+	// details are left out of the envelope when there are none
+	rec, _ = answer(t, func(c *gin.Context) { NotFound(c, "note not found") })
+	if strings.Contains(rec.Body.String(), "details") {
+		t.Errorf("body = %s, want no details", rec.Body)
+	}
+}
+
+func TestFromRepository(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		code    Code
+		message string
+		field   string
+	}{
+		{"gorm not found", repository.ErrNotFound, http.StatusNotFound, CodeNotFound, "not found", ""},
+		{"typed not found", &repository.Error{Kind: repository.ErrNotFound, Message: "user not found"},
+			http.StatusNotFound, CodeNotFound, "user not found", ""},
+		{"conflict", repository.ErrConflict, http.StatusConflict, CodeConflict, "conflict", ""},
+		{"wrapped typed conflict", fmt.Errorf("create: %w", &repository.Error{Kind: repository.ErrConflict, Message: "username already taken"}),
+			http.StatusConflict, CodeConflict, "username already taken", ""},
+		{"validation of a field", &repository.Error{Kind: repository.ErrValidation, Message: "a note needs a title or a content", Field: "content"},
+			http.StatusUnprocessableEntity, CodeValidation, "a note needs a title or a content", "content"},
+		{"validation", repository.ErrValidation, http.StatusUnprocessableEntity, CodeValidation, "validation failed", ""},
+		{"other error", errors.New("database is locked"), http.StatusInternalServerError, CodeInternal, "Internal Server Error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, got := answer(t, func(c *gin.Context) { FromRepository(c, tt.err) })
+			if rec.Code != tt.status || got.Code != tt.code || got.Message != tt.message {
+				t.Errorf("answer = %d %s %q, want %d %s %q", rec.Code, got.Code, got.Message, tt.status, tt.code, tt.message)
+			}
+			details, _ := got.Details.(map[string]interface{})
+			if field, _ := details["field"].(string); field != tt.field || (tt.field == "" && got.Details != nil) {
+				t.Errorf("details = %v, want field %q", got.Details, tt.field)
+			}
+		})
+	}
+}
+
+func TestFromRepositorySQLiteConflict(t *testing.T) {
+	db, err := database.GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := repository.NewRepos(db)
+	ctx := context.Background()
+	bob := models.User{Username: "bob", Email: "bob@example.com", Role: "user", Level: 1}
+	err = repos.Users.CreateUser(ctx, &bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	org := models.Organization{Name: "bobs"}
+	err = repos.Orgs.CreateOrg(ctx, &org, bob.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the owner is already a member, the unique index of the memberships
+	// refuses the second one
+	err = repos.Orgs.SaveMembership(ctx, &models.Membership{OrgID: org.ID, UserID: bob.ID, Role: models.OrgRoleViewer})
+	if !errors.Is(err, repository.ErrConflict) {
+		t.Fatalf("SaveMembership() = %v, want a conflict", err)
+	}
+	rec, got := answer(t, func(c *gin.Context) { FromRepository(c, err) })
+	if rec.Code != http.StatusConflict || got.Code != CodeConflict || got.Message != "already a member" {
+		t.Errorf("answer = %d %s %q, want %d %s %q", rec.Code, got.Code, got.Message, http.StatusConflict, CodeConflict, "already a member")
+	}
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/config"
+)
+
+// Key is a JWT signing or verification key
+type Key struct {
+	// ID is the key id, put in the "kid" header of the tokens
+	ID string
+	// Method is the signing method of the key
+	Method jwt.SigningMethod
+	// private is the key used for signing, nil for verification only keys
+	private interface{}
+	// public is the key used for verification
+	public interface{}
+}
+
+// KeySet holds the signing key and all the active verification keys
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+	secret  []byte
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	mu          sync.RWMutex
+	defaultKeys *KeySet
+)
+
+// Init loads the default key set of the configuration
+func Init(cfg config.Auth) error {
+	ks, err := LoadKeySet(cfg)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defaultKeys = ks
+	mu.Unlock()
+	return nil
+}
+
+// current returns the default key set
+func current() (*KeySet, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if defaultKeys == nil {
+		return nil, errors.New("signing keys are not loaded")
+	}
+	return defaultKeys, nil
+}
+
+// SignToken signs the claims with the default key set
+func SignToken(claims jwt.Claims) (string, error) {
+	ks, err := current()
+	if err != nil {
+		return "", err
+	}
+	return ks.Sign(claims)
+}
+
+// ParseToken parses and verifies a token with the default key set
+func ParseToken(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	ks, err := current()
+	if err != nil {
+		return nil, err
+	}
+	return ks.Parse(tokenString, claims)
+}
+
+// PublicKeys returns the public keys of the default key set
+func PublicKeys() JWKS {
+	ks, err := current()
+	if err != nil {
+		return JWKS{Keys: []JWK{}}
+	}
+	return ks.JWKS()
+}
+
+// Secret returns the server secret used to sign opaque tokens
+func Secret() string {
+	ks, err := current()
+	if err != nil {
+		return ""
+	}
+	return string(ks.secret)
+}
+
+// Sign signs the claims with the signing key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	token.Header["kid"] = ks.signing.ID
+	return token.SignedString(ks.signing.private)
+}
+
+// Parse parses a token and verifies it with the matching verification key
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	var methods []string
+	for _, key := range ks.keys {
+		methods = append(methods, key.Method.Alg())
+	}
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyFunc, jwt.WithValidMethods(methods))
+}
+
+// keyFunc looks up the verification key of a token
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if kid == "" {
+		// Tokens issued before key ids were introduced
+		key, ok = ks.legacyKey(token.Method.Alg())
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if key.Method.Alg() != token.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// legacyKey returns the verification key for tokens without key id
+func (ks *KeySet) legacyKey(alg string) (*Key, bool) {
+	if ks.signing.Method.Alg() == alg {
+		return ks.signing, true
+	}
+	for _, key := range ks.keys {
+		if key.Method.Alg() == alg {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// JWKS returns the asymmetric verification keys as a JSON Web Key Set
+func (ks *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk, ok := toJWK(key.public)
+		if !ok {
+			continue
+		}
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Method.Alg()
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
+
+// NewKeySet initializes a key set, the signing key is a verification key as well
+func NewKeySet(signing *Key, verification []*Key, secret []byte) *KeySet {
+	ks := &KeySet{
+		signing: signing,
+		keys:    map[string]*Key{signing.ID: signing},
+		secret:  secret,
+	}
+	for _, key := range verification {
+		ks.keys[key.ID] = key
+	}
+	return ks
+}
+
+// NewHMACKey initializes a HS256 key from a secret
+func NewHMACKey(secret []byte) *Key {
+	sum := sha256.Sum256(secret)
+	return &Key{
+		ID:      "hs256-" + hex.EncodeToString(sum[:4]),
+		Method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// NewPrivateKey initializes a RS256 or EdDSA signing key
+func NewPrivateKey(private crypto.PrivateKey) (*Key, error) {
+	var public crypto.PublicKey
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	default:
+		return nil, errors.New("unsupported private key type")
+	}
+	key, err := NewPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	key.private = private
+	return key, nil
+}
+
+// NewPublicKey initializes a RS256 or EdDSA verification key
+func NewPublicKey(public crypto.PublicKey) (*Key, error) {
+	var method jwt.SigningMethod
+	switch public.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+	return &Key{
+		ID:     thumbprint(public),
+		Method: method,
+		public: public,
+	}, nil
+}
+
+// LoadKeySet loads the key set of the configuration.
+//
+// JWT_PRIVATE_KEY_FILE selects a RS256 or EdDSA signing key, otherwise tokens
+// are signed with HS256 using JWT_SECRET. JWT_PUBLIC_KEY_FILES lists extra
+// verification keys, e.g. retired keys during a rotation. While JWT_SECRET is
+// set next to a private key, HS256 tokens are still accepted.
+func LoadKeySet(cfg config.Auth) (*KeySet, error) {
+	secret := []byte(cfg.JWTSecret)
+	privateFile := cfg.JWTPrivateKeyFile
+
+	var signing *Key
+	var verification []*Key
+	if privateFile != "" {
+		data, err := ioutil.ReadFile(privateFile)
+		if err != nil {
+			return nil, err
+		}
+		private, err := parsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", privateFile, err)
+		}
+		signing, err = NewPrivateKey(private)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", privateFile, err)
+		}
+		if len(secret) > 0 {
+			verification = append(verification, NewHMACKey(secret))
+		}
+	} else {
+		if len(secret) == 0 {
+			return nil, errors.New("JWT_SECRET or JWT_PRIVATE_KEY_FILE must be set")
+		}
+		signing = NewHMACKey(secret)
+	}
+
+	for _, file := range cfg.JWTPublicKeyFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		key, err := NewPublicKey(public)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		verification = append(verification, key)
+	}
+
+	// Opaque tokens (password reset, email verification) are signed with
+	// the secret, a random one is used when it's not configured
+	if len(secret) == 0 {
+		log.Println("Environment variable JWT_SECRET is null, emailed links won't survive a restart.")
+		secret = make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return NewKeySet(signing, verification, secret), nil
+}
+
+// parsePrivateKey parses a PEM encoded RSA or Ed25519 private key
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("not a PEM encoded RSA or Ed25519 private key")
+}
+
+// parsePublicKey parses a PEM encoded RSA or Ed25519 public key
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("not a PEM encoded RSA or Ed25519 public key")
+}
+
+// toJWK converts an asymmetric public key to a JWK
+func toJWK(public interface{}) (JWK, bool) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, true
+	}
+	return JWK{}, false
+}
+
+// thumbprint returns the RFC 7638 thumbprint of a public key
+func thumbprint(public crypto.PublicKey) string {
+	jwk, _ := toJWK(public)
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, jwk.Crv, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package auth
+
+import "github.com/mrinjamul/gnote/models"
+
+// Action is an operation on a note
+type Action string
+
+const (
+	// ActionRead reads a note
+	ActionRead Action = "read"
+	// ActionUpdate updates a note
+	ActionUpdate Action = "update"
+	// ActionDelete deletes a note
+	ActionDelete Action = "delete"
+)
+
+// orgRoleRank orders the organization roles, a role includes the lower ones
+var orgRoleRank = map[string]int{
+	models.OrgRoleViewer: 1,
+	models.OrgRoleEditor: 2,
+	models.OrgRoleOwner:  3,
+}
+
+// noteActionRole is the organization role required by each action
+var noteActionRole = map[Action]string{
+	ActionRead:   models.OrgRoleViewer,
+	ActionUpdate: models.OrgRoleEditor,
+	ActionDelete: models.OrgRoleEditor,
+}
+
+// ValidOrgRole checks if the role is an organization role
+func ValidOrgRole(role string) bool {
+	_, ok := orgRoleRank[role]
+	return ok
+}
+
+// HasOrgRole checks if the role includes the required one
+func HasOrgRole(role, required string) bool {
+	return ValidOrgRole(role) && orgRoleRank[role] >= orgRoleRank[required]
+}
+
+// CanAccessNote is the authorization policy of every note operation.
+// Personal notes are only seen by their owner. Notes of an organization are
+// seen by its members, with the role the action requires; membership is the
+// one of the principal in that organization, nil when checking a personal
+// note. Callers answer 404 when it fails, so notes can't be discovered.
+func CanAccessNote(principal Principal, note models.Note, membership *models.Membership, action Action) bool {
+	if principal.UserID == 0 {
+		return false
+	}
+	required, ok := noteActionRole[action]
+	if !ok {
+		return false
+	}
+	if note.OrgID == nil {
+		return membership == nil && note.UserID != nil && *note.UserID == principal.UserID
+	}
+	return membership != nil &&
+		membership.UserID == principal.UserID &&
+		membership.OrgID == *note.OrgID &&
+		HasOrgRole(membership.Role, required)
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/models"
+)
+
+// principalKey is the key of the principal in the request context
+const principalKey = "principal"
+
+// Principal is the verified caller of a request
+type Principal struct {
+	UserID   uint
+	Username string
+	Role     string
+	Level    int
+	// IssuedAt and ExpiresAt are the times of the token, to check the
+	// revoked sessions and to refresh it
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NewPrincipal returns the principal of verified claims
+func NewPrincipal(claims *models.Claims) Principal {
+	principal := Principal{
+		UserID:   claims.UserID,
+		Username: claims.Username,
+		Role:     claims.Role,
+		Level:    claims.Level,
+	}
+	if claims.IssuedAt != nil {
+		principal.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		principal.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return principal
+}
+
+// Revoked checks if the token of the principal was issued before the
+// sessions of the user were revoked, e.g. by a password change. Tokens
+// without issue time are revoked, the times have a precision of a second.
+func (p Principal) Revoked(user models.User) bool {
+	if p.IssuedAt.IsZero() {
+		return true
+	}
+	return user.SessionsRevokedAt.Valid && p.IssuedAt.Before(user.SessionsRevokedAt.Time.Truncate(time.Second))
+}
+
+// SetPrincipal puts the principal into the request context, it is meant to
+// be called by the auth middleware only
+func SetPrincipal(ctx *gin.Context, principal Principal) {
+	ctx.Set(principalKey, principal)
+}
+
+// PrincipalFrom returns the principal of the request, false when the request
+// didn't pass the auth middleware
+func PrincipalFrom(ctx *gin.Context) (Principal, bool) {
+	value, ok := ctx.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	if !ok || principal.UserID == 0 {
+		return Principal{}, false
+	}
+	return principal, true
+}
@@ -44,7 +44,7 @@ var addCmd = &cobra.Command{
 			panic(err)
 		}
 
-		note, err := utils.CreateNote(flagTitle, flagContent, config.Token)
+		note, err := utils.CreateNote(workspace(config), flagTitle, flagContent, config.Token)
 		if err != nil {
 			fmt.Println(err)
 			return
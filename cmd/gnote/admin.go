@@ -0,0 +1,339 @@
+/*
+Copyright © 2022 Injamul Mohammad Mollah
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/mrinjamul/gnote/api/controllers"
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/repository"
+	"github.com/mrinjamul/gnote/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagRole     string
+	flagLevel    int
+	flagMaxUses  int
+	flagExpires  string
+	flagUsername string
+	flagEmail    string
+	// invites have their own defaults
+	flagInviteRole  string
+	flagInviteLevel int
+)
+
+// adminCmd represents the admin command
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "manage gnote (admins only).",
+}
+
+// adminUsersCmd represents the admin users command
+var adminUsersCmd = &cobra.Command{
+	Use:   "users",
+	Short: "manage users.",
+}
+
+// adminUsersListCmd lists and searches users
+var adminUsersListCmd = &cobra.Command{
+	Use:     "list [query]",
+	Aliases: []string{"search"},
+	Short:   "list or search users.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		users, total, err := utils.AdminListUsers(strings.Join(args, " "), config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("%d users found: \n", total)
+		for _, user := range users {
+			utils.PrintUser(user)
+		}
+	},
+}
+
+// adminUsersShowCmd shows a user with its storage usage
+var adminUsersShowCmd = &cobra.Command{
+	Use:   "show [username]",
+	Short: "show a user and its storage usage.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		user, usage, err := utils.AdminGetUser(args[0], config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		utils.PrintUser(user)
+		fmt.Printf("Storage: %d notes, %d bytes\n", usage.Notes, usage.Bytes)
+	},
+}
+
+// adminUsersSetCmd changes the role and the level of a user
+var adminUsersSetCmd = &cobra.Command{
+	Use:   "set [username]",
+	Short: "change the role or the level of a user.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if flagRole == "" && flagLevel == 0 {
+			fmt.Println("role or level is required")
+			return
+		}
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		user, err := utils.AdminUpdateUser(args[0], flagRole, flagLevel, config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Updated user: ")
+		utils.PrintUser(user)
+	},
+}
+
+// adminInvitesCmd represents the admin invites command
+var adminInvitesCmd = &cobra.Command{
+	Use:   "invites",
+	Short: "manage invite codes.",
+}
+
+// adminInvitesCreateCmd creates an invite code
+var adminInvitesCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "create an invite code.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		code, invite, err := utils.AdminCreateInvite(flagMaxUses, flagExpires, flagInviteRole, flagInviteLevel, config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Invite code: " + code)
+		fmt.Println("It is shown only once, share it with the people to invite.")
+		utils.PrintInvite(invite)
+	},
+}
+
+// adminInvitesListCmd lists the invite codes
+var adminInvitesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list invite codes.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		invites, err := utils.AdminListInvites(config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("%d invites found: \n", len(invites))
+		for _, invite := range invites {
+			utils.PrintInvite(invite)
+		}
+	},
+}
+
+// adminInvitesRevokeCmd revokes an invite code
+var adminInvitesRevokeCmd = &cobra.Command{
+	Use:   "revoke [id]",
+	Short: "revoke an invite code.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		err = utils.AdminRevokeInvite(args[0], config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Invite revoked")
+	},
+}
+
+// adminBootstrapCmd creates the first admin directly in the database
+var adminBootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "create the first admin, run it on the server.",
+	Long: `create the first admin directly in the database of the server
+configuration, read like by serve. It fails once any admin exists.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := databaseConfig()
+		if err == nil {
+			err = cfg.Password.Validate()
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		utils.ConfigurePasswords(cfg.Password)
+		db, err := database.GetDB(cfg.Database)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		ctx := context.Background()
+		userRepo := repository.NewUserRepo(db)
+		exists, err := userRepo.AdminExists(ctx)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if exists {
+			fmt.Println(controllers.ErrAdminExists)
+			return
+		}
+
+		username := flagUsername
+		if username == "" {
+			prompt := promptui.Prompt{
+				Label: "Username",
+				Validate: func(input string) error {
+					ok := utils.IsValidUserName(input)
+					if !ok {
+						return errors.New("invalid username")
+					}
+					return nil
+				},
+			}
+			username, err = prompt.Run()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		prompt := promptui.Prompt{
+			Label: "Password",
+			Mask:  '*',
+			Validate: func(input string) error {
+				ok := utils.IsValidPassword(input)
+				if !ok {
+					return errors.New("invalid password")
+				}
+				return nil
+			},
+		}
+		password, err := prompt.Run()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		prompt = promptui.Prompt{
+			Label: "Verify password",
+			Mask:  '*',
+		}
+		verify, err := prompt.Run()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if password != verify {
+			fmt.Println("The passwords don't match")
+			return
+		}
+
+		user, err := controllers.BootstrapAdmin(ctx, userRepo, username, flagEmail, password)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Admin %s created, you can now login\n", user.Username)
+	},
+}
+
+// newAdminUserActionCmd creates a command running an action on a user
+func newAdminUserActionCmd(use, action, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " [username]",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// Read token from config
+			config, err := utils.GetConfig()
+			if err != nil {
+				panic(err)
+			}
+
+			message, err := utils.AdminUserAction(args[0], action, config.Token)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Printf("%s: %s\n", args[0], message)
+		},
+	}
+}
+
+func init() {
+	adminUsersSetCmd.Flags().StringVarP(&flagRole, "role", "r", "", "role of the user (user or admin)")
+	adminUsersSetCmd.Flags().IntVarP(&flagLevel, "level", "l", 0, "level of the user")
+
+	adminUsersCmd.AddCommand(adminUsersListCmd)
+	adminUsersCmd.AddCommand(adminUsersShowCmd)
+	adminUsersCmd.AddCommand(adminUsersSetCmd)
+	adminUsersCmd.AddCommand(newAdminUserActionCmd("suspend", "suspend", "suspend a user."))
+	adminUsersCmd.AddCommand(newAdminUserActionCmd("unsuspend", "unsuspend", "lift the suspension of a user."))
+	adminUsersCmd.AddCommand(newAdminUserActionCmd("reset-password", "reset", "force a user to reset the password."))
+	adminUsersCmd.AddCommand(newAdminUserActionCmd("unlock", "unlock", "lift the login lockout of a user."))
+	adminCmd.AddCommand(adminUsersCmd)
+
+	adminInvitesCreateCmd.Flags().IntVarP(&flagMaxUses, "max-uses", "m", 1, "number of sign ups allowed, 0 for unlimited")
+	adminInvitesCreateCmd.Flags().StringVarP(&flagExpires, "expires", "e", "", "validity of the code, like 72h")
+	adminInvitesCreateCmd.Flags().StringVarP(&flagInviteRole, "role", "r", "user", "role given to invited users, only user (admins are promoted with `admin users set`)")
+	adminInvitesCreateCmd.Flags().IntVarP(&flagInviteLevel, "level", "l", 1, "level given to invited users")
+
+	adminInvitesCmd.AddCommand(adminInvitesCreateCmd)
+	adminInvitesCmd.AddCommand(adminInvitesListCmd)
+	adminInvitesCmd.AddCommand(adminInvitesRevokeCmd)
+	adminCmd.AddCommand(adminInvitesCmd)
+
+	adminBootstrapCmd.Flags().StringVarP(&flagUsername, "username", "u", "", "username of the admin")
+	adminBootstrapCmd.Flags().StringVarP(&flagEmail, "email", "e", "", "email of the admin")
+	adminCmd.AddCommand(adminBootstrapCmd)
+}
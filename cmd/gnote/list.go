@@ -43,7 +43,7 @@ var listCmd = &cobra.Command{
 		}
 
 		if ID != "" {
-			note, err := utils.GetNote(ID, config.Token)
+			note, err := utils.GetNote(workspace(config), ID, config.Token)
 			if err != nil {
 				fmt.Println(err)
 				return
@@ -53,7 +53,7 @@ var listCmd = &cobra.Command{
 		}
 
 		// get all notes and print them
-		notes, err := utils.GetNotes(config.Token)
+		notes, err := utils.GetNotes(workspace(config), config.Token)
 		if err != nil {
 			fmt.Println(err)
 			return
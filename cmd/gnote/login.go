@@ -4,17 +4,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/manifoldco/promptui"
 	"github.com/mrinjamul/gnote/utils"
 	"github.com/spf13/cobra"
 )
 
+var (
+	flagSSO bool
+)
+
 // login represents the version command
 var loginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "login to gnote.",
 	Run: func(cmd *cobra.Command, args []string) {
+		if flagSSO {
+			loginSSO()
+			return
+		}
 		// prompt for username and password
 		prompt := promptui.Prompt{
 			Label: "Username",
@@ -73,3 +82,48 @@ var loginCmd = &cobra.Command{
 		}
 	},
 }
+
+// loginSSO logs in with single sign-on using the device flow
+func loginSSO() {
+	device, err := utils.CLISSOStart()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if device.VerificationURIComplete != "" {
+		fmt.Println("Open", device.VerificationURIComplete, "to login.")
+	} else {
+		fmt.Println("Open", device.VerificationURI, "and enter the code", device.UserCode)
+	}
+
+	interval := time.Duration(device.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(device.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		token, err := utils.CLISSOToken(device.DeviceCode, flagInvite)
+		if err == utils.ErrSlowDown {
+			interval += 5 * time.Second
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if token == "" {
+			continue
+		}
+		err = utils.SaveToken(token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Login successful")
+		return
+	}
+	fmt.Println("Login failed: code expired")
+}
+
+func init() {
+	loginCmd.Flags().BoolVar(&flagSSO, "sso", false, "login with single sign-on")
+	loginCmd.Flags().StringVarP(&flagInvite, "invite", "i", "", "invite code, when single sign-on creates the account")
+}
@@ -31,6 +31,10 @@ func main() {
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(signupCmd)
 	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(passwdCmd)
+	rootCmd.AddCommand(adminCmd)
+	rootCmd.AddCommand(orgCmd)
+	rootCmd.AddCommand(migrateCmd)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -0,0 +1,166 @@
+/*
+Copyright © 2022 Injamul Mohammad Mollah
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mrinjamul/gnote/database"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var (
+	// flagMigrationsDir is the directory of the migration files
+	flagMigrationsDir string
+)
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "manage the database schema, run it on the server.",
+	Long: `manage the schema of the database of the server configuration, read
+like by serve from --config and the environment variables. The migrations are embedded in
+the binary and applied in order.`,
+}
+
+// migrateUpCmd applies the pending migrations
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "apply the pending migrations.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := connectDB()
+		if db == nil {
+			return
+		}
+		applied, err := database.MigrateUp(db)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(applied) == 0 {
+			fmt.Println("The database is up to date")
+		}
+	},
+}
+
+// migrateDownCmd reverts the latest migrations
+var migrateDownCmd = &cobra.Command{
+	Use:   "down [steps]",
+	Short: "revert the latest migrations, one by default.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		steps := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				fmt.Println("steps should be a positive number")
+				return
+			}
+			steps = n
+		}
+		db := connectDB()
+		if db == nil {
+			return
+		}
+		reverted, err := database.MigrateDown(db, steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %04d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if len(reverted) == 0 {
+			fmt.Println("No migration to revert")
+		}
+	},
+}
+
+// migrateStatusCmd lists the migrations
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "list the migrations and when they were applied.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		db := connectDB()
+		if db == nil {
+			return
+		}
+		states, err := database.MigrationStatus(db)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, state := range states {
+			status := "pending"
+			if state.Applied {
+				status = "applied " + state.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			if state.Up == "" {
+				status += " (unknown to this build)"
+			}
+			fmt.Printf("%04d %-30s %s\n", state.Version, state.Name, status)
+		}
+	},
+}
+
+// migrateCreateCmd writes the files of a new migration
+var migrateCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "create empty up and down files of a new migration.",
+	Long: `create empty up and down files of a new migration for each database
+driver. They are embedded at the next build.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		paths, err := database.CreateMigration(flagMigrationsDir, args[0])
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+		if err != nil {
+			fmt.Println(err)
+		}
+	},
+}
+
+// connectDB connects to the database without migrating it
+func connectDB() *gorm.DB {
+	cfg, err := databaseConfig()
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	db, err := database.Connect(cfg.Database)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return db
+}
+
+func init() {
+	migrateCreateCmd.Flags().StringVarP(&flagMigrationsDir, "dir", "d", "database/migrations", "directory of the migration files")
+
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateCreateCmd)
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2022 Injamul Mohammad Mollah
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagOrg         string
+	flagDisplayName string
+	flagOrgRole     string
+	flagOrgEmail    string
+)
+
+// workspace returns the organization of the notes commands, set by --org
+// or by `gnote org use`. It is empty for personal notes.
+func workspace(config *models.Config) string {
+	if flagOrg != "" {
+		return flagOrg
+	}
+	return config.Org
+}
+
+// orgCmd represents the org command
+var orgCmd = &cobra.Command{
+	Use:     "org",
+	Aliases: []string{"orgs"},
+	Short:   "manage organizations and the active workspace.",
+}
+
+// orgCreateCmd creates an organization
+var orgCreateCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "create an organization.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		org, err := utils.CreateOrg(args[0], flagDisplayName, config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("Organization %s created, switch to it with `gnote org use %s`\n", org.Name, org.Name)
+	},
+}
+
+// orgListCmd lists the organizations of the user
+var orgListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list your organizations.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		orgs, err := utils.GetOrgs(config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		active := workspace(config)
+		for _, org := range orgs {
+			marker := " "
+			if org.Name == active {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\t%s\t%s\n", marker, org.Name, org.Role, org.DisplayName)
+		}
+	},
+}
+
+// orgUseCmd sets the active workspace
+var orgUseCmd = &cobra.Command{
+	Use:   "use [name]",
+	Short: "set the active workspace, personal notes without a name.",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		org := ""
+		if len(args) == 1 {
+			org = args[0]
+		}
+		err := utils.SaveOrg(org)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if org == "" {
+			fmt.Println("Using your personal notes")
+		} else {
+			fmt.Println("Using the notes of " + org)
+		}
+	},
+}
+
+// orgMembersCmd lists the members of the active organization
+var orgMembersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "list the members of the organization.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+		org, ok := requireOrg(config)
+		if !ok {
+			return
+		}
+
+		members, err := utils.GetOrgMembers(org, config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, member := range members {
+			fmt.Printf("@%s\t%s\tjoined on %s\n", member.Username, member.Role, member.JoinedAt.Format("2006-01-02"))
+		}
+	},
+}
+
+// orgInviteCmd invites to the active organization
+var orgInviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "invite someone to the organization.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+		org, ok := requireOrg(config)
+		if !ok {
+			return
+		}
+
+		code, err := utils.InviteToOrg(org, flagOrgRole, flagOrgEmail, config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Invite code: " + code)
+		fmt.Printf("It can be used once within a week with `gnote org join %s %s`\n", org, code)
+	},
+}
+
+// orgJoinCmd accepts an invite
+var orgJoinCmd = &cobra.Command{
+	Use:   "join [name] [code]",
+	Short: "join an organization with an invite code.",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		org, err := utils.JoinOrg(args[0], args[1], config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Printf("You joined %s as %s\n", org.Name, org.Role)
+	},
+}
+
+// orgLeaveCmd leaves the active organization
+var orgLeaveCmd = &cobra.Command{
+	Use:   "leave",
+	Short: "leave the organization.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+		org, ok := requireOrg(config)
+		if !ok {
+			return
+		}
+
+		err = utils.RemoveOrgMember(org, "me", config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if config.Org == org {
+			utils.SaveOrg("")
+		}
+		fmt.Println("You left " + org)
+	},
+}
+
+// requireOrg returns the organization of the command, it fails in the
+// personal workspace
+func requireOrg(config *models.Config) (string, bool) {
+	org := workspace(config)
+	if org == "" {
+		fmt.Println("no organization selected, use --org or `gnote org use`")
+		return "", false
+	}
+	return org, true
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&flagOrg, "org", "", "organization of the notes (default is the active workspace)")
+
+	orgCreateCmd.Flags().StringVarP(&flagDisplayName, "display-name", "d", "", "display name of the organization")
+	orgInviteCmd.Flags().StringVarP(&flagOrgRole, "role", "r", models.OrgRoleEditor, "role of the invitee (owner, editor or viewer)")
+	orgInviteCmd.Flags().StringVarP(&flagOrgEmail, "email", "e", "", "email the invite code to the invitee")
+
+	orgCmd.AddCommand(orgCreateCmd)
+	orgCmd.AddCommand(orgListCmd)
+	orgCmd.AddCommand(orgUseCmd)
+	orgCmd.AddCommand(orgMembersCmd)
+	orgCmd.AddCommand(orgInviteCmd)
+	orgCmd.AddCommand(orgJoinCmd)
+	orgCmd.AddCommand(orgLeaveCmd)
+}
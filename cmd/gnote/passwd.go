@@ -0,0 +1,91 @@
+/*
+Copyright © 2022 Injamul Mohammad Mollah
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/manifoldco/promptui"
+	"github.com/mrinjamul/gnote/utils"
+	"github.com/spf13/cobra"
+)
+
+// passwdCmd represents the passwd command
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "change your password.",
+	Long:  "change your password, your other sessions are signed out.",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Read token from config
+		config, err := utils.GetConfig()
+		if err != nil {
+			panic(err)
+		}
+
+		prompt := promptui.Prompt{
+			Label: "Current password",
+			Mask:  '*',
+		}
+		current, err := prompt.Run()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		prompt = promptui.Prompt{
+			Label: "New password",
+			Mask:  '*',
+			Validate: func(input string) error {
+				ok := utils.IsValidPassword(input)
+				if !ok {
+					return errors.New("invalid password")
+				}
+				return nil
+			},
+		}
+		password, err := prompt.Run()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		prompt = promptui.Prompt{
+			Label: "Repeat new password",
+			Mask:  '*',
+		}
+		repeated, err := prompt.Run()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if password != repeated {
+			fmt.Println("The passwords don't match")
+			return
+		}
+
+		token, err := utils.ChangePassword(current, password, config.Token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// keep this session signed in
+		err = utils.SaveToken(token)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Password changed, your other sessions are signed out.")
+	},
+}
@@ -42,7 +42,7 @@ var removeCmd = &cobra.Command{
 			panic(err)
 		}
 
-		note, err := utils.DeleteNote(id, config.Token)
+		note, err := utils.DeleteNote(workspace(config), id, config.Token)
 		if err != nil {
 			fmt.Println(err)
 			return
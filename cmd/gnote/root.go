@@ -16,9 +16,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/tracing"
 	"github.com/mrinjamul/gnote/utils"
 	"github.com/spf13/cobra"
 
@@ -42,6 +45,35 @@ var rootCmd = &cobra.Command{
 		tip += utils.GenTips()
 		fmt.Println(tip)
 	},
+	PersistentPreRun:  startTracing,
+	PersistentPostRun: stopTracing,
+}
+
+// stopTracer flushes the spans of the client commands
+var stopTracer func(context.Context) error
+
+// startTracing traces the requests of the client commands to the API with
+// the tracing settings of the environment, the server sets up its own
+func startTracing(cmd *cobra.Command, args []string) {
+	if cmd == serverCmd {
+		return
+	}
+	cfg, err := config.Read("", nil)
+	if err != nil {
+		return
+	}
+	cfg.Tracing.ServiceName += "-cli"
+	stopTracer, err = tracing.Init(cfg.Tracing)
+	if err != nil {
+		fmt.Println("tracing is disabled:", err)
+	}
+}
+
+// stopTracing flushes the spans before the client exits
+func stopTracing(cmd *cobra.Command, args []string) {
+	if stopTracer != nil {
+		stopTracer(context.Background())
+	}
 }
 
 func init() {
@@ -42,7 +42,7 @@ var searchCmd = &cobra.Command{
 			panic(err)
 		}
 		// get all notes and print them
-		notes, err := utils.GetNotes(config.Token)
+		notes, err := utils.GetNotes(workspace(config), config.Token)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -17,12 +17,20 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"log"
 	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/api/controllers"
 	"github.com/mrinjamul/gnote/api/routes"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/mrinjamul/gnote/middleware"
+	"github.com/mrinjamul/gnote/tracing"
+	"github.com/mrinjamul/gnote/utils"
 	"github.com/spf13/cobra"
 )
 
@@ -32,29 +40,101 @@ var viewsFs embed.FS
 var (
 	// startTime is the time when the server starts
 	startTime time.Time = time.Now()
+	// flagPrintConfig prints the configuration instead of starting
+	flagPrintConfig bool
 )
 
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
 	Use:   "serve",
 	Short: "starts the server",
+	Long: `starts the server. The configuration is read from the file given by
+--config, the environment variables and the flags, the later ones taking
+precedence.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// server
-		// Get port from env
-		port := ":8080"
-		_, present := os.LookupEnv("PORT")
-		if present {
-			port = ":" + os.Getenv("PORT")
-
+		cfg, err := config.Read(cfgFile, cmd.Flags())
+		if err != nil {
+			log.Fatal(err)
+		}
+		if flagPrintConfig {
+			out, err := cfg.YAML()
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Print(string(out))
+			if err := cfg.Validate(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+		err = cfg.Validate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = configure(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// The routes add the structured logger and the recovery of panics
+		server := gin.New()
+		// The client IP is only taken from X-Forwarded-For behind the
+		// configured proxies, it would be forged otherwise
+		err = server.SetTrustedProxies(cfg.Server.TrustedProxies)
+		if err != nil {
+			log.Fatal(err)
 		}
-		// Set the router as the default one shipped with Gin
-		server := gin.Default()
 		// Initialize the routes
 		routes.StartTime = startTime
 		routes.ViewsFs = viewsFs
-		routes.InitRoutes(server)
+		routes.Config = cfg
+		err = routes.InitRoutes(server)
+		if err != nil {
+			log.Fatal(err)
+		}
 		routes.BootTime = time.Since(startTime)
 		// Start and run the server
-		log.Fatal(server.Run(port))
+		log.Fatal(server.Run(fmt.Sprintf(":%d", cfg.Server.Port)))
 	},
 }
+
+// configure applies the configuration to the packages of the server
+func configure(cfg *config.Config) error {
+	gin.SetMode(cfg.Server.Mode)
+	err := logger.Init(cfg.Log)
+	if err != nil {
+		return err
+	}
+	utils.ConfigurePasswords(cfg.Password)
+	utils.ConfigureCookies(cfg.Security)
+	middleware.Configure(cfg)
+	controllers.Configure(cfg)
+	// The server runs until it is stopped, the batched spans are exported
+	// every few seconds meanwhile
+	_, err = tracing.Init(cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	// Load the token signing keys
+	return auth.Init(cfg.Auth)
+}
+
+// databaseConfig reads the server configuration for the commands which only
+// use the database, only its database settings are validated
+func databaseConfig() (*config.Config, error) {
+	cfg, err := config.Read(cfgFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Database.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func init() {
+	config.AddFlags(serverCmd.Flags())
+	serverCmd.Flags().BoolVar(&flagPrintConfig, "print-config", false, "print the configuration with its secrets redacted and exit")
+}
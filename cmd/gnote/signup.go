@@ -10,6 +10,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	flagInvite string
+)
+
 // signup represents the version command
 var signupCmd = &cobra.Command{
 	Use:   "signup",
@@ -48,21 +52,25 @@ var signupCmd = &cobra.Command{
 			return
 		}
 		// signup
-		data, err := utils.CLISignup(username, password)
+		data, err := utils.CLISignup(username, password, flagInvite)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		var resp map[string]string
+		var resp utils.Response
 		err = json.Unmarshal(data, &resp)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
-		if resp["status"] == "success" {
+		if resp.Status == "success" {
 			fmt.Println("Signup successful")
 		} else {
-			fmt.Println("Signup failed")
+			fmt.Println("Signup failed:", resp.Err())
 		}
 	},
 }
+
+func init() {
+	signupCmd.Flags().StringVarP(&flagInvite, "invite", "i", "", "invite code")
+}
@@ -44,7 +44,7 @@ var updateCmd = &cobra.Command{
 			flagContent = args[1]
 		}
 
-		note, err := utils.UpdateNote(ID, flagTitle, flagContent, config.Token)
+		note, err := utils.UpdateNote(workspace(config), ID, flagTitle, flagContent, config.Token)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -0,0 +1,169 @@
+// admin.js
+
+// pages of the dashboard and their loaders
+const pages = {
+  users: loadUsers,
+  storage: loadStorage,
+  signups: loadSignups,
+  health: loadHealth,
+};
+
+errorEl = document.getElementById("errorEl");
+
+window.addEventListener("hashchange", showPage);
+document.getElementById("searchForm").addEventListener("submit", (event) => {
+  event.preventDefault();
+  loadUsers();
+});
+showPage();
+
+// showPage shows the page named in the location hash
+function showPage() {
+  let page = window.location.hash.substring(1);
+  if (!(page in pages)) {
+    page = "users";
+  }
+  document.querySelectorAll(".admin-page").forEach((el) => {
+    el.classList.toggle("d-none", el.id != "page-" + page);
+  });
+  document.querySelectorAll("[data-page]").forEach((el) => {
+    el.classList.toggle("active", el.dataset.page == page);
+  });
+  errorEl.classList.add("d-none");
+  pages[page]();
+}
+
+// showError shows an error returned by the server
+function showError(data) {
+  errorEl.textContent = errorMessage(data) || "something went wrong";
+  errorEl.classList.remove("d-none");
+}
+
+function loadUsers() {
+  const query = document.getElementById("searchInput").value;
+  getData("/admin/users?q=" + encodeURIComponent(query)).then((data) => {
+    if (data.status != "success") {
+      showError(data);
+      return;
+    }
+    document.getElementById("usersTotalEl").textContent =
+      data.total + " user(s) found";
+    let rows = "";
+    data.users.forEach((user) => {
+      const suspended = user.suspended_at && user.suspended_at.Valid;
+      const action = suspended ? "unsuspend" : "suspend";
+      rows += `
+      <tr>
+        <td>@${escapeHTML(user.username)}</td>
+        <td>${escapeHTML(user.email)}</td>
+        <td>${escapeHTML(user.role)}</td>
+        <td>${user.level}</td>
+        <td>${suspended ? "suspended" : "active"}${
+        user.reset_required ? ", reset required" : ""
+      }</td>
+        <td>
+          <button class="btn btn-sm btn-outline-danger"
+            onclick="userAction('${user.username}', '${action}')">${action}</button>
+          <button class="btn btn-sm btn-outline-secondary"
+            onclick="userAction('${user.username}', 'reset')">reset password</button>
+        </td>
+      </tr>`;
+    });
+    document.getElementById("usersEl").innerHTML = rows;
+  });
+}
+
+// userAction runs an action on a user and reloads the list
+function userAction(username, action) {
+  if (!confirm(action + " @" + username + "?")) {
+    return;
+  }
+  postData("/admin/users/" + username + "/" + action, {}).then((data) => {
+    if (data.status != "success") {
+      showError(data);
+      return;
+    }
+    loadUsers();
+  });
+}
+
+function loadStorage() {
+  getData("/admin/storage").then((data) => {
+    if (data.status != "success") {
+      showError(data);
+      return;
+    }
+    document.getElementById("storageTotalEl").textContent =
+      data.usage.notes + " note(s) using " + formatBytes(data.usage.bytes);
+    let rows = "";
+    data.storage.forEach((usage) => {
+      rows += `
+      <tr>
+        <td>@${escapeHTML(usage.username)}</td>
+        <td>${usage.notes}</td>
+        <td>${formatBytes(usage.bytes)}</td>
+      </tr>`;
+    });
+    document.getElementById("storageEl").innerHTML = rows;
+  });
+}
+
+function loadSignups() {
+  getData("/admin/signups?days=7").then((data) => {
+    if (data.status != "success") {
+      showError(data);
+      return;
+    }
+    document.getElementById("signupsTotalEl").textContent =
+      data.total + " signup(s) in the last 7 days";
+    let rows = "";
+    data.users.forEach((user) => {
+      rows += `
+      <tr>
+        <td>@${escapeHTML(user.username)}</td>
+        <td>${escapeHTML(user.email)}</td>
+        <td>${user.email_verified ? "yes" : "no"}</td>
+        <td>${new Date(user.created_at).toLocaleString()}</td>
+      </tr>`;
+    });
+    document.getElementById("signupsEl").innerHTML = rows;
+  });
+}
+
+function loadHealth() {
+  getData("/api/health").then((data) => {
+    const rows = [
+      ["Status", data.status],
+      ["Uptime", data.uptime],
+      ["Startup", data.startup],
+      ["Go version", data.system.version],
+      ["Goroutines", data.system.goroutines_count],
+      ["Memory in use", formatBytes(data.system.alloc_bytes)],
+    ];
+    for (const name in data.failures || {}) {
+      rows.push([name, data.failures[name]]);
+    }
+    document.getElementById("healthEl").innerHTML = rows
+      .map(
+        (row) =>
+          `<tr><th>${escapeHTML(row[0])}</th><td>${escapeHTML(row[1])}</td></tr>`
+      )
+      .join("");
+  });
+}
+
+function formatBytes(bytes) {
+  const units = ["B", "KB", "MB", "GB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i == 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+function escapeHTML(value) {
+  const div = document.createElement("div");
+  div.textContent = value == null ? "" : String(value);
+  return div.innerHTML;
+}
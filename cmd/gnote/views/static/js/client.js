@@ -10,6 +10,7 @@ async function postData(url = "", data = {}) {
     credentials: "same-origin", // include, *same-origin, omit
     headers: {
       "Content-Type": "application/json",
+      "X-CSRF-Token": csrfToken(),
       // 'Content-Type': 'application/x-www-form-urlencoded',
     },
     redirect: "follow", // manual, *follow, error
@@ -48,6 +49,7 @@ async function deleteData(url = "", data = {}) {
     credentials: "same-origin", // include, *same-origin, omit
     headers: {
       "Content-Type": "application/json",
+      "X-CSRF-Token": csrfToken(),
       // 'Content-Type': 'application/x-www-form-urlencoded',
     },
     redirect: "follow", // manual, *follow, error
@@ -57,6 +59,38 @@ async function deleteData(url = "", data = {}) {
   return response.json(); // parses JSON response into native JavaScript objects
 }
 
+// PATCH request
+async function patchData(url = "", data = {}) {
+  // Default options are marked with *
+  const response = await fetch(url, {
+    method: "PATCH", // *GET, POST, PUT, DELETE, etc.
+    mode: "cors", // no-cors, *cors, same-origin
+    cache: "no-cache", // *default, no-cache, reload, force-cache, only-if-cached
+    credentials: "same-origin", // include, *same-origin, omit
+    headers: {
+      "Content-Type": "application/json",
+      "X-CSRF-Token": csrfToken(),
+      // 'Content-Type': 'application/x-www-form-urlencoded',
+    },
+    redirect: "follow", // manual, *follow, error
+    referrerPolicy: "no-referrer", // no-referrer, *no-referrer-when-downgrade, origin, origin-when-cross-origin, same-origin, strict-origin, strict-origin-when-cross-origin, unsafe-url
+    body: JSON.stringify(data), // body data type must match "Content-Type" header
+  });
+  return response.json(); // parses JSON response into native JavaScript objects
+}
+
+// errorMessage returns the message of an error response, the errors are
+// sent in an envelope with a code and a message
+function errorMessage(data) {
+  return (data.error && data.error.message) || data.error;
+}
+
+// csrfToken returns the double-submit token sent with state-changing requests
+function csrfToken() {
+  let match = document.cookie.match(/(?:^|; )csrf_token=([^;]*)/);
+  return match ? decodeURIComponent(match[1]) : "";
+}
+
 // isEmpty check if username or password is empty
 function isEmpty(username, password) {
   if (username == "" || password == "") {
@@ -2,20 +2,416 @@ package config
 
 import (
 	"fmt"
-	"log"
+	"reflect"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
 )
 
-var EnvConfigs map[string]string
-var err error
+// redacted replaces the secrets in the printed configuration
+const redacted = "[redacted]"
 
-func LoadEnv() {
-	EnvConfigs, err = godotenv.Read()
+// Config is the configuration of the server. Every value is read from the
+// defaults, the configuration file, the environment variable of its env tag
+// and the command line flags, the later ones taking precedence.
+type Config struct {
+	Server   Server   `mapstructure:"server"`
+	Log      Log      `mapstructure:"log"`
+	Database Database `mapstructure:"database"`
+	Auth     Auth     `mapstructure:"auth"`
+	Password Password `mapstructure:"password"`
+	Lockout  Lockout  `mapstructure:"lockout"`
+	Security Security `mapstructure:"security"`
+	Mail     Mail     `mapstructure:"mail"`
+	OIDC     OIDC     `mapstructure:"oidc"`
+	Metrics  Metrics  `mapstructure:"metrics"`
+	Tracing  Tracing  `mapstructure:"tracing"`
+}
+
+// Server configures the HTTP server
+type Server struct {
+	Port int `mapstructure:"port" env:"PORT"`
+	// Mode is the gin mode, debug, release or test
+	Mode string `mapstructure:"mode" env:"GIN_MODE"`
+	// AppURL is the public URL used in links, required to mail them
+	AppURL string `mapstructure:"app_url" env:"APP_URL"`
+	// TrustedProxies are the IPs or CIDRs of the reverse proxies whose
+	// X-Forwarded-For and X-Forwarded-Proto give the client IP and scheme,
+	// none when empty
+	TrustedProxies []string `mapstructure:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	// Demo runs on in-memory data seeded for a demo
+	Demo bool `mapstructure:"demo"`
+}
+
+// Log configures the logs of the server
+type Log struct {
+	// Level is debug, info, warn or error
+	Level string `mapstructure:"level" env:"LOG_LEVEL"`
+	// Format is json, or text for a terminal
+	Format string `mapstructure:"format" env:"LOG_FORMAT"`
+}
+
+// Database configures the database connection
+type Database struct {
+	// Driver is postgres or sqlite
+	Driver string `mapstructure:"driver" env:"DB_DRIVER"`
+	// Path is the SQLite database file
+	Path     string `mapstructure:"path" env:"DB_PATH"`
+	Host     string `mapstructure:"host" env:"POSTGRES_HOST"`
+	Port     int    `mapstructure:"port" env:"POSTGRES_PORT"`
+	Name     string `mapstructure:"name" env:"POSTGRES_DB"`
+	User     string `mapstructure:"user" env:"POSTGRES_USER"`
+	Password string `mapstructure:"password" env:"POSTGRES_PASSWORD" secret:"true"`
+	SSLMode  string `mapstructure:"sslmode" env:"POSTGRES_SSLMODE"`
+	TimeZone string `mapstructure:"timezone" env:"POSTGRES_TIMEZONE"`
+	// AutoMigrate applies the pending migrations when the server starts
+	AutoMigrate bool `mapstructure:"auto_migrate" env:"DB_AUTO_MIGRATE"`
+	// ConnectAttempts is the number of connection attempts at start, the
+	// delay between two of them starts at ConnectBackoff and doubles
+	ConnectAttempts int           `mapstructure:"connect_attempts" env:"DB_CONNECT_ATTEMPTS"`
+	ConnectBackoff  time.Duration `mapstructure:"connect_backoff" env:"DB_CONNECT_BACKOFF"`
+	// AllowDegraded starts the server when the database is unreachable, the
+	// API answers 503 until it connects
+	AllowDegraded bool `mapstructure:"allow_degraded" env:"DB_ALLOW_DEGRADED"`
+	// MaxOpenConns is unlimited when zero
+	MaxOpenConns    int           `mapstructure:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `mapstructure:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `mapstructure:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+}
+
+// Auth configures the tokens and the accounts
+type Auth struct {
+	JWTSecret         string   `mapstructure:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+	JWTPrivateKeyFile string   `mapstructure:"jwt_private_key_file" env:"JWT_PRIVATE_KEY_FILE"`
+	JWTPublicKeyFiles []string `mapstructure:"jwt_public_key_files" env:"JWT_PUBLIC_KEY_FILES"`
+	// SetupToken creates the first admin, generated and logged when empty
+	SetupToken string `mapstructure:"setup_token" env:"SETUP_TOKEN" secret:"true"`
+	// RegistrationMode is open, invite-only or closed
+	RegistrationMode         string        `mapstructure:"registration_mode" env:"REGISTRATION_MODE"`
+	RequireEmailVerification bool          `mapstructure:"require_email_verification" env:"REQUIRE_EMAIL_VERIFICATION"`
+	DeletionGracePeriod      time.Duration `mapstructure:"deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD"`
+}
+
+// Password configures the hashing of new passwords
+type Password struct {
+	// Hash is argon2id or bcrypt
+	Hash       string `mapstructure:"hash" env:"PASSWORD_HASH"`
+	BcryptCost int    `mapstructure:"bcrypt_cost" env:"BCRYPT_COST"`
+	// Argon2Memory is in KiB
+	Argon2Memory uint32 `mapstructure:"argon2_memory" env:"ARGON2_MEMORY"`
+	Argon2Time   uint32 `mapstructure:"argon2_time" env:"ARGON2_TIME"`
+	// HashConcurrency bounds the Argon2id hashes computed at once, the
+	// number of CPUs when zero
+	HashConcurrency int `mapstructure:"hash_concurrency" env:"PASSWORD_HASH_CONCURRENCY"`
+}
+
+// Lockout configures the backoff and the lockout of failed logins
+type Lockout struct {
+	Threshold int `mapstructure:"threshold" env:"LOGIN_LOCKOUT_THRESHOLD"`
+	// IPThreshold is five times Threshold when zero
+	IPThreshold int           `mapstructure:"ip_threshold" env:"LOGIN_LOCKOUT_IP_THRESHOLD"`
+	Duration    time.Duration `mapstructure:"duration" env:"LOGIN_LOCKOUT_DURATION"`
+	BackoffBase time.Duration `mapstructure:"backoff_base" env:"LOGIN_BACKOFF_BASE"`
+}
+
+// Security configures the cookies, CORS and the security headers
+type Security struct {
+	// CookieSameSite is lax, strict or none
+	CookieSameSite string `mapstructure:"cookie_samesite" env:"COOKIE_SAMESITE"`
+	CookieSecure   bool   `mapstructure:"cookie_secure" env:"COOKIE_SECURE"`
+	// CORSAllowedOrigins may call the API from a browser, * for any
+	CORSAllowedOrigins    []string `mapstructure:"cors_allowed_origins" env:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowedMethods    []string `mapstructure:"cors_allowed_methods" env:"CORS_ALLOWED_METHODS"`
+	CORSAllowedHeaders    []string `mapstructure:"cors_allowed_headers" env:"CORS_ALLOWED_HEADERS"`
+	CORSAllowCredentials  bool     `mapstructure:"cors_allow_credentials" env:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge            int      `mapstructure:"cors_max_age" env:"CORS_MAX_AGE"`
+	ContentSecurityPolicy string   `mapstructure:"content_security_policy" env:"CONTENT_SECURITY_POLICY"`
+	// HSTSMaxAge is in seconds, 0 disables HSTS
+	HSTSMaxAge int `mapstructure:"hsts_max_age" env:"HSTS_MAX_AGE"`
+}
+
+// Mail configures the delivery of emails
+type Mail struct {
+	// Mailer is smtp, file or log
+	Mailer       string `mapstructure:"mailer" env:"MAILER"`
+	From         string `mapstructure:"from" env:"MAIL_FROM"`
+	Dir          string `mapstructure:"dir" env:"MAILER_DIR"`
+	SMTPHost     string `mapstructure:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `mapstructure:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `mapstructure:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+}
+
+// OIDC configures single sign-on, disabled without an issuer
+type OIDC struct {
+	Issuer       string   `mapstructure:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string   `mapstructure:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string   `mapstructure:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	Scopes       []string `mapstructure:"scopes" env:"OIDC_SCOPES"`
+	// AllowedDomains restricts sign in to these email domains, all when empty
+	AllowedDomains []string `mapstructure:"allowed_domains" env:"OIDC_ALLOWED_DOMAINS"`
+	RedirectURL    string   `mapstructure:"redirect_url" env:"OIDC_REDIRECT_URL"`
+}
+
+// Metrics configures the Prometheus endpoint
+type Metrics struct {
+	Enabled bool `mapstructure:"enabled" env:"METRICS_ENABLED"`
+	// Token is required as bearer token by /metrics when set
+	Token string `mapstructure:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+// Tracing configures the export of the OpenTelemetry traces
+type Tracing struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `mapstructure:"exporter" env:"TRACING_EXPORTER"`
+	// Endpoint is the host:port of the OTLP HTTP collector
+	Endpoint string `mapstructure:"endpoint" env:"TRACING_ENDPOINT"`
+	// Insecure sends the traces to the collector without TLS
+	Insecure    bool   `mapstructure:"insecure" env:"TRACING_INSECURE"`
+	ServiceName string `mapstructure:"service_name" env:"TRACING_SERVICE_NAME"`
+	// SampleRatio is the share of the traces started here which are kept
+	SampleRatio float64 `mapstructure:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+// Default returns the default configuration
+func Default() Config {
+	return Config{
+		Server: Server{
+			Port: 8080,
+			Mode: "debug",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Database: Database{
+			Driver:      "postgres",
+			Path:        "gnote.db",
+			Port:        5432,
+			SSLMode:     "disable",
+			TimeZone:    "UTC",
+			AutoMigrate: true,
+
+			ConnectAttempts: 5,
+			ConnectBackoff:  time.Second,
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+		},
+		Auth: Auth{
+			RegistrationMode:    "open",
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+		},
+		Password: Password{
+			Hash:         "argon2id",
+			BcryptCost:   10,
+			Argon2Memory: 64 * 1024,
+			Argon2Time:   3,
+		},
+		Lockout: Lockout{
+			Threshold:   10,
+			Duration:    15 * time.Minute,
+			BackoffBase: time.Second,
+		},
+		Security: Security{
+			CookieSameSite:     "lax",
+			CORSAllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+			CORSAllowedHeaders: []string{
+				"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization",
+				"accept", "origin", "Cache-Control", "X-Requested-With",
+			},
+			CORSMaxAge: 600,
+			HSTSMaxAge: 31536000,
+		},
+		Mail: Mail{
+			Mailer:   "log",
+			From:     "gnote <noreply@localhost>",
+			Dir:      "mail",
+			SMTPPort: 587,
+		},
+		OIDC: OIDC{
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Metrics: Metrics{
+			Enabled: true,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "localhost:4318",
+			ServiceName: "gnote",
+			SampleRatio: 1,
+		},
+	}
+}
 
+// flags are the command line flags of the server and their keys
+var flags = []struct {
+	name  string
+	key   string
+	usage string
+}{
+	{"port", "server.port", "port to listen on"},
+	{"db-driver", "database.driver", "database driver, postgres or sqlite"},
+	{"db-path", "database.path", "SQLite database file"},
+	{"demo", "server.demo", "run on in-memory data seeded with demo accounts and notes"},
+}
+
+// AddFlags adds the command line flags of the server to the flag set
+func AddFlags(fs *pflag.FlagSet) {
+	def := Default()
+	for _, flag := range flags {
+		switch value := lookup(&def, flag.key).Interface().(type) {
+		case int:
+			fs.Int(flag.name, value, flag.usage)
+		case bool:
+			fs.Bool(flag.name, value, flag.usage)
+		default:
+			fs.String(flag.name, fmt.Sprint(value), flag.usage)
+		}
+	}
+}
+
+// Read reads the configuration without validating it. The file is optional,
+// its format is given by its extension, like yaml, json or toml. Only the
+// flags added by AddFlags and set on the command line are read.
+func Read(file string, fs *pflag.FlagSet) (*Config, error) {
+	v := viper.New()
+	def := Default()
+	var err error
+	walk(reflect.ValueOf(&def).Elem(), "", func(key string, field reflect.StructField, value reflect.Value) {
+		v.SetDefault(key, value.Interface())
+		if env := field.Tag.Get("env"); env != "" && err == nil {
+			err = v.BindEnv(key, env)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fs != nil {
+		for _, flag := range flags {
+			if f := fs.Lookup(flag.name); f != nil {
+				err := v.BindPFlag(flag.key, f)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		err := v.ReadInConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the configuration file: %w", err)
+		}
+	}
+
+	var cfg Config
+	err = v.Unmarshal(&cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg.normalize()
+	return &cfg, nil
+}
+
+// Load reads the configuration and validates it
+func Load(file string, fs *pflag.FlagSet) (*Config, error) {
+	cfg, err := Read(file, fs)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Validate()
 	if err != nil {
-		log.Fatalf("Error getting env, %v", err)
-	} else {
-		fmt.Println("We are getting the env values")
+		return nil, err
 	}
+	return cfg, nil
+}
+
+// normalize splits the lists given as one string, like in the environment
+func (c *Config) normalize() {
+	walk(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.StructField, value reflect.Value) {
+		list, ok := value.Interface().([]string)
+		if !ok {
+			return
+		}
+		items := []string{}
+		for _, item := range list {
+			// scopes are separated by spaces, the other lists by commas
+			split := strings.Split(item, ",")
+			if key == "oidc.scopes" {
+				split = strings.Fields(strings.ReplaceAll(item, ",", " "))
+			}
+			for _, s := range split {
+				if s = strings.TrimSpace(s); s != "" {
+					items = append(items, s)
+				}
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	})
+	c.Server.Mode = strings.ToLower(strings.TrimSpace(c.Server.Mode))
+	c.Log.Level = strings.ToLower(strings.TrimSpace(c.Log.Level))
+	c.Log.Format = strings.ToLower(strings.TrimSpace(c.Log.Format))
+	c.Database.Driver = strings.ToLower(strings.TrimSpace(c.Database.Driver))
+	c.Auth.RegistrationMode = strings.ToLower(strings.TrimSpace(c.Auth.RegistrationMode))
+	c.Password.Hash = strings.ToLower(strings.TrimSpace(c.Password.Hash))
+	c.Security.CookieSameSite = strings.ToLower(strings.TrimSpace(c.Security.CookieSameSite))
+	c.Mail.Mailer = strings.ToLower(strings.TrimSpace(c.Mail.Mailer))
+	c.Tracing.Exporter = strings.ToLower(strings.TrimSpace(c.Tracing.Exporter))
+	for i, domain := range c.OIDC.AllowedDomains {
+		c.OIDC.AllowedDomains[i] = strings.ToLower(domain)
+	}
+}
+
+// YAML returns the configuration in the format of a configuration file,
+// with its secrets redacted
+func (c *Config) YAML() ([]byte, error) {
+	sections := make(map[string]map[string]interface{})
+	walk(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.StructField, value reflect.Value) {
+		parts := strings.SplitN(key, ".", 2)
+		section, ok := sections[parts[0]]
+		if !ok {
+			section = make(map[string]interface{})
+			sections[parts[0]] = section
+		}
+		v := value.Interface()
+		switch {
+		case field.Tag.Get("secret") == "true" && value.String() != "":
+			v = redacted
+		case field.Type == reflect.TypeOf(time.Duration(0)):
+			v = value.Interface().(time.Duration).String()
+		}
+		section[parts[1]] = v
+	})
+	return yaml.Marshal(sections)
+}
+
+// walk calls fn with every value of the configuration and its key
+func walk(v reflect.Value, prefix string, fn func(key string, field reflect.StructField, value reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := field.Tag.Get("mapstructure")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if field.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), key, fn)
+			continue
+		}
+		fn(key, field, v.Field(i))
+	}
+}
+
+// lookup returns the value of a key of the configuration
+func lookup(c *Config, key string) reflect.Value {
+	var found reflect.Value
+	walk(reflect.ValueOf(c).Elem(), "", func(k string, field reflect.StructField, value reflect.Value) {
+		if k == key {
+			found = value
+		}
+	})
+	return found
 }
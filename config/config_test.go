@@ -0,0 +1,247 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// configFile writes a configuration file for the test and returns its path
+func configFile(t *testing.T, name, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(file, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadPrecedence(t *testing.T) {
+	file := configFile(t, "gnote.yaml", `
+server:
+  port: 9000
+database:
+  driver: sqlite
+  path: file.db
+lockout:
+  duration: 5m
+`)
+
+	tests := []struct {
+		name   string
+		file   string
+		env    map[string]string
+		args   []string
+		port   int
+		driver string
+		path   string
+	}{
+		{"defaults", "", nil, nil, 8080, "postgres", "gnote.db"},
+		{"file over defaults", file, nil, nil, 9000, "sqlite", "file.db"},
+		{"env over file", file, map[string]string{"PORT": "9001", "DB_PATH": "env.db"}, nil, 9001, "sqlite", "env.db"},
+		{"flag over env", file, map[string]string{"PORT": "9001"}, []string{"--port=9002"}, 9002, "sqlite", "file.db"},
+		{"unset flag keeps env", file, map[string]string{"PORT": "9001"}, []string{"--db-path=flag.db"}, 9001, "sqlite", "flag.db"},
+		{"flag over defaults", "", nil, []string{"--db-driver=SQLite"}, 8080, "sqlite", "gnote.db"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// an empty variable counts as unset
+			for _, env := range []string{"PORT", "DB_DRIVER", "DB_PATH"} {
+				t.Setenv(env, tt.env[env])
+			}
+			fs := pflag.NewFlagSet("gnote", pflag.ContinueOnError)
+			AddFlags(fs)
+			err := fs.Parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Read(tt.file, fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Server.Port != tt.port || cfg.Database.Driver != tt.driver || cfg.Database.Path != tt.path {
+				t.Errorf("port %d, driver %q, path %q, want %d, %q, %q",
+					cfg.Server.Port, cfg.Database.Driver, cfg.Database.Path, tt.port, tt.driver, tt.path)
+			}
+		})
+	}
+}
+
+func TestReadLists(t *testing.T) {
+	file := configFile(t, "gnote.yaml", `
+security:
+  cors_allowed_origins:
+    - https://a.example.com
+    - https://b.example.com
+`)
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1, 10.1.0.0/16,")
+	t.Setenv("OIDC_SCOPES", "openid  email,groups")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "2m")
+
+	cfg, err := Read(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := []struct {
+		name      string
+		got, want []string
+	}{
+		{"origins", cfg.Security.CORSAllowedOrigins, []string{"https://a.example.com", "https://b.example.com"}},
+		{"proxies", cfg.Server.TrustedProxies, []string{"10.0.0.1", "10.1.0.0/16"}},
+		{"scopes", cfg.OIDC.Scopes, []string{"openid", "email", "groups"}},
+	}
+	for _, l := range lists {
+		if strings.Join(l.got, "|") != strings.Join(l.want, "|") {
+			t.Errorf("%s = %q, want %q", l.name, l.got, l.want)
+		}
+	}
+	if cfg.Lockout.Duration != 2*time.Minute {
+		t.Errorf("lockout duration = %s, want 2m0s", cfg.Lockout.Duration)
+	}
+}
+
+func TestReadMissingFile(t *testing.T) {
+	_, err := Read(filepath.Join(t.TempDir(), "missing.yaml"), nil)
+	if err == nil {
+		t.Error("Read() of a missing file succeeded")
+	}
+}
+
+func TestYAMLRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Database.Password = "db-password"
+	cfg.Auth.JWTSecret = "jwt-secret"
+	cfg.Mail.SMTPPassword = "smtp-password"
+	cfg.OIDC.ClientSecret = "client-secret"
+
+	out, err := cfg.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"db-password", "jwt-secret", "smtp-password", "client-secret"} {
+		if strings.Contains(string(out), secret) {
+			t.Errorf("YAML() prints the secret %q", secret)
+		}
+	}
+	for _, line := range []string{
+		`password: '[redacted]'`,
+		`jwt_secret: '[redacted]'`,
+		`smtp_password: '[redacted]'`,
+		`client_secret: '[redacted]'`,
+		// the unset secrets are shown as unset
+		`setup_token: ""`,
+		`token: ""`,
+		// durations read like in the file
+		`duration: 15m0s`,
+	} {
+		if !strings.Contains(string(out), line) {
+			t.Errorf("YAML() has no line %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"wildcard origin with credentials", func(c *Config) {
+			c.Security.CORSAllowedOrigins = []string{"https://a.example.com", "*"}
+			c.Security.CORSAllowCredentials = true
+		}, "security.cors_allow_credentials (CORS_ALLOW_CREDENTIALS): can't be used with any origin allowed"},
+		{"wildcard origin without credentials", func(c *Config) {
+			c.Security.CORSAllowedOrigins = []string{"*"}
+		}, ""},
+		{"listed origins with credentials", func(c *Config) {
+			c.Security.CORSAllowedOrigins = []string{"https://a.example.com"}
+			c.Security.CORSAllowCredentials = true
+		}, ""},
+		{"samesite none without secure", func(c *Config) {
+			c.Security.CookieSameSite = "none"
+		}, "none requires security.cookie_secure"},
+		{"samesite none with secure", func(c *Config) {
+			c.Security.CookieSameSite = "none"
+			c.Security.CookieSecure = true
+		}, ""},
+		{"no JWT secret", func(c *Config) {
+			c.Auth.JWTSecret = ""
+		}, "auth.jwt_secret (JWT_SECRET): is required"},
+		{"port out of range", func(c *Config) {
+			c.Server.Port = 70000
+		}, "server.port (PORT): 70000 is not a port"},
+		{"trusted proxy not an IP", func(c *Config) {
+			c.Server.TrustedProxies = []string{"10.0.0.0/8", "proxy.local"}
+		}, `"proxy.local" is not an IP or a CIDR`},
+		{"postgres without host", func(c *Config) {
+			c.Database.Driver = "postgres"
+		}, "database.host (POSTGRES_HOST): is required"},
+		{"demo without database", func(c *Config) {
+			c.Database.Driver = "postgres"
+			c.Server.Demo = true
+		}, ""},
+		{"more idle than open connections", func(c *Config) {
+			c.Database.MaxIdleConns = 30
+		}, "database.max_idle_conns (DB_MAX_IDLE_CONNS): 30 is more than"},
+		{"unknown registration mode", func(c *Config) {
+			c.Auth.RegistrationMode = "invite"
+		}, `auth.registration_mode (REGISTRATION_MODE): "invite" should be one of`},
+		{"bcrypt cost out of range", func(c *Config) {
+			c.Password.BcryptCost = 3
+		}, "password.bcrypt_cost (BCRYPT_COST): 3 is not between 4 and 31"},
+		{"oidc issuer without client", func(c *Config) {
+			c.OIDC.Issuer = "https://id.example.com"
+			c.Server.AppURL = "https://gnote.example.com"
+		}, "oidc.client_id (OIDC_CLIENT_ID): and oidc.issuer"},
+		{"oidc without callback URL", func(c *Config) {
+			c.OIDC.Issuer = "https://id.example.com"
+			c.OIDC.ClientID = "gnote"
+		}, "oidc.redirect_url (OIDC_REDIRECT_URL): or server.app_url"},
+		{"smtp without host", func(c *Config) {
+			c.Mail.Mailer = "smtp"
+		}, "mail.smtp_host (SMTP_HOST): is required"},
+		{"otlp without endpoint", func(c *Config) {
+			c.Tracing.Exporter = "otlp"
+			c.Tracing.Endpoint = ""
+		}, "tracing.endpoint (TRACING_ENDPOINT): is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Auth.JWTSecret = "secret"
+			cfg.Database.Driver = "sqlite"
+			tt.change(&cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("Validate() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateListsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Port = 0
+	cfg.Log.Level = "verbose"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate() = nil, want the problems")
+	}
+	for _, key := range []string{"server.port", "log.level", "database.host", "auth.jwt_secret"} {
+		if !strings.Contains(err.Error(), "  - "+key+" ") {
+			t.Errorf("Validate() = %v, want a problem with %s", err, key)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// problems collects the invalid values of a configuration
+type problems []string
+
+// add records an invalid value by its key and environment variable
+func (p *problems) add(key, env, format string, args ...interface{}) {
+	name := key
+	if env != "" {
+		name += " (" + env + ")"
+	}
+	*p = append(*p, name+": "+fmt.Sprintf(format, args...))
+}
+
+// err returns the problems as one error, nil without problems
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  - " + strings.Join(p, "\n  - "))
+}
+
+// oneOf checks if the value is one of the allowed ones
+func (p *problems) oneOf(key, env, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	p.add(key, env, "%q should be one of %s", value, strings.Join(allowed, ", "))
+}
+
+// port checks if the value is a TCP port
+func (p *problems) port(key, env string, value int) {
+	if value < 1 || value > 65535 {
+		p.add(key, env, "%d is not a port between 1 and 65535", value)
+	}
+}
+
+// url checks if the value is an absolute HTTP URL, when set
+func (p *problems) url(key, env, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.add(key, env, "%q is not an http or https URL", value)
+	}
+}
+
+// Validate checks the whole configuration of the server
+func (c *Config) Validate() error {
+	var p problems
+	c.Server.validate(&p)
+	c.Log.validate(&p)
+	if !c.Server.Demo {
+		c.Database.validate(&p)
+	}
+	c.Auth.validate(&p)
+	c.Password.validate(&p)
+	c.Lockout.validate(&p)
+	c.Security.validate(&p)
+	c.Mail.validate(&p)
+	c.OIDC.validate(&p)
+	// the callback URL is never taken from the requests
+	if c.OIDC.Issuer != "" && c.OIDC.RedirectURL == "" && c.Server.AppURL == "" {
+		p.add("oidc.redirect_url", "OIDC_REDIRECT_URL", "or server.app_url (APP_URL) is required by single sign-on")
+	}
+	c.Tracing.validate(&p)
+	return p.err()
+}
+
+// Validate checks the database configuration alone, for the commands which
+// only use the database
+func (d Database) Validate() error {
+	var p problems
+	d.validate(&p)
+	return p.err()
+}
+
+// Validate checks the password configuration alone
+func (pw Password) Validate() error {
+	var p problems
+	pw.validate(&p)
+	return p.err()
+}
+
+func (s Server) validate(p *problems) {
+	p.port("server.port", "PORT", s.Port)
+	p.oneOf("server.mode", "GIN_MODE", s.Mode, "debug", "release", "test")
+	p.url("server.app_url", "APP_URL", s.AppURL)
+	for _, proxy := range s.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		if err != nil && net.ParseIP(proxy) == nil {
+			p.add("server.trusted_proxies", "TRUSTED_PROXIES", "%q is not an IP or a CIDR", proxy)
+		}
+	}
+}
+
+func (l Log) validate(p *problems) {
+	p.oneOf("log.level", "LOG_LEVEL", l.Level, "debug", "info", "warn", "error")
+	p.oneOf("log.format", "LOG_FORMAT", l.Format, "json", "text")
+}
+
+func (d Database) validate(p *problems) {
+	switch d.Driver {
+	case "postgres":
+		required := []struct {
+			key, env, value string
+		}{
+			{"database.host", "POSTGRES_HOST", d.Host},
+			{"database.name", "POSTGRES_DB", d.Name},
+			{"database.user", "POSTGRES_USER", d.User},
+			{"database.password", "POSTGRES_PASSWORD", d.Password},
+		}
+		for _, r := range required {
+			if r.value == "" {
+				p.add(r.key, r.env, "is required by the postgres driver")
+			}
+		}
+		p.port("database.port", "POSTGRES_PORT", d.Port)
+		p.oneOf("database.sslmode", "POSTGRES_SSLMODE", d.SSLMode,
+			"disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+		if _, err := time.LoadLocation(d.TimeZone); err != nil || d.TimeZone == "" {
+			p.add("database.timezone", "POSTGRES_TIMEZONE", "%q is not a time zone", d.TimeZone)
+		}
+	case "sqlite":
+		if d.Path == "" {
+			p.add("database.path", "DB_PATH", "is required by the sqlite driver")
+		}
+	default:
+		p.oneOf("database.driver", "DB_DRIVER", d.Driver, "postgres", "sqlite")
+	}
+	if d.ConnectAttempts < 1 {
+		p.add("database.connect_attempts", "DB_CONNECT_ATTEMPTS", "should be at least 1")
+	}
+	if d.ConnectBackoff <= 0 {
+		p.add("database.connect_backoff", "DB_CONNECT_BACKOFF", "should be positive")
+	}
+	if d.MaxOpenConns < 0 {
+		p.add("database.max_open_conns", "DB_MAX_OPEN_CONNS", "is negative")
+	}
+	if d.MaxIdleConns < 0 {
+		p.add("database.max_idle_conns", "DB_MAX_IDLE_CONNS", "is negative")
+	}
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		p.add("database.max_idle_conns", "DB_MAX_IDLE_CONNS", "%d is more than database.max_open_conns (DB_MAX_OPEN_CONNS) %d", d.MaxIdleConns, d.MaxOpenConns)
+	}
+	if d.ConnMaxLifetime < 0 {
+		p.add("database.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", "is negative")
+	}
+}
+
+func (a Auth) validate(p *problems) {
+	if a.JWTSecret == "" && a.JWTPrivateKeyFile == "" {
+		p.add("auth.jwt_secret", "JWT_SECRET", "is required without auth.jwt_private_key_file (JWT_PRIVATE_KEY_FILE)")
+	}
+	p.oneOf("auth.registration_mode", "REGISTRATION_MODE", a.RegistrationMode, "open", "invite-only", "closed")
+	if a.DeletionGracePeriod < 0 {
+		p.add("auth.deletion_grace_period", "ACCOUNT_DELETION_GRACE_PERIOD", "%s is negative", a.DeletionGracePeriod)
+	}
+}
+
+func (pw Password) validate(p *problems) {
+	p.oneOf("password.hash", "PASSWORD_HASH", pw.Hash, "argon2id", "bcrypt")
+	// the bounds of golang.org/x/crypto/bcrypt
+	if pw.BcryptCost < 4 || pw.BcryptCost > 31 {
+		p.add("password.bcrypt_cost", "BCRYPT_COST", "%d is not between 4 and 31", pw.BcryptCost)
+	}
+	if pw.Argon2Memory < 8*1024 {
+		p.add("password.argon2_memory", "ARGON2_MEMORY", "%d KiB is less than 8192 KiB", pw.Argon2Memory)
+	}
+	if pw.Argon2Time < 1 {
+		p.add("password.argon2_time", "ARGON2_TIME", "should be at least 1")
+	}
+	if pw.HashConcurrency < 0 {
+		p.add("password.hash_concurrency", "PASSWORD_HASH_CONCURRENCY", "is negative")
+	}
+}
+
+func (l Lockout) validate(p *problems) {
+	if l.Threshold < 1 {
+		p.add("lockout.threshold", "LOGIN_LOCKOUT_THRESHOLD", "should be at least 1")
+	}
+	if l.IPThreshold < 0 {
+		p.add("lockout.ip_threshold", "LOGIN_LOCKOUT_IP_THRESHOLD", "is negative")
+	}
+	if l.Duration <= 0 {
+		p.add("lockout.duration", "LOGIN_LOCKOUT_DURATION", "should be positive")
+	}
+	if l.BackoffBase <= 0 {
+		p.add("lockout.backoff_base", "LOGIN_BACKOFF_BASE", "should be positive")
+	}
+}
+
+func (s Security) validate(p *problems) {
+	p.oneOf("security.cookie_samesite", "COOKIE_SAMESITE", s.CookieSameSite, "lax", "strict", "none")
+	// browsers drop SameSite=None cookies without the Secure attribute
+	if s.CookieSameSite == "none" && !s.CookieSecure {
+		p.add("security.cookie_samesite", "COOKIE_SAMESITE", "none requires security.cookie_secure (COOKIE_SECURE)")
+	}
+	// a wildcard with credentials would let any site act as the user
+	for _, origin := range s.CORSAllowedOrigins {
+		if origin == "*" && s.CORSAllowCredentials {
+			p.add("security.cors_allow_credentials", "CORS_ALLOW_CREDENTIALS", "can't be used with any origin allowed")
+		}
+	}
+	if s.CORSMaxAge < 0 {
+		p.add("security.cors_max_age", "CORS_MAX_AGE", "is negative")
+	}
+	if s.HSTSMaxAge < 0 {
+		p.add("security.hsts_max_age", "HSTS_MAX_AGE", "is negative")
+	}
+}
+
+func (m Mail) validate(p *problems) {
+	p.oneOf("mail.mailer", "MAILER", m.Mailer, "smtp", "file", "log")
+	switch m.Mailer {
+	case "smtp":
+		if m.SMTPHost == "" {
+			p.add("mail.smtp_host", "SMTP_HOST", "is required by the smtp mailer")
+		}
+		p.port("mail.smtp_port", "SMTP_PORT", m.SMTPPort)
+	case "file":
+		if m.Dir == "" {
+			p.add("mail.dir", "MAILER_DIR", "is required by the file mailer")
+		}
+	}
+}
+
+func (o OIDC) validate(p *problems) {
+	if (o.Issuer == "") != (o.ClientID == "") {
+		p.add("oidc.client_id", "OIDC_CLIENT_ID", "and oidc.issuer (OIDC_ISSUER) should be set together")
+	}
+	p.url("oidc.issuer", "OIDC_ISSUER", o.Issuer)
+	p.url("oidc.redirect_url", "OIDC_REDIRECT_URL", o.RedirectURL)
+}
+
+func (t Tracing) validate(p *problems) {
+	p.oneOf("tracing.exporter", "TRACING_EXPORTER", t.Exporter, "none", "stdout", "otlp")
+	if t.Exporter == "otlp" && t.Endpoint == "" {
+		p.add("tracing.endpoint", "TRACING_ENDPOINT", "is required by the otlp exporter")
+	}
+	if t.Exporter != "none" && t.ServiceName == "" {
+		p.add("tracing.service_name", "TRACING_SERVICE_NAME", "is required to export traces")
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		p.add("tracing.sample_ratio", "TRACING_SAMPLE_RATIO", "%g is not between 0 and 1", t.SampleRatio)
+	}
+}
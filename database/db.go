@@ -3,63 +3,243 @@ package database
 import (
 	"fmt"
 	"log"
-	"os"
+	"sync/atomic"
+	"time"
 
-	"github.com/mrinjamul/gnote/models"
+	"github.com/glebarez/sqlite"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/logger"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+const (
+	// DriverPostgres is the PostgreSQL database driver
+	DriverPostgres = "postgres"
+	// DriverSQLite is the pure Go SQLite database driver
+	DriverSQLite = "sqlite"
+)
+
+const (
+	// maxConnectBackoff caps the delay between two connection attempts
+	maxConnectBackoff = 30 * time.Second
+	// monitorInterval is the delay between two pings of the monitor
+	monitorInterval = 10 * time.Second
+)
+
 var (
-	// IsConnected returns the connection status
-	IsConnected bool
+	// connected is 1 while the database is reachable and migrated
+	connected int32
 )
 
-func GetDB() *gorm.DB {
+// ConnectError is returned when the database can't be reached
+type ConnectError struct {
+	Driver   string
+	Attempts int
+	Err      error
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("failed to connect to the %s database after %d attempts: %v", e.Driver, e.Attempts, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationError is returned when the pending migrations can't be applied
+type MigrationError struct {
+	Err error
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("failed to migrate the database: %v", e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+// Connected reports whether the database is reachable and migrated
+func Connected() bool {
+	return atomic.LoadInt32(&connected) == 1
+}
+
+// setConnected records whether the database is reachable and migrated
+func setConnected(ok bool) {
+	var value int32
+	if ok {
+		value = 1
+	}
+	atomic.StoreInt32(&connected, value)
+}
+
+// GetDB connects to the database and applies the pending migrations unless
+// auto migration is disabled. On a *ConnectError the returned handle is
+// still usable, it connects once the database is reachable.
+func GetDB(cfg config.Database) (*gorm.DB, error) {
+	db, err := Connect(cfg)
+	if err != nil {
+		return db, err
+	}
+	if cfg.AutoMigrate {
+		err = migrate(db)
+		if err != nil {
+			return db, err
+		}
+	}
+	setConnected(true)
+	return db, nil
+}
+
+// Connect connects to the database of the configured driver, postgres by
+// default or sqlite for a single binary instance, with the configured pool
+// limits. It retries with an exponential backoff, on a *ConnectError the
+// returned handle is still usable.
+func Connect(cfg config.Database) (*gorm.DB, error) {
 	var db *gorm.DB
-	// Get ENV variables
-	dbHost := os.Getenv("POSTGRES_HOST")
-	dbName := os.Getenv("POSTGRES_DB")
-	dbUser := os.Getenv("POSTGRES_USER")
-	dbPassword := os.Getenv("POSTGRES_PASSWORD")
-	dbPort := os.Getenv("POSTGRES_PORT")
-	if db == nil {
-		if dbHost == "" {
-			fmt.Println("Environment variable DB_HOST is null.")
-			return nil
+	var err error
+	switch cfg.Driver {
+	case DriverPostgres:
+		db, err = openPostgres(cfg)
+	case DriverSQLite:
+		db, err = openSQLite(cfg)
+	default:
+		return nil, fmt.Errorf("database driver should be %s or %s, not %q", DriverPostgres, DriverSQLite, cfg.Driver)
+	}
+	if err != nil {
+		return nil, &ConnectError{Driver: cfg.Driver, Attempts: 1, Err: err}
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	backoff := cfg.ConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err = sqlDB.Ping()
+		if err == nil {
+			return db, nil
 		}
-		if dbName == "" {
-			fmt.Println("Environment variable DB_NAME is null.")
-			return nil
+		if attempt >= cfg.ConnectAttempts {
+			return db, &ConnectError{Driver: cfg.Driver, Attempts: attempt, Err: err}
 		}
-		if dbUser == "" {
-			fmt.Println("Environment variable DB_USERNAME is null.")
-			return nil
+		log.Printf("failed to connect to the %s database, retrying in %s: %v", cfg.Driver, backoff, err)
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff)
+	}
+}
+
+// Reconnect pings the database with backoff until it is reachable, then
+// applies the pending migrations unless auto migration is disabled. It
+// leaves the degraded mode, in which the server runs without database.
+func Reconnect(db *gorm.DB, cfg config.Database) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Println("failed to reconnect the database:", err)
+		return
+	}
+	backoff := cfg.ConnectBackoff
+	for {
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff)
+		err := sqlDB.Ping()
+		if err != nil {
+			log.Printf("database still unreachable, retrying in %s: %v", backoff, err)
+			continue
 		}
-		if dbPassword == "" {
-			fmt.Println("Environment variable DB_PASSWORD is null.")
-			return nil
+		if cfg.AutoMigrate {
+			err = migrate(db)
+			if err != nil {
+				log.Printf("%v, retrying in %s", err, backoff)
+				continue
+			}
 		}
+		setConnected(true)
+		log.Println("database connected, leaving the degraded mode")
+		return
+	}
+}
 
-		if dbPort == "" {
-			dbPort = "5432"
+// Monitor pings the database every monitorInterval for the life of the
+// server. Once it is unreachable the server enters the degraded mode, and
+// leaves it when Reconnect gets it back.
+func Monitor(db *gorm.DB, cfg config.Database) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Println("failed to monitor the database:", err)
+		return
+	}
+	for {
+		if !Connected() {
+			Reconnect(db, cfg)
+		}
+		time.Sleep(monitorInterval)
+		err := sqlDB.Ping()
+		if err != nil && Connected() {
+			setConnected(false)
+			log.Printf("database unreachable, entering the degraded mode: %v", err)
 		}
 	}
+}
+
+// nextBackoff doubles the delay between two connection attempts
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxConnectBackoff {
+		backoff = maxConnectBackoff
+	}
+	return backoff
+}
 
-	// Connect to db
-	dest := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=Asia/Kolkata",
-		dbHost, dbUser, dbPassword, dbName, dbPort)
-	db, err := gorm.Open(postgres.Open(dest), &gorm.Config{})
+// GetMemoryDB opens a private SQLite database kept in memory and migrates
+// the schema, for the tests of the GORM repositories
+func GetMemoryDB() (*gorm.DB, error) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.GORM(DriverSQLite)})
+	if err != nil {
+		return nil, &ConnectError{Driver: DriverSQLite, Attempts: 1, Err: err}
+	}
+	// every connection would get its own empty database
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	err = migrate(db)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
 
-	if err == nil {
-		IsConnected = true
-	} else {
-		log.Println("failed to connect database")
+// migrate applies the pending migrations, logging them
+func migrate(db *gorm.DB) error {
+	applied, err := MigrateUp(db)
+	for _, migration := range applied {
+		log.Printf("applied migration %d %s", migration.Version, migration.Name)
+	}
+	if err != nil {
+		return &MigrationError{Err: err}
 	}
+	return nil
+}
+
+// openPostgres connects to the PostgreSQL database
+func openPostgres(cfg config.Database) (*gorm.DB, error) {
+	dest := fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=%s",
+		cfg.Host, cfg.User, cfg.Password, cfg.Name, cfg.Port, cfg.SSLMode, cfg.TimeZone)
+	// the connection is checked with retries by Connect
+	return gorm.Open(postgres.Open(dest), &gorm.Config{DisableAutomaticPing: true, Logger: logger.GORM(DriverPostgres)})
+}
 
-	// Migrate the schema
-	db.AutoMigrate(&models.Note{})
-	db.AutoMigrate(&models.User{})
-	return db
+// openSQLite opens the SQLite database file, created if missing
+func openSQLite(cfg config.Database) (*gorm.DB, error) {
+	// foreign keys are off by default, the busy timeout lets concurrent
+	// writers wait for each other
+	dest := cfg.Path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	return gorm.Open(sqlite.Open(dest), &gorm.Config{DisableAutomaticPing: true, Logger: logger.GORM(DriverSQLite)})
 }
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/mrinjamul/gnote/health"
+	"gorm.io/gorm"
+)
+
+// RegisterChecks registers the readiness checks of the database: it should
+// answer a ping and have all the migrations of this build applied. A failed
+// ping enters the degraded mode until the monitor reconnects.
+func RegisterChecks(checker *health.Checker, db *gorm.DB) {
+	checker.Register(health.Check{
+		Name:     "database",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			err = sqlDB.PingContext(ctx)
+			if err != nil && Connected() {
+				setConnected(false)
+				log.Printf("database unreachable, entering the degraded mode: %v", err)
+			}
+			return err
+		},
+	})
+	checker.Register(health.Check{
+		Name:     "migrations",
+		Critical: true,
+		Run: func(ctx context.Context) error {
+			pending, err := PendingMigrations(ctx, db)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("%d pending migrations, the first is %04d %s", len(pending), pending[0].Version, pending[0].Name)
+			}
+			return nil
+		},
+	})
+}
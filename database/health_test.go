@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mrinjamul/gnote/health"
+)
+
+func TestDatabaseCheckEntersDegradedMode(t *testing.T) {
+	db, err := GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := health.NewChecker()
+	RegisterChecks(checker, db)
+	setConnected(true)
+	t.Cleanup(func() { setConnected(false) })
+
+	report := checker.Run(context.Background())
+	if !report.Ready || !Connected() {
+		t.Fatalf("reachable database: ready = %v, connected = %v, want both", report.Ready, Connected())
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+	report = checker.Run(context.Background())
+	if report.Ready {
+		t.Error("closed database: ready = true, want false")
+	}
+	if Connected() {
+		t.Error("closed database: connected = true, want the degraded mode")
+	}
+}
@@ -0,0 +1,407 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationsFs holds the migrations of each driver, named like
+// migrations/<driver>/0001_initial_schema.up.sql and .down.sql
+//
+//go:embed migrations
+var migrationsFs embed.FS
+
+const (
+	// migrationsTable records the applied migrations
+	migrationsTable = "schema_migrations"
+	// migrationLockID is the PostgreSQL advisory lock held while migrating
+	migrationLockID = 7_466_110
+)
+
+var (
+	// migrationFile matches the file names of the migrations
+	migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	// migrationName matches the names of new migrations
+	migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+	// ErrUnknownMigration is returned when the database has a migration
+	// applied which this build doesn't know, e.g. after a downgrade
+	ErrUnknownMigration = errors.New("unknown migration")
+)
+
+// Migration is a versioned change of the schema, applied by its up script and
+// reverted by its down script
+type Migration struct {
+	Version uint64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration with the time it was applied
+type MigrationState struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// migrator applies the migrations of a driver on one connection
+type migrator struct {
+	db         *sql.DB
+	driver     string
+	migrations []Migration
+}
+
+// newMigrator loads the migrations of the driver of the database
+func newMigrator(db *gorm.DB) (*migrator, error) {
+	if db == nil {
+		return nil, errors.New("no database connection")
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	driver := db.Dialector.Name()
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{db: sqlDB, driver: driver, migrations: migrations}, nil
+}
+
+// loadMigrations reads the embedded migrations of a driver, by version
+func loadMigrations(driver string) ([]Migration, error) {
+	dir := "migrations/" + driver
+	entries, err := fs.ReadDir(migrationsFs, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for the %s driver", driver)
+	}
+	byVersion := make(map[uint64]*Migration)
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		script, err := fs.ReadFile(migrationsFs, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrateUp applies the pending migrations in order and returns them
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	m, err := newMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	var applied []Migration
+	err = m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		states, err := m.states(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			if state.Applied {
+				continue
+			}
+			// recording the version first takes the write lock of SQLite, so
+			// a concurrent run fails on the primary key instead of applying
+			// the migration twice
+			err := m.apply(ctx, conn, state.Migration.Up,
+				m.bind("INSERT INTO "+migrationsTable+" (version, name, applied_at) VALUES (?, ?, ?)"),
+				state.Version, state.Name, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", state.Version, state.Name, err)
+			}
+			applied = append(applied, state.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// MigrateDown reverts the given number of applied migrations, the latest
+// first, and returns them
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	m, err := newMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	err = m.locked(func(ctx context.Context, conn *sql.Conn) error {
+		states, err := m.states(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(states) - 1; i >= 0 && len(reverted) < steps; i-- {
+			state := states[i]
+			if !state.Applied {
+				continue
+			}
+			if state.Up == "" {
+				return fmt.Errorf("%w %d, it can't be reverted by this build", ErrUnknownMigration, state.Version)
+			}
+			err := m.apply(ctx, conn, state.Migration.Down,
+				m.bind("DELETE FROM "+migrationsTable+" WHERE version = ?"),
+				state.Version)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %w", state.Version, state.Name, err)
+			}
+			reverted = append(reverted, state.Migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus returns the known and the applied migrations, by version
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	m, err := newMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = m.createTable(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	return m.states(ctx, conn)
+}
+
+// PendingMigrations returns the known migrations which aren't applied yet,
+// without creating the migrations table
+func PendingMigrations(ctx context.Context, db *gorm.DB) ([]Migration, error) {
+	m, err := newMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	if !db.WithContext(ctx).Migrator().HasTable(migrationsTable) {
+		return m.migrations, nil
+	}
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	states, err := m.states(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, state := range states {
+		if !state.Applied {
+			pending = append(pending, state.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// CreateMigration writes empty up and down scripts for each driver in dir,
+// numbered after the latest migration, and returns their paths
+func CreateMigration(dir, name string) ([]string, error) {
+	name = strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(name)))
+	if !migrationName.MatchString(name) {
+		return nil, errors.New("the name should only have letters, digits and underscores")
+	}
+	drivers := []string{DriverPostgres, DriverSQLite}
+	var version uint64
+	for _, driver := range drivers {
+		entries, err := os.ReadDir(filepath.Join(dir, driver))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			match := migrationFile.FindStringSubmatch(entry.Name())
+			if match == nil {
+				continue
+			}
+			v, err := strconv.ParseUint(match[1], 10, 64)
+			if err == nil && v > version {
+				version = v
+			}
+		}
+	}
+	version++
+
+	var paths []string
+	for _, driver := range drivers {
+		err := os.MkdirAll(filepath.Join(dir, driver), 0755)
+		if err != nil {
+			return paths, err
+		}
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(dir, driver, fmt.Sprintf("%04d_%s.%s.sql", version, name, direction))
+			content := fmt.Sprintf("-- %s migration %04d %s\n", direction, version, name)
+			err := os.WriteFile(path, []byte(content), 0644)
+			if err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// locked runs fn on a dedicated connection holding the migration lock and
+// the migrations table. PostgreSQL uses an advisory lock, SQLite serializes
+// the writers of a database on its own.
+func (m *migrator) locked(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if m.driver == DriverPostgres {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID)
+		if err != nil {
+			return fmt.Errorf("failed to lock the migrations: %w", err)
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}
+	err = m.createTable(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(ctx, conn)
+}
+
+// createTable creates the migrations table if missing
+func (m *migrator) createTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+migrationsTable+
+		" (version bigint PRIMARY KEY, name text NOT NULL, applied_at timestamp NOT NULL)")
+	return err
+}
+
+// states merges the known migrations with the applied ones, an applied
+// migration unknown to this build has no scripts
+func (m *migrator) states(ctx context.Context, conn *sql.Conn) ([]MigrationState, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, applied_at FROM "+migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[uint64]MigrationState)
+	for rows.Next() {
+		var state MigrationState
+		err := rows.Scan(&state.Version, &state.Name, &state.AppliedAt)
+		if err != nil {
+			return nil, err
+		}
+		state.Applied = true
+		applied[state.Version] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		state := MigrationState{Migration: migration}
+		if a, ok := applied[migration.Version]; ok {
+			state.Applied = true
+			state.AppliedAt = a.AppliedAt
+			delete(applied, migration.Version)
+		}
+		states = append(states, state)
+	}
+	for _, state := range applied {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Version < states[j].Version })
+	return states, nil
+}
+
+// apply runs the bookkeeping statement then the script in a transaction
+func (m *migrator) apply(ctx context.Context, conn *sql.Conn, script, query string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if hasStatements(script) {
+		_, err = tx.ExecContext(ctx, script)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// bind numbers the placeholders of a query for PostgreSQL
+func (m *migrator) bind(query string) string {
+	if m.driver != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// hasStatements checks if a script has anything besides comments
+func hasStatements(script string) bool {
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// baselineSchema is the schema AutoMigrate created for the users and the
+// notes in the first release, before the migrations
+const baselineSchema = "CREATE TABLE `users` (" +
+	"`id` integer, `first_name` text NOT NULL, `middle_name` text, `last_name` text NOT NULL, " +
+	"`username` text, `email` text UNIQUE, `dob` datetime NOT NULL, `password` text NOT NULL, " +
+	"`role` text NOT NULL, `level` integer NOT NULL, `created_at` datetime NOT NULL, " +
+	"`updated_at` datetime NOT NULL, `deleted_at` datetime, PRIMARY KEY (`id`));" +
+	"CREATE TABLE `notes` (" +
+	"`id` integer, `title` text, `content` text NOT NULL, `username` text NOT NULL, " +
+	"`archived` numeric, `created_at` datetime NOT NULL, `updated_at` datetime, PRIMARY KEY (`id`));"
+
+// openTestDB opens an empty SQLite database kept in memory
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get its own empty database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestMigrateUpgradesBaseline(t *testing.T) {
+	db := openTestDB(t)
+	err := db.Exec(baselineSchema).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedUp := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	err = db.Exec("INSERT INTO users (first_name, last_name, username, email, dob, password, role, level, created_at, updated_at) "+
+		"VALUES ('Alice', 'A', 'alice', 'alice@example.com', ?, 'hash', 'user', 1, ?, ?)", signedUp, signedUp, signedUp).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Exec("INSERT INTO notes (title, content, username, created_at, updated_at) VALUES ('note', 'content', 'alice', ?, ?)",
+		signedUp.Add(time.Hour), signedUp.Add(time.Hour)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() on the baseline = %v", err)
+	}
+	migrations, err := loadMigrations(DriverSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("MigrateUp() applied %d migrations, want %d", len(applied), len(migrations))
+	}
+
+	// the notes are linked to their owner
+	var userID *uint
+	err = db.Raw("SELECT user_id FROM notes WHERE username = 'alice'").Scan(&userID).Error
+	if err != nil || userID == nil || *userID != 1 {
+		t.Errorf("note user_id = %v, %v, want 1", userID, err)
+	}
+	// the columns added since are usable
+	err = db.Exec("UPDATE users SET email_verified = true, suspended_at = ?, reset_required = false, "+
+		"deletion_due_at = NULL, sessions_revoked_at = NULL WHERE id = 1", time.Now()).Error
+	if err != nil {
+		t.Errorf("updating the new user columns = %v", err)
+	}
+	err = db.Exec("UPDATE notes SET org_id = NULL WHERE id = 1").Error
+	if err != nil {
+		t.Errorf("updating the new note columns = %v", err)
+	}
+	// the usernames are unique
+	err = db.Exec("INSERT INTO users (first_name, last_name, username, email, dob, password, role, level, created_at, updated_at) "+
+		"VALUES ('Other', 'A', 'alice', 'other@example.com', ?, 'hash', 'user', 1, ?, ?)", signedUp, signedUp, signedUp).Error
+	if err == nil {
+		t.Error("inserting a second alice succeeded, want a unique violation")
+	}
+}
+
+func TestMigrateRoundTrip(t *testing.T) {
+	db := openTestDB(t)
+	applied, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() = %v", err)
+	}
+	reverted, err := MigrateDown(db, len(applied))
+	if err != nil {
+		t.Fatalf("MigrateDown() = %v", err)
+	}
+	if len(reverted) != len(applied) {
+		t.Errorf("MigrateDown() reverted %d migrations, want %d", len(reverted), len(applied))
+	}
+	if db.Migrator().HasTable("users") {
+		t.Error("the users table is left after reverting all migrations")
+	}
+	_, err = MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() after MigrateDown = %v", err)
+	}
+	pending, err := PendingMigrations(db.Statement.Context, db)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations() = %d, %v, want none", len(pending), err)
+	}
+}
+
+func TestMigrateDedupesBeforeUniqueIndexes(t *testing.T) {
+	db := openTestDB(t)
+	_, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// back to the schema without the unique indexes
+	_, err = MigrateDown(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, statement := range []string{
+		"INSERT INTO organizations (id, name, display_name, created_at, updated_at) VALUES (1, 'acme', 'Acme', ?, ?)",
+		"INSERT INTO organizations (id, name, display_name, created_at, updated_at) VALUES (2, 'acme', 'Acme', ?, ?)",
+		"INSERT INTO username_redirects (id, username, user_id, expires_at, created_at) VALUES (1, 'alice', 1, ?, ?)",
+		"INSERT INTO username_redirects (id, username, user_id, expires_at, created_at) VALUES (2, 'alice', 2, ?, ?)",
+	} {
+		err := db.Exec(statement, now, now).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = MigrateUp(db)
+	if err != nil {
+		t.Fatalf("MigrateUp() with duplicates = %v", err)
+	}
+	var names []string
+	err = db.Raw("SELECT name FROM organizations ORDER BY id").Scan(&names).Error
+	if err != nil || len(names) != 2 || names[0] != "acme" || names[1] != "acme-2" {
+		t.Errorf("organizations = %v, %v, want [acme acme-2]", names, err)
+	}
+	var owners []uint
+	err = db.Raw("SELECT user_id FROM username_redirects").Scan(&owners).Error
+	if err != nil || len(owners) != 1 || owners[0] != 2 {
+		t.Errorf("redirect owners = %v, %v, want the latest [2]", owners, err)
+	}
+}
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS "username_redirects";
+DROP TABLE IF EXISTS "org_invites";
+DROP TABLE IF EXISTS "memberships";
+DROP TABLE IF EXISTS "organizations";
+DROP TABLE IF EXISTS "invites";
+DROP TABLE IF EXISTS "identities";
+DROP TABLE IF EXISTS "user_tokens";
+DROP TABLE IF EXISTS "notes";
+DROP TABLE IF EXISTS "users";
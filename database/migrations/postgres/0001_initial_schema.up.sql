@@ -0,0 +1,136 @@
+-- The schema created by AutoMigrate in earlier releases, existing databases
+-- are adopted and their users and notes get the columns added since.
+CREATE TABLE IF NOT EXISTS "users" (
+	"id" bigserial,
+	"first_name" text NOT NULL,
+	"middle_name" text,
+	"last_name" text NOT NULL,
+	"username" text,
+	"email" text UNIQUE,
+	"email_verified" boolean,
+	"dob" timestamptz NOT NULL,
+	"password" text NOT NULL,
+	"role" text NOT NULL,
+	"level" bigint NOT NULL,
+	"suspended_at" timestamptz,
+	"reset_required" boolean,
+	"deletion_due_at" timestamptz,
+	"sessions_revoked_at" timestamptz,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL,
+	"deleted_at" timestamptz,
+	PRIMARY KEY ("id")
+);
+
+-- The columns added since the first release
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "email_verified" boolean;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "suspended_at" timestamptz;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "reset_required" boolean;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "deletion_due_at" timestamptz;
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS "sessions_revoked_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_users_deletion_due_at" ON "users" ("deletion_due_at");
+
+CREATE TABLE IF NOT EXISTS "notes" (
+	"id" bigserial,
+	"title" text,
+	"content" text NOT NULL,
+	"user_id" bigint,
+	"username" text NOT NULL,
+	"org_id" bigint,
+	"archived" boolean,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz,
+	PRIMARY KEY ("id"),
+	CONSTRAINT "fk_notes_user" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE SET NULL
+);
+ALTER TABLE "notes" ADD COLUMN IF NOT EXISTS "user_id" bigint;
+ALTER TABLE "notes" ADD COLUMN IF NOT EXISTS "org_id" bigint;
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_notes_user') THEN
+		ALTER TABLE "notes" ADD CONSTRAINT "fk_notes_user"
+			FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE SET NULL;
+	END IF;
+END
+$$;
+CREATE INDEX IF NOT EXISTS "idx_notes_user_id" ON "notes" ("user_id");
+CREATE INDEX IF NOT EXISTS "idx_notes_org_id" ON "notes" ("org_id");
+
+CREATE TABLE IF NOT EXISTS "user_tokens" (
+	"id" bigserial,
+	"user_id" bigint,
+	"purpose" text NOT NULL,
+	"hash" text,
+	"expires_at" timestamptz NOT NULL,
+	"used_at" timestamptz,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "identities" (
+	"id" bigserial,
+	"user_id" bigint,
+	"issuer" text,
+	"subject" text,
+	"email" text,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_identity_subject" ON "identities" ("issuer", "subject");
+
+CREATE TABLE IF NOT EXISTS "invites" (
+	"id" bigserial,
+	"hash" text,
+	"hint" text NOT NULL,
+	"role" text NOT NULL,
+	"level" bigint NOT NULL,
+	"max_uses" bigint NOT NULL,
+	"uses" bigint NOT NULL,
+	"expires_at" timestamptz,
+	"revoked_at" timestamptz,
+	"created_by" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "organizations" (
+	"id" bigserial,
+	"name" text,
+	"display_name" text,
+	"created_at" timestamptz NOT NULL,
+	"updated_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "memberships" (
+	"id" bigserial,
+	"org_id" bigint,
+	"user_id" bigint,
+	"role" text NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_membership" ON "memberships" ("org_id", "user_id");
+
+CREATE TABLE IF NOT EXISTS "org_invites" (
+	"id" bigserial,
+	"org_id" bigint,
+	"hash" text,
+	"email" text,
+	"role" text NOT NULL,
+	"created_by" text NOT NULL,
+	"expires_at" timestamptz NOT NULL,
+	"accepted_at" timestamptz,
+	"accepted_by" text,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
+
+CREATE TABLE IF NOT EXISTS "username_redirects" (
+	"id" bigserial,
+	"username" text,
+	"user_id" bigint,
+	"expires_at" timestamptz NOT NULL,
+	"created_at" timestamptz NOT NULL,
+	PRIMARY KEY ("id")
+);
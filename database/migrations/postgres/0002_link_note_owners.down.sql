@@ -0,0 +1 @@
+-- The owners stay linked, the column is dropped with the schema.
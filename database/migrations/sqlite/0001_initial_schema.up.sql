@@ -0,0 +1,122 @@
+-- The schema created by AutoMigrate in earlier releases, existing databases
+-- are adopted and their users and notes get the columns added since.
+CREATE TABLE IF NOT EXISTS `users` (
+	`id` integer,
+	`first_name` text NOT NULL,
+	`middle_name` text,
+	`last_name` text NOT NULL,
+	`username` text,
+	`email` text UNIQUE,
+	`dob` datetime NOT NULL,
+	`password` text NOT NULL,
+	`role` text NOT NULL,
+	`level` integer NOT NULL,
+	`created_at` datetime NOT NULL,
+	`updated_at` datetime NOT NULL,
+	`deleted_at` datetime,
+	PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `notes` (
+	`id` integer,
+	`title` text,
+	`content` text NOT NULL,
+	`username` text NOT NULL,
+	`archived` numeric,
+	`created_at` datetime NOT NULL,
+	`updated_at` datetime,
+	PRIMARY KEY (`id`)
+);
+
+-- The columns added since the first release, SQLite has no ADD COLUMN IF
+-- NOT EXISTS so the tables above are created as that release left them.
+ALTER TABLE `users` ADD COLUMN `email_verified` numeric;
+ALTER TABLE `users` ADD COLUMN `suspended_at` datetime;
+ALTER TABLE `users` ADD COLUMN `reset_required` numeric;
+ALTER TABLE `users` ADD COLUMN `deletion_due_at` datetime;
+ALTER TABLE `users` ADD COLUMN `sessions_revoked_at` datetime;
+CREATE INDEX IF NOT EXISTS `idx_users_deletion_due_at` ON `users` (`deletion_due_at`);
+
+ALTER TABLE `notes` ADD COLUMN `user_id` integer REFERENCES `users` (`id`) ON DELETE SET NULL;
+ALTER TABLE `notes` ADD COLUMN `org_id` integer;
+CREATE INDEX IF NOT EXISTS `idx_notes_user_id` ON `notes` (`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_notes_org_id` ON `notes` (`org_id`);
+
+CREATE TABLE IF NOT EXISTS `user_tokens` (
+	`id` integer,
+	`user_id` integer,
+	`purpose` text NOT NULL,
+	`hash` text,
+	`expires_at` datetime NOT NULL,
+	`used_at` datetime,
+	`created_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `identities` (
+	`id` integer,
+	`user_id` integer,
+	`issuer` text,
+	`subject` text,
+	`email` text,
+	`created_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_identity_subject` ON `identities` (`issuer`, `subject`);
+
+CREATE TABLE IF NOT EXISTS `invites` (
+	`id` integer,
+	`hash` text,
+	`hint` text NOT NULL,
+	`role` text NOT NULL,
+	`level` integer NOT NULL,
+	`max_uses` integer NOT NULL,
+	`uses` integer NOT NULL,
+	`expires_at` datetime,
+	`revoked_at` datetime,
+	`created_by` text NOT NULL,
+	`created_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `organizations` (
+	`id` integer,
+	`name` text,
+	`display_name` text,
+	`created_at` datetime NOT NULL,
+	`updated_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `memberships` (
+	`id` integer,
+	`org_id` integer,
+	`user_id` integer,
+	`role` text NOT NULL,
+	`created_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_membership` ON `memberships` (`org_id`, `user_id`);
+
+CREATE TABLE IF NOT EXISTS `org_invites` (
+	`id` integer,
+	`org_id` integer,
+	`hash` text,
+	`email` text,
+	`role` text NOT NULL,
+	`created_by` text NOT NULL,
+	`expires_at` datetime NOT NULL,
+	`accepted_at` datetime,
+	`accepted_by` text,
+	`created_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
+
+CREATE TABLE IF NOT EXISTS `username_redirects` (
+	`id` integer,
+	`username` text,
+	`user_id` integer,
+	`expires_at` datetime NOT NULL,
+	`created_at` datetime NOT NULL,
+	PRIMARY KEY (`id`)
+);
@@ -0,0 +1,9 @@
+-- Link the notes created before the ownership by user id to the user of
+-- their username. A username can have changed hands: the notes written
+-- before its current user signed up were left by a former owner and stay
+-- unlinked, like the notes of the users who are gone.
+UPDATE notes SET user_id = (
+    SELECT id FROM users
+    WHERE users.username = notes.username AND users.created_at <= notes.created_at
+)
+WHERE user_id IS NULL AND org_id IS NULL AND username <> '';
@@ -0,0 +1,3 @@
+-- The first release didn't enforce unique usernames, this fails while two
+-- users share one and they have to be renamed first.
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_users_username` ON `users` (`username`);
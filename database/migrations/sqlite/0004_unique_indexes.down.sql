@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS `idx_org_invites_org_id`;
+DROP INDEX IF EXISTS `idx_username_redirects_user_id`;
+DROP INDEX IF EXISTS `idx_identities_user_id`;
+DROP INDEX IF EXISTS `idx_user_tokens_user_id`;
+DROP INDEX IF EXISTS `idx_notes_updated_at`;
+DROP INDEX IF EXISTS `idx_users_deleted_at`;
+DROP INDEX IF EXISTS `idx_username_redirects_username`;
+DROP INDEX IF EXISTS `idx_org_invites_hash`;
+DROP INDEX IF EXISTS `idx_invites_hash`;
+DROP INDEX IF EXISTS `idx_user_tokens_hash`;
+DROP INDEX IF EXISTS `idx_organizations_name`;
@@ -0,0 +1,41 @@
+-- The unique indexes declared by the models and missing from the initial
+-- schema. The duplicates left by concurrent requests are removed first:
+-- the later organizations are renamed after their id, the later tokens and
+-- invites are dropped and only the latest redirect of a username is kept.
+UPDATE `organizations` SET `name` = `name` || '-' || `id`
+WHERE `name` IS NOT NULL AND `id` NOT IN (
+	SELECT MIN(`id`) FROM `organizations` WHERE `name` IS NOT NULL GROUP BY `name`
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_organizations_name` ON `organizations` (`name`);
+
+DELETE FROM `user_tokens`
+WHERE `hash` IS NOT NULL AND `id` NOT IN (
+	SELECT MIN(`id`) FROM `user_tokens` WHERE `hash` IS NOT NULL GROUP BY `hash`
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_user_tokens_hash` ON `user_tokens` (`hash`);
+
+DELETE FROM `invites`
+WHERE `hash` IS NOT NULL AND `id` NOT IN (
+	SELECT MIN(`id`) FROM `invites` WHERE `hash` IS NOT NULL GROUP BY `hash`
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_invites_hash` ON `invites` (`hash`);
+
+DELETE FROM `org_invites`
+WHERE `hash` IS NOT NULL AND `id` NOT IN (
+	SELECT MIN(`id`) FROM `org_invites` WHERE `hash` IS NOT NULL GROUP BY `hash`
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_org_invites_hash` ON `org_invites` (`hash`);
+
+DELETE FROM `username_redirects`
+WHERE `username` IS NOT NULL AND `id` NOT IN (
+	SELECT MAX(`id`) FROM `username_redirects` WHERE `username` IS NOT NULL GROUP BY `username`
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_username_redirects_username` ON `username_redirects` (`username`);
+
+-- The lookup indexes declared by the models
+CREATE INDEX IF NOT EXISTS `idx_users_deleted_at` ON `users` (`deleted_at`);
+CREATE INDEX IF NOT EXISTS `idx_notes_updated_at` ON `notes` (`updated_at`);
+CREATE INDEX IF NOT EXISTS `idx_user_tokens_user_id` ON `user_tokens` (`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_identities_user_id` ON `identities` (`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_username_redirects_user_id` ON `username_redirects` (`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_org_invites_org_id` ON `org_invites` (`org_id`);
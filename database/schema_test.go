@@ -0,0 +1,97 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mrinjamul/gnote/models"
+	"gorm.io/gorm"
+)
+
+// schemaModels are the models stored by the repositories
+var schemaModels = []interface{}{
+	&models.User{},
+	&models.Note{},
+	&models.UsernameRedirect{},
+	&models.UserToken{},
+	&models.Identity{},
+	&models.Invite{},
+	&models.Organization{},
+	&models.Membership{},
+	&models.OrgInvite{},
+}
+
+// sqliteIndexes returns whether each index of a table is unique, by its
+// columns joined with commas
+func sqliteIndexes(t *testing.T, db *gorm.DB, table string) map[string]bool {
+	t.Helper()
+	var list []struct {
+		Name   string
+		Unique bool
+	}
+	err := db.Raw("SELECT name, \"unique\" FROM pragma_index_list(?)", table).Scan(&list).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes := make(map[string]bool, len(list))
+	for _, index := range list {
+		var columns []string
+		err := db.Raw("SELECT name FROM pragma_index_info(?) ORDER BY seqno", index.Name).Scan(&columns).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := strings.Join(columns, ",")
+		indexes[key] = indexes[key] || index.Unique
+	}
+	return indexes
+}
+
+// TestSchemaMatchesModels checks that the migrations create the indexes
+// declared by the models, the unique ones being what the repositories rely
+// on to refuse duplicates
+func TestSchemaMatchesModels(t *testing.T) {
+	db := openTestDB(t)
+	_, err := MigrateUp(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: db}
+		err := stmt.Parse(model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := stmt.Schema
+		got := sqliteIndexes(t, db, schema.Table)
+
+		want := map[string]bool{}
+		for _, index := range schema.ParseIndexes() {
+			var columns []string
+			for _, field := range index.Fields {
+				columns = append(columns, field.DBName)
+			}
+			want[strings.Join(columns, ",")] = index.Class == "UNIQUE"
+		}
+		for _, field := range schema.Fields {
+			if field.Unique {
+				want[field.DBName] = true
+			}
+		}
+
+		var keys []string
+		for key := range want {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, columns := range keys {
+			unique, ok := got[columns]
+			switch {
+			case !ok:
+				t.Errorf("%s (%s): no index, the model declares one", schema.Table, columns)
+			case want[columns] && !unique:
+				t.Errorf("%s (%s): the index isn't unique, the model declares it unique", schema.Table, columns)
+			}
+		}
+	}
+}
@@ -1 +0,0 @@
--- Schema for the app
\ No newline at end of file
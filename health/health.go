@@ -0,0 +1,161 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds the checks without their own timeout
+	DefaultTimeout = 2 * time.Second
+)
+
+// Check is a dependency check run by the readiness probe
+type Check struct {
+	// Name identifies the check in the report
+	Name string
+	// Critical checks make the server not ready when they fail, the others
+	// only degrade it
+	Critical bool
+	// Timeout bounds the check, DefaultTimeout when zero
+	Timeout time.Duration
+	// Run returns nil when the dependency is usable
+	Run func(ctx context.Context) error
+}
+
+// Result is the outcome of a check
+type Result struct {
+	OK       bool   `json:"ok"`
+	Critical bool   `json:"critical"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report holds the results of all checks by name
+type Report struct {
+	// Ready is false when a critical check failed
+	Ready bool `json:"ready"`
+	// Degraded is true when a check which is not critical failed
+	Degraded bool              `json:"degraded"`
+	Checks   map[string]Result `json:"checks"`
+}
+
+// Checker runs the checks registered by the subsystems of the server
+type Checker struct {
+	mu     sync.RWMutex
+	checks []Check
+}
+
+// NewChecker initializes a checker without checks
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Register adds a check, replacing the one with the same name
+func (c *Checker) Register(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.checks {
+		if c.checks[i].Name == check.Name {
+			c.checks[i] = check
+			return
+		}
+	}
+	c.checks = append(c.checks, check)
+	sort.Slice(c.checks, func(i, j int) bool { return c.checks[i].Name < c.checks[j].Name })
+}
+
+// Run runs all checks concurrently, each within its timeout
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := make([]Check, len(c.checks))
+	copy(checks, c.checks)
+	c.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Ready: true, Checks: make(map[string]Result, len(checks))}
+	for i, check := range checks {
+		result := results[i]
+		report.Checks[check.Name] = result
+		if result.OK {
+			continue
+		}
+		if check.Critical {
+			report.Ready = false
+		} else {
+			report.Degraded = true
+		}
+	}
+	return report
+}
+
+// run runs a check, a check ignoring its context still times out
+func run(ctx context.Context, check Check) Result {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+
+	result := Result{
+		OK:       err == nil,
+		Critical: check.Critical,
+		Duration: time.Since(start).String(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// WritableDir checks that files can be written in the directory, it is
+// created if missing
+func WritableDir(name, dir string, critical bool) Check {
+	return Check{
+		Name:     name,
+		Critical: critical,
+		Run: func(ctx context.Context) error {
+			if dir == "" {
+				return errors.New("no directory configured")
+			}
+			err := os.MkdirAll(dir, 0700)
+			if err != nil {
+				return err
+			}
+			f, err := os.CreateTemp(dir, ".gnote-health-*")
+			if err != nil {
+				return err
+			}
+			f.Close()
+			return os.Remove(f.Name())
+		},
+	}
+}
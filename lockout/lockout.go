@@ -0,0 +1,177 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/logger"
+)
+
+// Policy configures the backoff and the lockout of a tracker
+type Policy struct {
+	// Threshold is the number of failures after which a key is locked out
+	Threshold int
+	// BackoffBase is the delay after the first failure, doubled on every next one
+	BackoffBase time.Duration
+	// Lockout is the duration of a lockout, failures are forgotten after it
+	Lockout time.Duration
+}
+
+// entry holds the failures of a key
+type entry struct {
+	failures int
+	last     time.Time
+	until    time.Time
+}
+
+// Tracker tracks failed attempts by key
+type Tracker struct {
+	mu      sync.Mutex
+	policy  Policy
+	entries map[string]*entry
+	swept   time.Time
+}
+
+// RetryAfter returns how long the key has to wait before the next attempt
+func (t *Tracker) RetryAfter(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	wait := time.Until(e.until)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Fail records a failed attempt and reports whether the key got locked out
+func (t *Tracker) Fail(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.sweep(now)
+
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.last) > t.policy.Lockout {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.last = now
+
+	if e.failures >= t.policy.Threshold {
+		e.until = now.Add(t.policy.Lockout)
+		return true
+	}
+	// Exponential backoff, capped by the lockout duration
+	delay := t.policy.BackoffBase << uint(e.failures-1)
+	if delay <= 0 || delay > t.policy.Lockout {
+		delay = t.policy.Lockout
+	}
+	e.until = now.Add(delay)
+	return false
+}
+
+// Reset forgets the failures of the key
+func (t *Tracker) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+// sweep drops forgotten entries, at most once a minute
+func (t *Tracker) sweep(now time.Time) {
+	if now.Sub(t.swept) < time.Minute {
+		return
+	}
+	t.swept = now
+	for key, e := range t.entries {
+		if now.Sub(e.last) > t.policy.Lockout && now.After(e.until) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// NewTracker initializes a new tracker
+func NewTracker(policy Policy) *Tracker {
+	return &Tracker{
+		policy:  policy,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Guard protects logins by tracking failures per account and per client IP.
+// An account is keyed by its user rather than by the login sent, so that its
+// username and its email share their failures.
+type Guard struct {
+	users *Tracker
+	ips   *Tracker
+}
+
+// RetryAfter returns how long the account, when not empty, or the IP has
+// to wait
+func (g *Guard) RetryAfter(account, ip string) time.Duration {
+	wait := g.ips.RetryAfter(ip)
+	if account != "" {
+		if userWait := g.users.RetryAfter(account); userWait > wait {
+			wait = userWait
+		}
+	}
+	return wait
+}
+
+// Fail records a failed login of the account, when not empty, and of the
+// IP, lockouts are logged for admins with the request of the context
+func (g *Guard) Fail(ctx context.Context, account, ip string) {
+	if account != "" && g.users.Fail(account) {
+		logger.Ctx(ctx).Warn().
+			Str("account", account).
+			Str("client_ip", ip).
+			Msg("account locked after too many failed logins")
+	}
+	if g.ips.Fail(ip) {
+		logger.Ctx(ctx).Warn().
+			Str("client_ip", ip).
+			Msg("client locked after too many failed logins")
+	}
+}
+
+// Succeed forgets the failures of the account after a successful login
+func (g *Guard) Succeed(account string) {
+	g.users.Reset(account)
+}
+
+// Unlock lifts the lockout of an account
+func (g *Guard) Unlock(ctx context.Context, account string) {
+	g.users.Reset(account)
+	logger.Ctx(ctx).Info().Str("account", account).Msg("account unlocked")
+}
+
+// NewGuard initializes a login guard, an address is locked out after five
+// times the failures of an account unless configured
+func NewGuard(cfg config.Lockout) *Guard {
+	threshold := cfg.Threshold
+	ipThreshold := cfg.IPThreshold
+	if ipThreshold == 0 {
+		ipThreshold = 5 * threshold
+	}
+	backoff := cfg.BackoffBase
+	lockout := cfg.Duration
+	return &Guard{
+		users: NewTracker(Policy{
+			Threshold:   threshold,
+			BackoffBase: backoff,
+			Lockout:     lockout,
+		}),
+		ips: NewTracker(Policy{
+			Threshold:   ipThreshold,
+			BackoffBase: backoff,
+			Lockout:     lockout,
+		}),
+	}
+}
@@ -0,0 +1,77 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	gormlogger "gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// slowQuery is the duration from which the queries are logged as slow
+const slowQuery = 200 * time.Millisecond
+
+var (
+	// literals matches the string literals of the SQL statements, they hold
+	// the emails, the password hashes and the tokens
+	literals = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// sqliteLiterals also matches the double quoted strings of the
+	// statements logged by the SQLite driver, which quotes its identifiers
+	// with backticks
+	sqliteLiterals = regexp.MustCompile(`'(?:[^']|'')*'|"(?:[^"]|"")*"`)
+)
+
+// gormLogger logs the queries of gorm in the logger of their request
+type gormLogger struct {
+	literals *regexp.Regexp
+}
+
+// GORM returns the logger of the database queries of the driver: the failed
+// and the slow ones are logged, the others at debug level, with their string
+// literals redacted
+func GORM(driver string) gormlogger.Interface {
+	if driver == "sqlite" {
+		return gormLogger{literals: sqliteLiterals}
+	}
+	return gormLogger{literals: literals}
+}
+
+func (l gormLogger) LogMode(gormlogger.LogLevel) gormlogger.Interface {
+	return l
+}
+
+func (gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	Ctx(ctx).Info().Msgf(msg, data...)
+}
+
+func (gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	Ctx(ctx).Warn().Msgf(msg, data...)
+}
+
+func (gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	Ctx(ctx).Error().Msgf(msg, data...)
+}
+
+func (g gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	l := Ctx(ctx)
+	event := l.Debug()
+	switch {
+	case err != nil && !errors.Is(err, gormlogger.ErrRecordNotFound):
+		event = l.Error().Err(err)
+	case elapsed >= slowQuery:
+		event = l.Warn()
+	}
+	if !event.Enabled() {
+		return
+	}
+	sql, rows := fc()
+	event.
+		Str("sql", g.literals.ReplaceAllString(sql, "'"+redacted+"'")).
+		Int64("rows", rows).
+		Dur("elapsed_ms", elapsed).
+		Str("caller", utils.FileWithLineNum()).
+		Msg("query")
+}
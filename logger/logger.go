@@ -0,0 +1,158 @@
+package logger
+
+import (
+	"context"
+	"io"
+	stdlog "log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redacted replaces the secrets in the logs
+const redacted = "[redacted]"
+
+// base is the logger outside of requests, the request loggers add their
+// request id to it. It logs at info level until Init.
+var base = zerolog.New(os.Stderr).Level(zerolog.InfoLevel).With().Timestamp().Logger()
+
+// contextKey holds the logger of a request in its context
+type contextKey struct{}
+
+// requestIDKey holds the id of a request in its context
+type requestIDKey struct{}
+
+// sensitive are the parts of the names of the query parameters whose values
+// are secrets, like the tokens of the emailed links
+var sensitive = []string{"token", "password", "secret", "code", "key", "state"}
+
+// Init configures the level and the format of the logs. The standard library
+// logger writes through it, each of its lines being logged at info level.
+func Init(cfg config.Log) error {
+	level, err := zerolog.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stderr
+	if cfg.Format == "text" {
+		out = zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339}
+	}
+	base = zerolog.New(out).Level(level).With().Timestamp().Logger()
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(stdWriter{})
+	return nil
+}
+
+// Base returns the logger outside of requests
+func Base() *zerolog.Logger {
+	return &base
+}
+
+// Ctx returns the logger of the request of the context, or the base logger.
+// A gin context gives the logger of its request.
+func Ctx(ctx context.Context) *zerolog.Logger {
+	ctx = requestContext(ctx)
+	if ctx == nil {
+		return &base
+	}
+	if l, ok := ctx.Value(contextKey{}).(*zerolog.Logger); ok {
+		return l
+	}
+	return &base
+}
+
+// WithRequestID returns a copy of the context of a request carrying its id
+// and its logger, which adds the id and the trace id to each line
+func WithRequestID(ctx context.Context, id string) context.Context {
+	fields := base.With().Str("request_id", id)
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		fields = fields.Str("trace_id", span.TraceID().String())
+	}
+	l := fields.Logger()
+	ctx = context.WithValue(ctx, requestIDKey{}, id)
+	return context.WithValue(ctx, contextKey{}, &l)
+}
+
+// RequestID returns the id of the request of the context, empty outside of
+// requests. A gin context gives the id of its request.
+func RequestID(ctx context.Context) string {
+	ctx = requestContext(ctx)
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Detach returns a context carrying the id and the logger of the request of
+// the context, without its cancellation. It is meant for the work which
+// outlives the request.
+func Detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	ctx = requestContext(ctx)
+	if ctx == nil {
+		return detached
+	}
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		detached = context.WithValue(detached, requestIDKey{}, id)
+	}
+	if l, ok := ctx.Value(contextKey{}).(*zerolog.Logger); ok {
+		detached = context.WithValue(detached, contextKey{}, l)
+	}
+	return detached
+}
+
+// requestContext returns the context of the request of a gin context, nil
+// without request
+func requestContext(ctx context.Context) context.Context {
+	if c, ok := ctx.(*gin.Context); ok {
+		if c == nil || c.Request == nil {
+			return nil
+		}
+		return c.Request.Context()
+	}
+	return ctx
+}
+
+// RedactQuery returns the path and the query of the URL, the values of the
+// sensitive parameters replaced
+func RedactQuery(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	query := u.Query()
+	for name, values := range query {
+		if isSensitive(name) {
+			for i := range values {
+				values[i] = redacted
+			}
+		}
+	}
+	// the brackets of the placeholder stay readable
+	return u.Path + "?" + strings.NewReplacer("%5B", "[", "%5D", "]").Replace(query.Encode())
+}
+
+// isSensitive checks if the value of a named parameter is a secret
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitive {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// stdWriter logs the lines of the standard library logger
+type stdWriter struct{}
+
+func (stdWriter) Write(p []byte) (int, error) {
+	base.Info().Msg(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"no query", "/api/notes/1", "/api/notes/1"},
+		{"plain query", "/api/notes?page=2&q=go", "/api/notes?page=2&q=go"},
+		{"token", "/auth/verify?token=abc.def", "/auth/verify?token=[redacted]"},
+		{"names containing a secret", "/x?reset_token=a&client_secret=b&api_key=c", "/x?api_key=[redacted]&client_secret=[redacted]&reset_token=[redacted]"},
+		{"case insensitive", "/x?Password=a&ID=1", "/x?ID=1&Password=[redacted]"},
+		{"oauth callback", "/auth/sso/callback?code=xyz&state=s1", "/auth/sso/callback?code=[redacted]&state=[redacted]"},
+		{"repeated values", "/x?token=a&token=b", "/x?token=[redacted]&token=[redacted]"},
+		{"escaped values", "/x?q=a%20b&token=a%2Bb", "/x?q=a+b&token=[redacted]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := RedactQuery(u); got != tt.want {
+				t.Errorf("RedactQuery(%s) = %s, want %s", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+// captureLogs sends the logs of the test to a buffer
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := base
+	base = zerolog.New(&buf)
+	t.Cleanup(func() { base = previous })
+	return &buf
+}
+
+func TestCtx(t *testing.T) {
+	buf := captureLogs(t)
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	Ctx(ctx).Info().Msg("in the request")
+	Ctx(Detach(ctx)).Info().Msg("after the request")
+	Ctx(context.Background()).Info().Msg("outside of requests")
+
+	want := []string{"req-1", "req-1", ""}
+	dec := json.NewDecoder(buf)
+	for i, id := range want {
+		var line map[string]interface{}
+		err := dec.Decode(&line)
+		if err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		got, _ := line["request_id"].(string)
+		if got != id {
+			t.Errorf("line %d %v, want request_id %q", i, line, id)
+		}
+	}
+	if got := RequestID(Detach(ctx)); got != "req-1" {
+		t.Errorf("RequestID(Detach()) = %q, want req-1", got)
+	}
+}
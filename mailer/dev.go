@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// logMailer writes emails to the server log, meant for development
+type logMailer struct {
+	from string
+}
+
+// Send writes the message to the log
+func (m *logMailer) Send(msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// NewLogMailer initializes a log mailer
+func NewLogMailer(from string) Mailer {
+	return &logMailer{
+		from: from,
+	}
+}
+
+// fileMailer writes every email as an .eml file into a directory
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// Send writes the message into the mail directory
+func (m *fileMailer) Send(msg Message) error {
+	err := os.MkdirAll(m.dir, 0700)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return ioutil.WriteFile(filepath.Join(m.dir, name), msg.Bytes(m.from), 0600)
+}
+
+// NewFileMailer initializes a file mailer
+func NewFileMailer(dir, from string) Mailer {
+	return &fileMailer{
+		dir:  dir,
+		from: from,
+	}
+}
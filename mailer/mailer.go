@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrinjamul/gnote/config"
+)
+
+// Message is an email to be delivered
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails
+type Mailer interface {
+	// Send delivers the message
+	Send(msg Message) error
+}
+
+// Bytes returns the message formatted as a RFC 5322 plain text email
+func (msg Message) Bytes(from string) []byte {
+	var buf bytes.Buffer
+	// Header values must not contain line breaks
+	header := strings.NewReplacer("\r", "", "\n", "")
+	fmt.Fprintf(&buf, "From: %s\r\n", header.Replace(from))
+	fmt.Fprintf(&buf, "To: %s\r\n", header.Replace(msg.To))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", header.Replace(msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// NewMailer initializes the configured mailer, smtp, file or log
+func NewMailer(cfg config.Mail) Mailer {
+	switch cfg.Mailer {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort), cfg.SMTPUsername, cfg.SMTPPassword, cfg.From)
+	case "file":
+		return NewFileMailer(cfg.Dir, cfg.From)
+	}
+	return NewLogMailer(cfg.From)
+}
@@ -0,0 +1,142 @@
+// Package mailtest provides a SMTP server capturing the emails for the tests
+package mailtest
+
+import (
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Message is a captured email
+type Message struct {
+	From string
+	To   []string
+	// Data is the message as it was sent, headers and body
+	Data string
+}
+
+// Parse parses the captured email
+func (msg Message) Parse() (*mail.Message, error) {
+	return mail.ReadMessage(strings.NewReader(msg.Data))
+}
+
+// Server is a SMTP server on a local port which accepts every email
+type Server struct {
+	Host string
+	Port string
+
+	listener net.Listener
+	messages chan Message
+}
+
+// NewServer starts a capture server, it is closed with the test
+func NewServer(t *testing.T) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		Host:     host,
+		Port:     port,
+		listener: listener,
+		messages: make(chan Message, 16),
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+// Next waits for the next captured email
+func (s *Server) Next(t *testing.T) Message {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+		return Message{}
+	}
+}
+
+// Empty checks if no email is waiting
+func (s *Server) Empty() bool {
+	return len(s.messages) == 0
+}
+
+// serve accepts the connections until the server is closed
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle speaks the SMTP commands a client needs to send a message
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	text := textproto.NewConn(conn)
+	reply := func(line string) bool {
+		return text.PrintfLine("%s", line) == nil
+	}
+	if !reply("220 localhost ESMTP mailtest") {
+		return
+	}
+	var msg Message
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			msg = Message{From: address(line)}
+			reply("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, address(line))
+			reply("250 OK")
+		case "DATA":
+			if !reply("354 end data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = string(data)
+			s.messages <- msg
+			reply("250 OK")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// address returns the address between the angle brackets of a command
+func address(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.LastIndex(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
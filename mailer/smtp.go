@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+const (
+	// smtpDialTimeout is the time given to connect to the SMTP server
+	smtpDialTimeout = 10 * time.Second
+	// smtpTimeout is the time given to deliver a message once connected
+	smtpTimeout = 30 * time.Second
+)
+
+// smtpMailer delivers emails through a SMTP server
+type smtpMailer struct {
+	host string
+	addr string
+	auth smtp.Auth
+	from string
+
+	dialTimeout time.Duration
+	timeout     time.Duration
+}
+
+// Send delivers the message through the SMTP server. Unlike smtp.SendMail,
+// a server which doesn't answer fails the delivery in time.
+func (m *smtpMailer) Send(msg Message) error {
+	sender, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return errors.New("smtp: recipient contains a line break")
+	}
+	conn, err := net.DialTimeout("tcp", m.addr, m.dialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(m.timeout))
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+		err = c.Auth(m.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(sender.Address)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg.Bytes(m.from))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// NewSMTPMailer initializes a SMTP mailer. Authentication is skipped when
+// username is empty, e.g. for a local capture server.
+func NewSMTPMailer(host, port, username, password, from string) Mailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpMailer{
+		host:        host,
+		addr:        net.JoinHostPort(host, port),
+		auth:        auth,
+		from:        from,
+		dialTimeout: smtpDialTimeout,
+		timeout:     smtpTimeout,
+	}
+}
@@ -0,0 +1,103 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mrinjamul/gnote/mailer/mailtest"
+)
+
+func TestSMTPMailerSends(t *testing.T) {
+	server := mailtest.NewServer(t)
+	m := NewSMTPMailer(server.Host, server.Port, "", "", "gnote <noreply@example.com>")
+
+	err := m.Send(Message{
+		To:      "alice@example.com",
+		Subject: "Reset your gnote password",
+		Body:    "Hi alice,\n\nhttps://gnote.example.com/reset?token=abc\n",
+	})
+	if err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	captured := server.Next(t)
+	if captured.From != "noreply@example.com" {
+		t.Errorf("MAIL FROM = %q, want noreply@example.com", captured.From)
+	}
+	if len(captured.To) != 1 || captured.To[0] != "alice@example.com" {
+		t.Errorf("RCPT TO = %v, want [alice@example.com]", captured.To)
+	}
+	msg, err := captured.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"From":         "gnote <noreply@example.com>",
+		"To":           "alice@example.com",
+		"Subject":      "Reset your gnote password",
+		"Content-Type": "text/plain; charset=UTF-8",
+	} {
+		if got := msg.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hi alice,\n\nhttps://gnote.example.com/reset?token=abc\n"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestSMTPMailerRefusesHeaderInjection(t *testing.T) {
+	server := mailtest.NewServer(t)
+	m := NewSMTPMailer(server.Host, server.Port, "", "", "noreply@example.com")
+
+	err := m.Send(Message{To: "alice@example.com\r\nBcc: mallory@example.com", Subject: "hi"})
+	if err == nil {
+		t.Error("Send() to a recipient with a line break succeeded, want an error")
+	}
+	if !server.Empty() {
+		t.Error("an email was sent")
+	}
+}
+
+func TestSMTPMailerTimesOut(t *testing.T) {
+	// a server which accepts the connection but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewSMTPMailer(host, port, "", "", "noreply@example.com").(*smtpMailer)
+	m.timeout = 100 * time.Millisecond
+
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Send(Message{To: "alice@example.com", Subject: "hi"})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Send() to a silent server succeeded, want a timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send() to a silent server doesn't time out")
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// startKey holds the start time of a query in its statement
+const startKey = "metrics:start"
+
+// GORMPlugin observes the latency of the queries of a database
+type GORMPlugin struct{}
+
+// Name names the plugin for gorm
+func (GORMPlugin) Name() string {
+	return "metrics"
+}
+
+// Initialize registers the callbacks timing each operation
+func (GORMPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, op := range []struct {
+		name   string
+		before func(string, func(*gorm.DB)) error
+		after  func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	} {
+		err := op.before("metrics:before_"+op.name, start)
+		if err != nil {
+			return err
+		}
+		err = op.after("metrics:after_"+op.name, observe(op.name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start records the start time of a query
+func start(db *gorm.DB) {
+	db.InstanceSet(startKey, time.Now())
+}
+
+// observe records the latency of a query of the operation
+func observe(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(startKey)
+		if !ok {
+			return
+		}
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		DBQueryDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+	}
+}
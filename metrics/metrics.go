@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the names of the metrics
+const namespace = "gnote"
+
+// registry holds the metrics exposed by Handler
+var registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequests counts the handled requests by route and status
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of handled HTTP requests.",
+	}, []string{"method", "route", "status"})
+	// HTTPDuration observes the latency of the requests by route and status
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	// DBQueryDuration observes the latency of the queries by operation
+	DBQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of the database queries.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	// NotesCreated counts the notes created by users and organizations
+	NotesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notes_created_total",
+		Help:      "Number of notes created.",
+	})
+	// Logins counts the successful logins by method, password or sso
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Number of successful logins.",
+	}, []string{"method"})
+	// FailedLogins counts the refused logins by reason, invalid_credentials
+	// or locked_out
+	FailedLogins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failed_logins_total",
+		Help:      "Number of refused logins.",
+	}, []string{"reason"})
+)
+
+func init() {
+	registry.MustRegister(
+		HTTPRequests,
+		HTTPDuration,
+		DBQueryDuration,
+		NotesCreated,
+		Logins,
+		FailedLogins,
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// MustRegister adds the collectors of a subsystem, like the statistics of
+// the database pool. A collector already registered is ignored, the other
+// errors panic.
+func MustRegister(collectors ...prometheus.Collector) {
+	for _, c := range collectors {
+		err := registry.Register(c)
+		if _, ok := err.(prometheus.AlreadyRegisteredError); err != nil && !ok {
+			panic(err)
+		}
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+const (
+	// csrfCookie is the double-submit cookie, readable by the web client
+	csrfCookie = "csrf_token"
+	// csrfHeader echoes the double-submit cookie on state-changing requests
+	csrfHeader = "X-CSRF-Token"
+)
+
+// CSRF protects the requests authenticated by the token cookie with a
+// double-submit token: the web client copies the csrf_token cookie into the
+// X-CSRF-Token header, which a cross-site page can't do. Requests without the
+// token cookie, like the ones with a bearer token, are exempt. It also applies
+// the SameSite setting to every cookie of the request.
+func CSRF() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.SetSameSite(utils.CookieSameSite)
+
+		csrfToken, err := ctx.Cookie(csrfCookie)
+		if err != nil || csrfToken == "" {
+			b := make([]byte, 32)
+			_, err = rand.Read(b)
+			if err != nil {
+				apierror.Internal(ctx, err)
+				return
+			}
+			csrfToken = base64.RawURLEncoding.EncodeToString(b)
+			ctx.SetCookie(csrfCookie, csrfToken, 0, "/", "", utils.SecureCookies, false)
+		}
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			ctx.Next()
+			return
+		}
+		// the cookie wins over the authorization header, so any request
+		// carrying it is authenticated by cookie
+		if token, err := ctx.Cookie("token"); err != nil || token == "" {
+			ctx.Next()
+			return
+		}
+		header := ctx.GetHeader(csrfHeader)
+		if header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(csrfToken)) != 1 {
+			apierror.Forbidden(ctx, "invalid csrf token")
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/utils"
+)
+
+// configureCookies sets the cookie attributes for the test, the previous
+// ones are restored after it
+func configureCookies(t *testing.T, cfg config.Security) {
+	t.Helper()
+	sameSite, secure := utils.CookieSameSite, utils.SecureCookies
+	t.Cleanup(func() {
+		utils.CookieSameSite, utils.SecureCookies = sameSite, secure
+	})
+	utils.ConfigureCookies(cfg)
+}
+
+// csrfRouter serves every method behind the CSRF middleware, the handler
+// sets the token cookie like the sign in
+func csrfRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CSRF())
+	router.Any("/", func(ctx *gin.Context) {
+		ctx.SetCookie("token", "jwt", 0, "/", "", utils.SecureCookies, true)
+		ctx.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestCSRF(t *testing.T) {
+	configureCookies(t, config.Security{CookieSameSite: "lax"})
+	router := csrfRouter()
+
+	tests := []struct {
+		name    string
+		method  string
+		cookies map[string]string
+		header  string
+		want    int
+	}{
+		{"bearer request without cookies", http.MethodPost, nil, "", http.StatusOK},
+		{"csrf cookie without token cookie", http.MethodDelete, map[string]string{csrfCookie: "abc"}, "", http.StatusOK},
+		{"matching header", http.MethodPost, map[string]string{"token": "jwt", csrfCookie: "abc"}, "abc", http.StatusOK},
+		{"matching header on put", http.MethodPut, map[string]string{"token": "jwt", csrfCookie: "abc"}, "abc", http.StatusOK},
+		{"header not matching the cookie", http.MethodPost, map[string]string{"token": "jwt", csrfCookie: "abc"}, "abd", http.StatusForbidden},
+		{"header on delete not matching", http.MethodDelete, map[string]string{"token": "jwt", csrfCookie: "abc"}, "ab", http.StatusForbidden},
+		{"no header", http.MethodPatch, map[string]string{"token": "jwt", csrfCookie: "abc"}, "", http.StatusForbidden},
+		{"header without csrf cookie", http.MethodPost, map[string]string{"token": "jwt"}, "abc", http.StatusForbidden},
+		{"get without header", http.MethodGet, map[string]string{"token": "jwt", csrfCookie: "abc"}, "", http.StatusOK},
+		{"head without header", http.MethodHead, map[string]string{"token": "jwt", csrfCookie: "abc"}, "", http.StatusOK},
+		{"options without header", http.MethodOptions, map[string]string{"token": "jwt"}, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			for name, value := range tt.cookies {
+				req.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			if tt.header != "" {
+				req.Header.Set(csrfHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("%s = %d %s, want %d", tt.method, rec.Code, rec.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFCookies(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      config.Security
+		sameSite http.SameSite
+	}{
+		{"lax", config.Security{CookieSameSite: "lax"}, http.SameSiteLaxMode},
+		{"strict", config.Security{CookieSameSite: "strict"}, http.SameSiteStrictMode},
+		{"none", config.Security{CookieSameSite: "none", CookieSecure: true}, http.SameSiteNoneMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configureCookies(t, tt.cfg)
+			rec := httptest.NewRecorder()
+			csrfRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			cookies := make(map[string]*http.Cookie)
+			for _, cookie := range rec.Result().Cookies() {
+				cookies[cookie.Name] = cookie
+			}
+			csrf, ok := cookies[csrfCookie]
+			if !ok || len(csrf.Value) < 43 {
+				t.Fatalf("csrf cookie = %v, want a random token", csrf)
+			}
+			// the web client reads the token to echo it
+			if csrf.HttpOnly {
+				t.Error("csrf cookie is HttpOnly")
+			}
+			for _, cookie := range []*http.Cookie{csrf, cookies["token"]} {
+				if cookie == nil {
+					t.Fatal("token cookie not set")
+				}
+				if cookie.SameSite != tt.sameSite || cookie.Secure != tt.cfg.CookieSecure {
+					t.Errorf("%s cookie SameSite %v, Secure %v, want %v, %v",
+						cookie.Name, cookie.SameSite, cookie.Secure, tt.sameSite, tt.cfg.CookieSecure)
+				}
+			}
+		})
+	}
+}
+
+func TestCSRFKeepsTheToken(t *testing.T) {
+	configureCookies(t, config.Security{CookieSameSite: "lax"})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "abc"})
+	rec := httptest.NewRecorder()
+	csrfRouter().ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == csrfCookie {
+			t.Errorf("csrf cookie reissued as %q, want the token kept", cookie.Value)
+		}
+	}
+}
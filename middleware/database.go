@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/database"
+)
+
+// RequireDatabase answers 503 while the database is unreachable, in the
+// degraded mode
+func RequireDatabase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !database.Connected() {
+			c.Header("Retry-After", "30")
+			apierror.Abort(c, http.StatusServiceUnavailable, apierror.CodeUnavailable, "database is unavailable, try again later", nil)
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,145 +1,132 @@
 package middleware
 
 import (
-	"net/http"
+	"context"
+	"errors"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/auth"
 	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
 	"github.com/mrinjamul/gnote/utils"
 )
 
-var (
-	jwtKey string
-)
+// users looks up the users of the tokens, so that the revoked sessions and
+// the suspended users are refused before their token expires. The tokens are only verified by their
+// signature while it is nil.
+var users repository.UserRepo
 
-func init() {
-	jwtKey = utils.GetEnv("JWT_SECRET")
-	// if jwtKey == "" {
-	// 	panic("JWT_SECRET not set")
-	// }
+// UseUsers sets the repository the users of the tokens are looked up in
+func UseUsers(userRepo repository.UserRepo) {
+	users = userRepo
 }
 
-// JWTAuth is a middleware for validating JWT tokens
+// JWTAuth is a middleware for validating JWT tokens, it puts the verified
+// principal into the request context
 func JWTAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		// check if token is present
-		// Get cookie "token"
-		tokenString, err := ctx.Cookie("token")
-		if err != nil {
-			tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-			if err != nil {
-				ctx.JSON(http.StatusUnauthorized, gin.H{
-					"error": "no token provided",
-				})
-				ctx.Abort()
-				return
-			}
-			tokenString = tkn
-		}
-
-		claims := &models.Claims{}
-		// check if token is expired
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtKey), nil
-		})
-		if !token.Valid {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
-		}
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				ctx.JSON(http.StatusUnauthorized, gin.H{
-					"error": "invalid token",
-				})
-				ctx.Abort()
-				return
-			}
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "bad token",
-			})
-			ctx.Abort()
-			return
-		}
-
-		// check if token is expired
-		if time.Now().Unix() > claims.ExpiresAt.Unix() {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "token expired",
-			})
-			ctx.Abort()
+		claims, ok := authenticate(ctx)
+		if !ok {
 			return
 		}
+		auth.SetPrincipal(ctx, auth.NewPrincipal(claims))
 		ctx.Next()
 	}
 }
 
-// JWTAuth is a middleware for validating JWT tokens
-func JWTAuthAdmin() gin.HandlerFunc {
+// OptionalJWTAuth puts the principal of a valid token into the request
+// context, the requests without one go on anonymously
+func OptionalJWTAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		// check if token is present
-		// Get cookie "token"
 		tokenString, err := ctx.Cookie("token")
 		if err != nil {
-			tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
-			if err != nil {
-				ctx.JSON(http.StatusUnauthorized, gin.H{
-					"error": "invalid token",
-				})
-				ctx.Abort()
-				return
-			}
-			tokenString = tkn
-		}
-
-		claims := &models.Claims{}
-		// check if token is expired
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(jwtKey), nil
-		})
-		if !token.Valid {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "invalid token",
-			})
-			ctx.Abort()
-			return
+			tokenString, _ = utils.ParseToken(ctx.Request.Header.Get("Authorization"))
 		}
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				ctx.JSON(http.StatusUnauthorized, gin.H{
-					"error": "invalid token",
-				})
-				ctx.Abort()
-				return
+		if tokenString != "" {
+			claims := &models.Claims{}
+			token, err := auth.ParseToken(tokenString, claims)
+			if err == nil && token.Valid && claims.UserID != 0 &&
+				claims.ExpiresAt != nil && time.Now().Before(claims.ExpiresAt.Time) {
+				active, err := activeSession(ctx, claims)
+				if err == nil && active {
+					auth.SetPrincipal(ctx, auth.NewPrincipal(claims))
+				}
 			}
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "bad token",
-			})
-			ctx.Abort()
-			return
 		}
+		ctx.Next()
+	}
+}
 
-		// check if token is expired
-		if time.Now().Unix() > claims.ExpiresAt.Unix() {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "token expired",
-			})
-			ctx.Abort()
+// JWTAuthAdmin is a middleware for validating JWT tokens of admins
+func JWTAuthAdmin() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		claims, ok := authenticate(ctx)
+		if !ok {
 			return
 		}
-
 		// check if user is admin
 		if claims.Role != "admin" {
-			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "unauthorized",
-			})
-			ctx.Abort()
+			apierror.Unauthorized(ctx, "unauthorized")
 			return
 		}
+		auth.SetPrincipal(ctx, auth.NewPrincipal(claims))
 		ctx.Next()
 	}
 }
+
+// authenticate verifies the token of the cookie or of the authorization
+// header, it answers 401 and aborts the request when it is not valid
+func authenticate(ctx *gin.Context) (*models.Claims, bool) {
+	// Get cookie "token"
+	tokenString, err := ctx.Cookie("token")
+	if err != nil {
+		tkn, err := utils.ParseToken(ctx.Request.Header.Get("Authorization"))
+		if err != nil {
+			apierror.Unauthorized(ctx, "no token provided")
+			return nil, false
+		}
+		tokenString = tkn
+	}
+
+	claims := &models.Claims{}
+	token, err := auth.ParseToken(tokenString, claims)
+	// tokens without user id predate the ownership by id and are refused
+	if err != nil || !token.Valid || claims.UserID == 0 {
+		apierror.Unauthorized(ctx, "invalid token")
+		return nil, false
+	}
+	// check if token is expired
+	if claims.ExpiresAt == nil || time.Now().Unix() > claims.ExpiresAt.Unix() {
+		apierror.Unauthorized(ctx, "token expired")
+		return nil, false
+	}
+	active, err := activeSession(ctx, claims)
+	if err != nil {
+		apierror.Internal(ctx, err)
+		return nil, false
+	}
+	if !active {
+		apierror.Unauthorized(ctx, "invalid token")
+		return nil, false
+	}
+	return claims, true
+}
+
+// activeSession checks that the user of the token exists, isn't suspended
+// or scheduled for deletion, and didn't revoke its sessions since the token
+// was issued. /auth/restore takes the credentials instead of a token.
+func activeSession(ctx context.Context, claims *models.Claims) (bool, error) {
+	if users == nil {
+		return true, nil
+	}
+	user, err := users.GetUser(ctx, int(claims.UserID))
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !user.SuspendedAt.Valid && !user.DeletionDueAt.Valid && !auth.NewPrincipal(claims).Revoked(user), nil
+}
@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/auth"
+	"github.com/mrinjamul/gnote/config"
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/repository"
+)
+
+// withUsers looks the users of the tokens up in a memory repository for the
+// test
+func withUsers(t *testing.T) repository.UserRepo {
+	t.Helper()
+	err := auth.Init(config.Auth{JWTSecret: "middleware-test-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := repository.NewMemoryRepos().Users
+	UseUsers(userRepo)
+	t.Cleanup(func() { UseUsers(nil) })
+	return userRepo
+}
+
+// tokenIssuedAt signs a token of the user issued at the given time, zero
+// for a token without issue time
+func tokenIssuedAt(t *testing.T, user models.User, issuedAt time.Time) string {
+	t.Helper()
+	claims := &models.Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+		},
+	}
+	if !issuedAt.IsZero() {
+		claims.IssuedAt = jwt.NewNumericDate(issuedAt)
+	}
+	token, err := auth.SignToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// authStatus sends a request with the token through the middleware
+func authStatus(middleware gin.HandlerFunc, token string) int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", middleware, func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestJWTAuthRefusesRevokedSessions(t *testing.T) {
+	userRepo := withUsers(t)
+	ctx := context.Background()
+	revokedAt := time.Now().Add(-time.Hour)
+	alice := models.User{
+		Username:          "alice",
+		Email:             "alice@example.com",
+		Role:              "admin",
+		SessionsRevokedAt: sql.NullTime{Time: revokedAt, Valid: true},
+	}
+	err := userRepo.CreateUser(ctx, &alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		want     int
+	}{
+		{"issued before the revocation", revokedAt.Add(-time.Minute), http.StatusUnauthorized},
+		{"issued after the revocation", revokedAt.Add(time.Minute), http.StatusOK},
+		{"issued in the second of the revocation", revokedAt, http.StatusOK},
+		{"without issue time", time.Time{}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := tokenIssuedAt(t, alice, tt.issuedAt)
+			if got := authStatus(JWTAuth(), token); got != tt.want {
+				t.Errorf("JWTAuth() = %d, want %d", got, tt.want)
+			}
+			if got := authStatus(JWTAuthAdmin(), token); got != tt.want {
+				t.Errorf("JWTAuthAdmin() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTAuthRefusesDeletedUsers(t *testing.T) {
+	userRepo := withUsers(t)
+	ctx := context.Background()
+	alice := models.User{Username: "alice", Email: "alice@example.com", Role: "user"}
+	err := userRepo.CreateUser(ctx, &alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := tokenIssuedAt(t, alice, time.Now())
+	if got := authStatus(JWTAuth(), token); got != http.StatusOK {
+		t.Fatalf("JWTAuth() = %d, want %d", got, http.StatusOK)
+	}
+
+	err = userRepo.DeleteUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(JWTAuth(), token); got != http.StatusUnauthorized {
+		t.Errorf("JWTAuth() of a deleted user = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+func TestJWTAuthRefusesAccountsScheduledForDeletion(t *testing.T) {
+	userRepo := withUsers(t)
+	ctx := context.Background()
+	alice := models.User{Username: "alice", Email: "alice@example.com", Role: "user"}
+	err := userRepo.CreateUser(ctx, &alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := tokenIssuedAt(t, alice, time.Now())
+
+	err = userRepo.ScheduleDeletion(ctx, alice.ID, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(JWTAuth(), token); got != http.StatusUnauthorized {
+		t.Errorf("JWTAuth() of an account scheduled for deletion = %d, want %d", got, http.StatusUnauthorized)
+	}
+
+	// the session comes back with the account
+	err = userRepo.RestoreUser(ctx, alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := authStatus(JWTAuth(), token); got != http.StatusOK {
+		t.Errorf("JWTAuth() of a restored account = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestOptionalJWTAuthIgnoresRevokedSessions(t *testing.T) {
+	userRepo := withUsers(t)
+	alice := models.User{
+		Username:          "alice",
+		Email:             "alice@example.com",
+		SessionsRevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	}
+	err := userRepo.CreateUser(context.Background(), &alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/", OptionalJWTAuth(), func(ctx *gin.Context) {
+		_, ok := auth.PrincipalFrom(ctx)
+		if ok {
+			ctx.Status(http.StatusOK)
+			return
+		}
+		ctx.Status(http.StatusNoContent)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenIssuedAt(t, alice, time.Now().Add(-time.Hour)))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("OptionalJWTAuth() with a revoked token = %d, want an anonymous request", rec.Code)
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/apierror"
+	"github.com/mrinjamul/gnote/logger"
+	"github.com/rs/zerolog"
+)
+
+// RequestIDHeader carries the id of a request, from the client or generated
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDPattern matches the request ids accepted from the clients, the
+// others are replaced so that they can't forge log lines
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// quietRoutes are polled by the probes and the scrapers, they are only
+// logged at debug level
+var quietRoutes = map[string]bool{
+	"/api/health": true,
+	"/livez":      true,
+	"/readyz":     true,
+	"/metrics":    true,
+}
+
+// RequestID propagates the X-Request-ID of the request, or generates one,
+// and gives the request a logger adding it to each line. The id is sent back
+// in the header and in the JSON error responses.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+		c.Writer = &requestIDWriter{ResponseWriter: c.Writer, id: id}
+		c.Next()
+	}
+}
+
+// newRequestID generates a random request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// Logger logs each request once answered, with its query redacted. The
+// headers and the bodies, which carry the passwords and the tokens, are never
+// logged.
+func Logger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		l := logger.Ctx(c)
+		var event *zerolog.Event
+		switch {
+		case quietRoutes[c.FullPath()]:
+			event = l.Debug()
+		case status >= http.StatusInternalServerError:
+			event = l.Error()
+		case status >= http.StatusBadRequest:
+			event = l.Warn()
+		default:
+			event = l.Info()
+		}
+		if len(c.Errors) > 0 {
+			event = event.Str("errors", c.Errors.String())
+		}
+		event.
+			Str("method", c.Request.Method).
+			Str("path", logger.RedactQuery(c.Request.URL)).
+			Str("route", c.FullPath()).
+			Int("status", status).
+			Dur("latency_ms", time.Since(start)).
+			Str("client_ip", c.ClientIP()).
+			Str("user_agent", c.Request.UserAgent()).
+			Int("bytes", c.Writer.Size()).
+			Msg("request")
+	}
+}
+
+// Recovery answers a 500 error envelope when a handler panics, and logs the
+// panic with its stack
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				logger.Ctx(c).Error().
+					Interface("panic", err).
+					Str("stack", string(debug.Stack())).
+					Msg("recovered from a panic")
+				if c.Writer.Written() {
+					c.Abort()
+					return
+				}
+				apierror.Internal(c, nil)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// requestIDWriter adds the request id to the JSON objects of the error
+// responses which don't have it
+type requestIDWriter struct {
+	gin.ResponseWriter
+	id string
+	// done is set after the first write, the body is only changed then
+	done bool
+}
+
+func (w *requestIDWriter) Write(b []byte) (int, error) {
+	if w.done || w.Status() < http.StatusBadRequest ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.done = true
+		return w.ResponseWriter.Write(b)
+	}
+	w.done = true
+	body := bytes.TrimSpace(b)
+	if len(body) < 2 || body[0] != '{' || bytes.Contains(body, []byte(`"request_id":`)) {
+		return w.ResponseWriter.Write(b)
+	}
+	id, _ := json.Marshal(w.id)
+	var out bytes.Buffer
+	out.WriteString(`{"request_id":`)
+	out.Write(id)
+	if rest := bytes.TrimSpace(body[1:]); len(rest) > 0 && rest[0] != '}' {
+		out.WriteByte(',')
+	}
+	out.Write(body[1:])
+	_, err := w.ResponseWriter.Write(out.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
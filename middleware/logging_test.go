@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/logger"
+)
+
+// generatedID matches the request ids generated by the server
+var generatedID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		keep bool
+	}{
+		{"none", "", false},
+		{"uuid", "0f8e3a52-5c1d-4f7b-9a4e-2b6c8d1e7f30", true},
+		{"trace style", "web.1:req_42", true},
+		{"longest", strings.Repeat("a", 128), true},
+		{"oversized", strings.Repeat("a", 129), false},
+		{"forged log line", "abc\n{\"level\":\"error\"}", false},
+		{"spaces", "abc def", false},
+		{"quotes", `abc"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(RequestID())
+			var seen string
+			router.GET("/", func(ctx *gin.Context) {
+				seen = logger.RequestID(ctx)
+				ctx.Status(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.id != "" {
+				req.Header.Set(RequestIDHeader, tt.id)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if got != seen {
+				t.Errorf("header %q, request context %q, want the same id", got, seen)
+			}
+			if tt.keep && got != tt.id {
+				t.Errorf("request id = %q, want %q kept", got, tt.id)
+			}
+			if !tt.keep && !generatedID.MatchString(got) {
+				t.Errorf("request id = %q, want a generated one", got)
+			}
+		})
+	}
+}
+
+func TestRequestIDWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		want        string
+	}{
+		{"error envelope", http.StatusNotFound, "application/json; charset=utf-8",
+			`{"error":{"code":"not_found"}}`, `{"request_id":"req-1","error":{"code":"not_found"}}`},
+		{"empty object", http.StatusBadRequest, "application/json", `{}`, `{"request_id":"req-1"}`},
+		{"empty object with spaces", http.StatusBadRequest, "application/json", "{ }\n", `{"request_id":"req-1" }`},
+		{"request id already set", http.StatusConflict, "application/json",
+			`{"request_id":"other","error":"conflict"}`, `{"request_id":"other","error":"conflict"}`},
+		{"success", http.StatusOK, "application/json", `{"status":"success"}`, `{"status":"success"}`},
+		{"error array", http.StatusBadRequest, "application/json", `["bad"]`, `["bad"]`},
+		{"error text", http.StatusInternalServerError, "text/plain", `{"not":"json"}`, `{"not":"json"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(RequestID())
+			router.GET("/", func(ctx *gin.Context) {
+				ctx.Data(tt.status, tt.contentType, []byte(tt.body))
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, "req-1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.status || rec.Body.String() != tt.want {
+				t.Errorf("response = %d %s, want %d %s", rec.Code, rec.Body, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestIDWriterWritesOnce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Header("Content-Type", "application/json")
+		ctx.Status(http.StatusBadRequest)
+		// only the first write is the start of the object
+		_, _ = ctx.Writer.Write([]byte(`{"error":`))
+		_, _ = ctx.Writer.Write([]byte(`{"code":"x"}}`))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if want := `{"request_id":"req-1","error":{"code":"x"}}`; rec.Body.String() != want {
+		t.Errorf("body = %s, want %s", rec.Body, want)
+	}
+}
@@ -1,21 +1,114 @@
 package middleware
 
 import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
 	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/config"
+)
+
+var (
+	// corsOrigins are the origins allowed to call the API from a browser,
+	// "*" allows any origin. The same origin needs no entry.
+	corsOrigins []string
+	// corsMethods are the methods allowed in cross origin requests
+	corsMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	// corsHeaders are the headers allowed in cross origin requests
+	corsHeaders = "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With"
+	// corsCredentials allows cross origin requests to send the cookies
+	corsCredentials bool
+	// corsMaxAge is the number of seconds a preflight response is cached
+	corsMaxAge = "600"
 )
 
-// CORSMiddleware : cross origin resource sharing
+// Configure sets the CORS policy, the security headers and the proxies
+// trusted with the forwarded headers
+func Configure(cfg *config.Config) {
+	security := cfg.Security
+	corsOrigins = security.CORSAllowedOrigins
+	corsMethods = strings.ToUpper(strings.Join(security.CORSAllowedMethods, ", "))
+	corsHeaders = strings.Join(security.CORSAllowedHeaders, ", ")
+	corsCredentials = security.CORSAllowCredentials
+	corsMaxAge = strconv.Itoa(security.CORSMaxAge)
+	if security.ContentSecurityPolicy != "" {
+		contentSecurityPolicy = security.ContentSecurityPolicy
+	}
+	hstsMaxAge = security.HSTSMaxAge
+	trustedProxies = parseProxies(cfg.Server.TrustedProxies)
+}
+
+// parseProxies returns the networks of the proxy IPs and CIDRs, the invalid
+// ones are refused by the validation of the configuration
+func parseProxies(proxies []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			continue
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks
+}
+
+// CORSMiddleware : cross origin resource sharing for the allowed origins
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, GET, OPTIONS, POST, PUT")
+		origin := c.GetHeader("Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !allowedOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			// the browser hides the response from the page
+			c.Next()
+			return
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if allowedOrigin("*") {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if corsCredentials {
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if preflight {
+			c.Writer.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			c.Writer.Header().Set("Access-Control-Allow-Methods", corsMethods)
+			c.Writer.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
 		c.Next()
 	}
 }
+
+// allowedOrigin checks if the origin is in the allowlist
+func allowedOrigin(origin string) bool {
+	for _, allowed := range corsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
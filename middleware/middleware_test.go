@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/config"
+)
+
+// configure applies the configuration for the test, the previous settings
+// are restored after it
+func configure(t *testing.T, cfg config.Config) {
+	t.Helper()
+	origins, methods, headers, credentials, maxAge := corsOrigins, corsMethods, corsHeaders, corsCredentials, corsMaxAge
+	csp, hsts, proxies := contentSecurityPolicy, hstsMaxAge, trustedProxies
+	t.Cleanup(func() {
+		corsOrigins, corsMethods, corsHeaders, corsCredentials, corsMaxAge = origins, methods, headers, credentials, maxAge
+		contentSecurityPolicy, hstsMaxAge, trustedProxies = csp, hsts, proxies
+	})
+	Configure(&cfg)
+}
+
+// corsConfig allows one origin with credentials
+func corsConfig() config.Config {
+	cfg := config.Default()
+	cfg.Security.CORSAllowedOrigins = []string{"https://app.example.com"}
+	cfg.Security.CORSAllowedMethods = []string{"get", "post"}
+	cfg.Security.CORSAllowedHeaders = []string{"Content-Type", "X-CSRF-Token"}
+	cfg.Security.CORSAllowCredentials = true
+	cfg.Security.CORSMaxAge = 300
+	return cfg
+}
+
+// corsRequest sends a request from the origin through the CORS middleware,
+// a preflight when the requested method is set
+func corsRequest(method, origin, requestMethod string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORSMiddleware())
+	router.Any("/api/notes", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(method, "/api/notes", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if requestMethod != "" {
+		req.Header.Set("Access-Control-Request-Method", requestMethod)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCORSPreflight(t *testing.T) {
+	configure(t, corsConfig())
+
+	allowed := corsRequest(http.MethodOptions, "https://APP.example.com", http.MethodPost)
+	if allowed.Code != http.StatusNoContent {
+		t.Fatalf("allowed preflight = %d, want %d", allowed.Code, http.StatusNoContent)
+	}
+	for name, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://APP.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, POST",
+		"Access-Control-Allow-Headers":     "Content-Type, X-CSRF-Token",
+		"Access-Control-Max-Age":           "300",
+		"Vary":                             "Origin",
+	} {
+		if got := allowed.Header().Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	denied := corsRequest(http.MethodOptions, "https://evil.example.com", http.MethodPost)
+	if denied.Code != http.StatusForbidden {
+		t.Errorf("denied preflight = %d, want %d", denied.Code, http.StatusForbidden)
+	}
+	if got := denied.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("denied preflight allows the origin %q", got)
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		origin      string
+		wantOrigin  string
+		wantCreds   string
+	}{
+		{"allowed origin", []string{"https://app.example.com"}, true, "https://app.example.com", "https://app.example.com", "true"},
+		{"other origin", []string{"https://app.example.com"}, true, "https://evil.example.com", "", ""},
+		{"same origin", []string{"https://app.example.com"}, true, "", "", ""},
+		{"no origin allowed", nil, false, "https://app.example.com", "", ""},
+		{"any origin", []string{"*"}, false, "https://evil.example.com", "*", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := corsConfig()
+			cfg.Security.CORSAllowedOrigins = tt.origins
+			cfg.Security.CORSAllowCredentials = tt.credentials
+			configure(t, cfg)
+
+			// the handler answers, the browser hides the denied responses
+			rec := corsRequest(http.MethodGet, tt.origin, "")
+			if rec.Code != http.StatusOK {
+				t.Errorf("GET = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCreds)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "" {
+				t.Errorf("Access-Control-Allow-Methods = %q outside a preflight", got)
+			}
+		})
+	}
+}
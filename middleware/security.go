@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// contentSecurityPolicy allows the assets of the views, served by the
+	// server and the CDN, and forbids framing the pages
+	contentSecurityPolicy = "default-src 'self'; " +
+		"script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+		"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; " +
+		"img-src 'self' data:; " +
+		"connect-src 'self'; " +
+		"object-src 'none'; " +
+		"base-uri 'self'; " +
+		"form-action 'self'; " +
+		"frame-ancestors 'none'"
+	// hstsMaxAge is the number of seconds browsers stick to HTTPS, 0 disables HSTS
+	hstsMaxAge = 31536000
+	// trustedProxies are the networks of the reverse proxies whose
+	// X-Forwarded-Proto tells the scheme of the client, none by default
+	trustedProxies []*net.IPNet
+)
+
+// SecurityHeaders sets the security headers of the views and the API
+func SecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("Content-Security-Policy", contentSecurityPolicy)
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		// frame-ancestors for the browsers without CSP support
+		header.Set("X-Frame-Options", "DENY")
+		// HSTS is only honored over HTTPS
+		if hstsMaxAge > 0 && https(c) {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(hstsMaxAge)+"; includeSubDomains")
+		}
+		c.Next()
+	}
+}
+
+// https checks if the client uses HTTPS, directly or through a trusted proxy
+func https(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return fromTrustedProxy(c) && c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// fromTrustedProxy checks if the request comes from a trusted proxy, any
+// client could send the forwarded headers otherwise
+func fromTrustedProxy(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mrinjamul/gnote/config"
+)
+
+// securityHeaders sends the request through the security headers
+func securityHeaders(req *http.Request) http.Header {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(SecurityHeaders())
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Header()
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	cfg := config.Default()
+	cfg.Security.ContentSecurityPolicy = "default-src 'none'"
+	configure(t, cfg)
+
+	header := securityHeaders(httptest.NewRequest(http.MethodGet, "/", nil))
+	for name, want := range map[string]string{
+		"Content-Security-Policy":   "default-src 'none'",
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"X-Frame-Options":           "DENY",
+		"Strict-Transport-Security": "",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	const hsts = "max-age=31536000; includeSubDomains"
+	tests := []struct {
+		name       string
+		proxies    []string
+		maxAge     int
+		remoteAddr string
+		tls        bool
+		proto      string
+		want       string
+	}{
+		{"plain HTTP", nil, 31536000, "203.0.113.7:4000", false, "", ""},
+		{"TLS", nil, 31536000, "203.0.113.7:4000", true, "", hsts},
+		{"forwarded by an untrusted client", nil, 31536000, "203.0.113.7:4000", false, "https", ""},
+		{"forwarded by a trusted proxy", []string{"10.0.0.0/8"}, 31536000, "10.1.2.3:4000", false, "https", hsts},
+		{"forwarded by a trusted proxy IP", []string{"10.1.2.3"}, 31536000, "10.1.2.3:4000", false, "https", hsts},
+		{"forwarded by an IPv6 proxy", []string{"fd00::/8"}, 31536000, "[fd00::1]:4000", false, "https", hsts},
+		{"forwarded by another client", []string{"10.1.2.3"}, 31536000, "10.1.2.4:4000", false, "https", ""},
+		{"forwarded HTTP by a trusted proxy", []string{"10.0.0.0/8"}, 31536000, "10.1.2.3:4000", false, "http", ""},
+		{"disabled", []string{"10.0.0.0/8"}, 0, "10.1.2.3:4000", true, "https", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Server.TrustedProxies = tt.proxies
+			cfg.Security.HSTSMaxAge = tt.maxAge
+			configure(t, cfg)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if got := securityHeaders(req).Get("Strict-Transport-Security"); got != tt.want {
+				t.Errorf("Strict-Transport-Security = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -12,27 +12,149 @@ type Note struct {
 	ID        uint64    `json:"id" gorm:"primary_key,autoIncrement,not null"`
 	Title     string    `json:"title,omitempty"`
 	Content   string    `json:"content" gorm:"not null"`
+	UserID    *uint     `json:"user_id,omitempty" gorm:"index"`
+	User      *User     `json:"-" gorm:"constraint:OnDelete:SET NULL"`
 	Username  string    `json:"username" gorm:"not null"`
+	OrgID     *uint     `json:"org_id,omitempty" gorm:"index"`
 	Archived  bool      `json:"archived,omitempty"`
 	CreatedAt time.Time `json:"created_at" gorm:"not null"`
-	UpdatedAt time.Time `json:"updated_at" gorm:"index,not null"`
+	UpdatedAt time.Time `json:"updated_at" gorm:"index;not null"`
 }
 
 // User is a user of the application
 type User struct {
+	ID                uint         `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	FirstName         string       `json:"first_name" gorm:"not null"`
+	MiddleName        string       `json:"middle_name,omitempty"`
+	LastName          string       `json:"last_name" gorm:"not null"`
+	Username          string       `json:"username"  gorm:"unique;not null"`
+	Email             string       `json:"email" gorm:"unique"`
+	EmailVerified     bool         `json:"email_verified"`
+	DOB               time.Time    `json:"dob" gorm:"not null"`
+	Password          string       `json:"password" gorm:"not null"`
+	Role              string       `json:"role" gorm:"not null"`
+	Level             int          `json:"level" gorm:"not null"`
+	SuspendedAt       sql.NullTime `json:"suspended_at"`
+	ResetRequired     bool         `json:"reset_required"`
+	DeletionDueAt     sql.NullTime `json:"deletion_due_at" gorm:"index"`
+	SessionsRevokedAt sql.NullTime `json:"sessions_revoked_at"`
+	CreatedAt         time.Time    `json:"created_at" gorm:"not null"`
+	UpdatedAt         time.Time    `json:"updated_at" gorm:"not null"`
+	DeletedAt         sql.NullTime `json:"deleted_at" gorm:"index;not null"`
+}
+
+const (
+	// TokenPurposeReset is the purpose of password reset tokens
+	TokenPurposeReset = "reset"
+	// TokenPurposeVerify is the purpose of email verification tokens
+	TokenPurposeVerify = "verify"
+)
+
+// UsernameRedirect keeps the old username of a renamed user pointing to it
+type UsernameRedirect struct {
+	ID        uint      `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	Username  string    `json:"username" gorm:"uniqueIndex;not null"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+// UserToken is a single-use token issued to a user by email
+type UserToken struct {
+	ID        uint         `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	UserID    uint         `json:"user_id" gorm:"index;not null"`
+	Purpose   string       `json:"purpose" gorm:"not null"`
+	Hash      string       `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time    `json:"expires_at" gorm:"not null"`
+	UsedAt    sql.NullTime `json:"used_at"`
+	CreatedAt time.Time    `json:"created_at" gorm:"not null"`
+}
+
+// Usage is the storage used by a user
+type Usage struct {
+	Username string `json:"username,omitempty"`
+	Notes    int64  `json:"notes"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Identity links a user to an account of an external OpenID Connect provider
+type Identity struct {
+	ID        uint      `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	UserID    uint      `json:"user_id" gorm:"index;not null"`
+	Issuer    string    `json:"issuer" gorm:"uniqueIndex:idx_identity_subject;not null"`
+	Subject   string    `json:"subject" gorm:"uniqueIndex:idx_identity_subject;not null"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+// Invite is an invite code allowing to sign up, only its hash is stored
+type Invite struct {
+	ID        uint         `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	Hash      string       `json:"-" gorm:"uniqueIndex;not null"`
+	Hint      string       `json:"hint" gorm:"not null"`
+	Role      string       `json:"role" gorm:"not null"`
+	Level     int          `json:"level" gorm:"not null"`
+	MaxUses   int          `json:"max_uses" gorm:"not null"`
+	Uses      int          `json:"uses" gorm:"not null"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
+	RevokedAt sql.NullTime `json:"revoked_at"`
+	CreatedBy string       `json:"created_by" gorm:"not null"`
+	CreatedAt time.Time    `json:"created_at" gorm:"not null"`
+}
+
+const (
+	// OrgRoleOwner manages the organization and its members
+	OrgRoleOwner = "owner"
+	// OrgRoleEditor reads and writes the notes of the organization
+	OrgRoleEditor = "editor"
+	// OrgRoleViewer reads the notes of the organization
+	OrgRoleViewer = "viewer"
+)
+
+// Organization is a team workspace sharing notes
+type Organization struct {
+	ID          uint      `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	Name        string    `json:"name" gorm:"uniqueIndex;not null"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at" gorm:"not null"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"not null"`
+}
+
+// Membership gives a user a role in an organization
+type Membership struct {
+	ID        uint      `json:"id" gorm:"primary_key,autoIncrement,not null"`
+	OrgID     uint      `json:"org_id" gorm:"uniqueIndex:idx_membership;not null"`
+	UserID    uint      `json:"user_id" gorm:"uniqueIndex:idx_membership;not null"`
+	Role      string    `json:"role" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"not null"`
+}
+
+// Member is a member of an organization as listed to other members
+type Member struct {
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Workspace is an organization as listed to one of its members
+type Workspace struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Role        string `json:"role"`
+}
+
+// OrgInvite invites to join an organization, only its hash is stored
+type OrgInvite struct {
 	ID         uint         `json:"id" gorm:"primary_key,autoIncrement,not null"`
-	FirstName  string       `json:"first_name" gorm:"not null"`
-	MiddleName string       `json:"middle_name,omitempty"`
-	LastName   string       `json:"last_name" gorm:"not null"`
-	Username   string       `json:"username"  gorm:"unique,not null"`
-	Email      string       `json:"email" gorm:"unique"`
-	DOB        time.Time    `json:"dob" gorm:"not null"`
-	Password   string       `json:"password" gorm:"not null"`
+	OrgID      uint         `json:"org_id" gorm:"index;not null"`
+	Hash       string       `json:"-" gorm:"uniqueIndex;not null"`
+	Email      string       `json:"email"`
 	Role       string       `json:"role" gorm:"not null"`
-	Level      int          `json:"level" gorm:"not null"`
+	CreatedBy  string       `json:"created_by" gorm:"not null"`
+	ExpiresAt  time.Time    `json:"expires_at" gorm:"not null"`
+	AcceptedAt sql.NullTime `json:"accepted_at"`
+	AcceptedBy string       `json:"accepted_by"`
 	CreatedAt  time.Time    `json:"created_at" gorm:"not null"`
-	UpdatedAt  time.Time    `json:"updated_at" gorm:"not null"`
-	DeletedAt  sql.NullTime `json:"deleted_at" gorm:"index,not null"`
 }
 
 // Create a struct that models the structure of a user in the request body
@@ -44,6 +166,7 @@ type Credentials struct {
 
 // Claims
 type Claims struct {
+	UserID   uint   `json:"user_id,omitempty"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
 	Level    int    `json:"level"`
@@ -55,4 +178,5 @@ type Config struct {
 	Username string `json:"username,omitempty"`
 	Token    string `json:"token,omitempty"`
 	APIToken string `json:"api_token,omitempty"`
+	Org      string `json:"org,omitempty"`
 }
@@ -0,0 +1,377 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/config"
+)
+
+var (
+	// ErrAuthorizationPending is returned while the user hasn't approved the device yet
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	// ErrSlowDown is returned when the device polls too often
+	ErrSlowDown = errors.New("slow_down")
+)
+
+// Discovery is the OpenID provider metadata
+type Discovery struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// Tokens is the response of the token endpoint
+type Tokens struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// DeviceAuth is the response of the device authorization endpoint
+type DeviceAuth struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// Claims are the claims of an ID token
+type Claims struct {
+	Email             string      `json:"email"`
+	EmailVerified     interface{} `json:"email_verified"`
+	Name              string      `json:"name"`
+	GivenName         string      `json:"given_name"`
+	FamilyName        string      `json:"family_name"`
+	PreferredUsername string      `json:"preferred_username"`
+	Nonce             string      `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// IsEmailVerified reports whether the provider verified the email, some
+// providers send the claim as a string
+func (c *Claims) IsEmailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// Provider is an OpenID Connect provider
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      map[string]interface{}
+	fetched   time.Time
+}
+
+// NewProvider initializes a provider, the metadata is discovered on first use
+func NewProvider(issuer, clientID, clientSecret string, scopes []string) *Provider {
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Discover fetches and caches the provider metadata
+func (p *Provider) Discover() (*Discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d Discovery
+	err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: %q", d.Issuer)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// AuthCodeURL returns the authorization URL of the code flow with PKCE
+func (p *Provider) AuthCodeURL(redirectURI, state, nonce, verifier string) (string, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange exchanges an authorization code for tokens
+func (p *Provider) Exchange(code, redirectURI, verifier string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("code_verifier", verifier)
+	return p.token(form)
+}
+
+// DeviceAuthorize starts the device authorization flow
+func (p *Provider) DeviceAuthorize() (*DeviceAuth, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	if d.DeviceAuthorizationEndpoint == "" {
+		return nil, errors.New("provider doesn't support the device flow")
+	}
+	form := url.Values{}
+	form.Set("client_id", p.ClientID)
+	form.Set("scope", strings.Join(p.Scopes, " "))
+	req, err := http.NewRequest("POST", d.DeviceAuthorizationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	var auth DeviceAuth
+	err = p.doJSON(req, &auth)
+	if err != nil {
+		return nil, err
+	}
+	if auth.DeviceCode == "" {
+		return nil, errors.New("no device code received")
+	}
+	if auth.Interval == 0 {
+		auth.Interval = 5
+	}
+	return &auth, nil
+}
+
+// DeviceToken polls the token endpoint for a device code
+func (p *Provider) DeviceToken(deviceCode string) (*Tokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	form.Set("device_code", deviceCode)
+	return p.token(form)
+}
+
+// Verify verifies an ID token, nonce is only checked when not empty
+func (p *Provider) Verify(rawIDToken, nonce string) (*Claims, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	claims := &Claims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, p.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}))
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyIssuer(d.Issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("invalid audience")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("missing expiry")
+	}
+	if nonce != "" && claims.Nonce != nonce {
+		return nil, errors.New("invalid nonce")
+	}
+	return claims, nil
+}
+
+// token calls the token endpoint
+func (p *Provider) token(form url.Values) (*Tokens, error) {
+	d, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	form.Set("client_id", p.ClientID)
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tokens Tokens
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	switch tokens.Error {
+	case "":
+	case "authorization_pending":
+		return nil, ErrAuthorizationPending
+	case "slow_down":
+		return nil, ErrSlowDown
+	default:
+		return nil, fmt.Errorf("token endpoint: %s %s", tokens.Error, tokens.Description)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("no id token received")
+	}
+	return &tokens, nil
+}
+
+// keyFunc looks up the provider key of a token, the keys are fetched again
+// when the key id is unknown, at most once a minute
+func (p *Provider) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key, ok := p.lookupKey(kid)
+	if !ok && time.Since(p.fetched) > time.Minute {
+		err := p.fetchKeys()
+		if err != nil {
+			return nil, err
+		}
+		key, ok = p.lookupKey(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+// lookupKey returns the key by id, or the only key when the id is empty
+func (p *Provider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetchKeys fetches the provider keys, the caller holds the lock
+func (p *Provider) fetchKeys() error {
+	if p.discovery == nil {
+		return errors.New("provider is not discovered")
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := p.getJSON(p.discovery.JWKSURI, &set)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	p.keys = keys
+	p.fetched = time.Now()
+	return nil
+}
+
+// getJSON fetches a JSON document
+func (p *Provider) getJSON(u string, v interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	return p.doJSON(req, v)
+}
+
+// doJSON sends a request and decodes the JSON response
+func (p *Provider) doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", req.URL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// RandomString returns a random URL safe string, used for state, nonce and
+// PKCE verifiers
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge of a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewProviderFromConfig initializes the configured provider. It returns nil
+// when single sign-on is not configured.
+func NewProviderFromConfig(cfg config.OIDC) *Provider {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil
+	}
+	return NewProvider(cfg.Issuer, cfg.ClientID, cfg.ClientSecret, cfg.Scopes)
+}
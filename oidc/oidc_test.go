@@ -0,0 +1,183 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/oidc"
+	"github.com/mrinjamul/gnote/oidc/oidctest"
+)
+
+const clientID = "gnote"
+
+func TestVerify(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, clientID)
+	provider := issuer.Provider()
+	past := jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name   string
+		claims oidc.Claims
+		nonce  string
+		valid  bool
+	}{
+		{name: "valid", claims: oidc.Claims{Nonce: "n"}, nonce: "n", valid: true},
+		{name: "nonce not checked", claims: oidc.Claims{Nonce: "n"}, valid: true},
+		{name: "other nonce", claims: oidc.Claims{Nonce: "other"}, nonce: "n"},
+		{name: "missing nonce", nonce: "n"},
+		{name: "other issuer", claims: oidc.Claims{RegisteredClaims: jwt.RegisteredClaims{Issuer: "https://evil.example.com"}}},
+		{name: "other audience", claims: oidc.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"other"}}}},
+		{name: "expired", claims: oidc.Claims{RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: past}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims.Subject = "alice"
+			claims, err := provider.Verify(issuer.IDToken(tt.claims), tt.nonce)
+			if tt.valid && (err != nil || claims.Subject != "alice") {
+				t.Errorf("Verify() = %v, %v, want the claims of alice", claims, err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Verify() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsUnsignedTokens(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, clientID)
+	provider := issuer.Provider()
+	claims := jwt.RegisteredClaims{
+		Issuer:    issuer.URL,
+		Audience:  jwt.ClaimStrings{clientID},
+		Subject:   "alice",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+
+	// the expiry is required
+	_, err := provider.Verify(issuer.Sign(jwt.RegisteredClaims{
+		Issuer:   issuer.URL,
+		Audience: jwt.ClaimStrings{clientID},
+		Subject:  "alice",
+	}), "")
+	if err == nil {
+		t.Error("Verify(no expiry) succeeded, want an error")
+	}
+
+	// a key of someone else with the key id of the issuer
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	forged.Header["kid"] = oidctest.KeyID
+	signed, err := forged.SignedString(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Verify(signed, "")
+	if err == nil {
+		t.Error("Verify(other key) succeeded, want an error")
+	}
+
+	// symmetric and unsigned tokens
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Verify(signed, "")
+	if err == nil {
+		t.Error("Verify(HS256) succeeded, want an error")
+	}
+	signed, err = jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Verify(signed, "")
+	if err == nil {
+		t.Error("Verify(none) succeeded, want an error")
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, clientID)
+	provider := issuer.Provider()
+	redirect := "https://gnote.example.com/auth/oidc/callback"
+
+	authURL, err := provider.AuthCodeURL(redirect, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Query().Get("code_challenge"); got != oidc.Challenge("verifier") {
+		t.Errorf("code_challenge = %q, want the S256 challenge of the verifier", got)
+	}
+	if got := u.Query().Get("state"); got != "state" {
+		t.Errorf("state = %q, want state", got)
+	}
+
+	code := issuer.Authorize(authURL, oidc.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}})
+	_, err = provider.Exchange(code, redirect, "other verifier")
+	if err == nil {
+		t.Error("Exchange(other verifier) succeeded, want an error")
+	}
+
+	code = issuer.Authorize(authURL, oidc.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}})
+	tokens, err := provider.Exchange(code, redirect, "verifier")
+	if err != nil {
+		t.Fatalf("Exchange() = %v", err)
+	}
+	claims, err := provider.Verify(tokens.IDToken, "nonce")
+	if err != nil || claims.Subject != "alice" {
+		t.Errorf("Verify() = %v, %v, want the claims of alice", claims, err)
+	}
+	// the code is used up
+	_, err = provider.Exchange(code, redirect, "verifier")
+	if err == nil {
+		t.Error("Exchange(used code) succeeded, want an error")
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	issuer := oidctest.NewIssuer(t, clientID)
+	provider := issuer.Provider()
+
+	device, err := provider.DeviceAuthorize()
+	if err != nil {
+		t.Fatalf("DeviceAuthorize() = %v", err)
+	}
+	_, err = provider.DeviceToken(device.DeviceCode)
+	if !errors.Is(err, oidc.ErrAuthorizationPending) {
+		t.Errorf("DeviceToken(pending) = %v, want ErrAuthorizationPending", err)
+	}
+
+	issuer.Approve(device.DeviceCode, oidc.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}})
+	tokens, err := provider.DeviceToken(device.DeviceCode)
+	if err != nil {
+		t.Fatalf("DeviceToken(approved) = %v", err)
+	}
+	claims, err := provider.Verify(tokens.IDToken, "")
+	if err != nil || claims.Subject != "alice" {
+		t.Errorf("Verify() = %v, %v, want the claims of alice", claims, err)
+	}
+	_, err = provider.DeviceToken("unknown")
+	if err == nil || errors.Is(err, oidc.ErrAuthorizationPending) {
+		t.Errorf("DeviceToken(unknown) = %v, want a failure", err)
+	}
+}
+
+func TestIsEmailVerified(t *testing.T) {
+	for value, want := range map[interface{}]bool{true: true, "true": true, false: false, "false": false, nil: false} {
+		claims := oidc.Claims{EmailVerified: value}
+		if got := claims.IsEmailVerified(); got != want {
+			t.Errorf("IsEmailVerified(%v) = %v, want %v", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package oidctest provides an OpenID provider for the tests of the single
+// sign-on
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/mrinjamul/gnote/oidc"
+)
+
+// KeyID is the key id of the issuer signing key
+const KeyID = "test"
+
+// deviceGrant is the grant type of the device flow
+const deviceGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Issuer is an OpenID provider served on a local test server. The user's
+// approval at the provider is simulated with Authorize and Approve.
+type Issuer struct {
+	*httptest.Server
+	ClientID string
+	// Key signs the ID tokens, its public key is served as JWKS
+	Key *rsa.PrivateKey
+
+	t       *testing.T
+	mu      sync.Mutex
+	codes   map[string]authorization
+	devices map[string]*oidc.Claims
+	next    int
+}
+
+// authorization is an authorization code waiting to be exchanged
+type authorization struct {
+	redirectURI string
+	challenge   string
+	claims      oidc.Claims
+}
+
+// NewIssuer starts an issuer for the client, it is closed with the test
+func NewIssuer(t *testing.T, clientID string) *Issuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &Issuer{
+		ClientID: clientID,
+		Key:      key,
+		t:        t,
+		codes:    make(map[string]authorization),
+		devices:  make(map[string]*oidc.Claims),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("/jwks", i.jwks)
+	mux.HandleFunc("/token", i.token)
+	mux.HandleFunc("/device", i.device)
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+// Provider returns a provider of the issuer for its client
+func (i *Issuer) Provider() *oidc.Provider {
+	return oidc.NewProvider(i.URL, i.ClientID, "secret", nil)
+}
+
+// Sign signs the claims as they are with the issuer key
+func (i *Issuer) Sign(claims jwt.Claims) string {
+	i.t.Helper()
+	signed, err := i.sign(claims)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	return signed
+}
+
+// IDToken signs an ID token, the issuer, the audience and the times are
+// filled in when empty
+func (i *Issuer) IDToken(claims oidc.Claims) string {
+	i.t.Helper()
+	signed, err := i.idToken(claims)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	return signed
+}
+
+// idToken fills in the claims of IDToken and signs them, it is also used by
+// the server which can't fail the test
+func (i *Issuer) idToken(claims oidc.Claims) (string, error) {
+	now := time.Now()
+	if claims.Issuer == "" {
+		claims.Issuer = i.URL
+	}
+	if claims.Audience == nil {
+		claims.Audience = jwt.ClaimStrings{i.ClientID}
+	}
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if claims.ExpiresAt == nil {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(5 * time.Minute))
+	}
+	return i.sign(&claims)
+}
+
+// sign signs the claims with the issuer key
+func (i *Issuer) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = KeyID
+	return token.SignedString(i.Key)
+}
+
+// Authorize simulates the user signing in at the authorization URL and
+// returns the authorization code. The nonce of the request is used unless
+// the claims set one.
+func (i *Issuer) Authorize(authURL string, claims oidc.Claims) string {
+	i.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		i.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" {
+		i.t.Fatalf("authorization request %s is not for the code flow of %s", authURL, i.ClientID)
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		i.t.Fatalf("authorization request %s has no S256 challenge", authURL)
+	}
+	if claims.Nonce == "" {
+		claims.Nonce = q.Get("nonce")
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.next++
+	code := "code-" + strconv.Itoa(i.next)
+	i.codes[code] = authorization{
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+		claims:      claims,
+	}
+	return code
+}
+
+// Approve simulates the user approving the device
+func (i *Issuer) Approve(deviceCode string, claims oidc.Claims) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.devices[deviceCode] = &claims
+}
+
+// discovery serves the provider metadata
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, oidc.Discovery{
+		Issuer:                      i.URL,
+		AuthorizationEndpoint:       i.URL + "/authorize",
+		TokenEndpoint:               i.URL + "/token",
+		JWKSURI:                     i.URL + "/jwks",
+		DeviceAuthorizationEndpoint: i.URL + "/device",
+	})
+}
+
+// jwks serves the public key of the issuer
+func (i *Issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	encode := base64.RawURLEncoding.EncodeToString
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(i.Key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(i.Key.E)).Bytes()),
+		}},
+	})
+}
+
+// device starts the device flow, the device stays pending until approved
+func (i *Issuer) device(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.next++
+	code := "device-" + strconv.Itoa(i.next)
+	i.devices[code] = nil
+	writeJSON(w, http.StatusOK, oidc.DeviceAuth{
+		DeviceCode:      code,
+		UserCode:        "USER-" + strconv.Itoa(i.next),
+		VerificationURI: i.URL + "/activate",
+		ExpiresIn:       600,
+		Interval:        1,
+	})
+}
+
+// token exchanges the authorization codes and the approved device codes,
+// each of them once
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, _, ok := r.BasicAuth()
+	if !ok || clientID != i.ClientID {
+		tokenError(w, "invalid_client")
+		return
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var claims oidc.Claims
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		auth, ok := i.codes[code]
+		delete(i.codes, code)
+		if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") ||
+			auth.challenge != oidc.Challenge(r.PostForm.Get("code_verifier")) {
+			tokenError(w, "invalid_grant")
+			return
+		}
+		claims = auth.claims
+	case deviceGrant:
+		code := r.PostForm.Get("device_code")
+		approved, ok := i.devices[code]
+		if !ok {
+			tokenError(w, "invalid_grant")
+			return
+		}
+		if approved == nil {
+			tokenError(w, "authorization_pending")
+			return
+		}
+		delete(i.devices, code)
+		claims = *approved
+	default:
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	idToken, err := i.idToken(claims)
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, oidc.Tokens{
+		AccessToken: "access",
+		TokenType:   "Bearer",
+		IDToken:     idToken,
+		ExpiresIn:   300,
+	})
+}
+
+// tokenError answers an error of the token endpoint
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+// writeJSON writes the value as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// pgUniqueViolation is the SQLSTATE of the unique violations
+	pgUniqueViolation = "23505"
+	// sqliteConstraintPrimaryKey and sqliteConstraintUnique are the result
+	// codes of the unique violations
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+var (
+	// ErrNotFound is returned when a record doesn't exist, it is the error
+	// of gorm so that the lookups can return theirs as is
+	ErrNotFound = gorm.ErrRecordNotFound
+	// ErrConflict is returned when a record clashes with an existing one
+	ErrConflict = errors.New("conflict")
+	// ErrValidation is returned when a record is invalid
+	ErrValidation = errors.New("validation failed")
+)
+
+// Error is an error of a kind, ErrNotFound, ErrConflict or ErrValidation,
+// its message can be shown to the users
+type Error struct {
+	Kind    error
+	Message string
+	// Field is the invalid field of the validation errors
+	Field string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind so that errors.Is matches it
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// notFound returns an ErrNotFound error with a message
+func notFound(message string) error {
+	return &Error{Kind: ErrNotFound, Message: message}
+}
+
+// conflict returns an ErrConflict error with a message
+func conflict(message string) error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
+
+// invalid returns an ErrValidation error of a field
+func invalid(field, message string) error {
+	return &Error{Kind: ErrValidation, Message: message, Field: field}
+}
+
+// duplicate returns an ErrConflict error with a message when err violates a
+// unique constraint, which happens when a concurrent request stores the same
+// record between the check and the insert. The other errors are returned as
+// they are.
+func duplicate(err error, message string) error {
+	// the error of the postgres driver tells its SQLSTATE
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) && pgErr.SQLState() == pgUniqueViolation {
+		return conflict(message)
+	}
+	// and the one of sqlite its extended result code
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) &&
+		(sqliteErr.Code() == sqliteConstraintUnique || sqliteErr.Code() == sqliteConstraintPrimaryKey) {
+		return conflict(message)
+	}
+	return err
+}
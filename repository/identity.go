@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"github.com/mrinjamul/gnote/models"
+	"gorm.io/gorm"
+)
+
+// IdentityRepo is a repository for external identities
+type IdentityRepo interface {
+	// CreateIdentity links a new external identity to a user
+	CreateIdentity(ctx context.Context, identity *models.Identity) error
+	// GetIdentity returns an identity by issuer and subject
+	GetIdentity(ctx context.Context, issuer, subject string) (models.Identity, error)
+}
+
+// identityRepo is a repository for external identities
+type identityRepo struct {
+	db gorm.DB
+}
+
+// CreateIdentity links a new external identity to a user
+func (i *identityRepo) CreateIdentity(ctx context.Context, identity *models.Identity) error {
+	err := i.db.WithContext(ctx).Create(identity).Error
+	if err != nil {
+		return duplicate(err, "identity already exists")
+	}
+	return nil
+}
+
+// GetIdentity returns an identity by issuer and subject
+func (i *identityRepo) GetIdentity(ctx context.Context, issuer, subject string) (models.Identity, error) {
+	var identity models.Identity
+	err := i.db.WithContext(ctx).Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	if err != nil {
+		return models.Identity{}, err
+	}
+	return identity, nil
+}
+
+// NewIdentityRepo initializes a new identity repository
+func NewIdentityRepo(db *gorm.DB) IdentityRepo {
+	return &identityRepo{
+		db: *db,
+	}
+}
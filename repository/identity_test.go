@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mrinjamul/gnote/models"
+)
+
+func TestIdentities(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		identity := models.Identity{UserID: alice.ID, Issuer: "https://idp.example.com", Subject: "42"}
+		err := r.Identities.CreateIdentity(ctx, &identity)
+		if err != nil {
+			t.Fatalf("CreateIdentity() = %v", err)
+		}
+
+		// the unique index refuses a second link of the identity
+		again := models.Identity{UserID: alice.ID, Issuer: "https://idp.example.com", Subject: "42"}
+		err = r.Identities.CreateIdentity(ctx, &again)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("CreateIdentity(duplicate) = %v, want ErrConflict", err)
+		}
+
+		got, err := r.Identities.GetIdentity(ctx, "https://idp.example.com", "42")
+		if err != nil || got.UserID != alice.ID {
+			t.Errorf("GetIdentity() = %d, %v, want %d", got.UserID, err, alice.ID)
+		}
+		// the subjects are unique by issuer only
+		_, err = r.Identities.GetIdentity(ctx, "https://other.example.com", "42")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetIdentity(other issuer) = %v, want ErrNotFound", err)
+		}
+
+		// the identities go with their user
+		err = r.Users.DeleteUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("DeleteUser() = %v", err)
+		}
+		_, err = r.Identities.GetIdentity(ctx, "https://idp.example.com", "42")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetIdentity() after DeleteUser = %v, want ErrNotFound", err)
+		}
+	})
+}
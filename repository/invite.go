@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+	"gorm.io/gorm"
+)
+
+// InviteRepo is a repository for invite codes
+type InviteRepo interface {
+	// CreateInvite stores a new invite
+	CreateInvite(ctx context.Context, invite *models.Invite) error
+	// GetInvites returns all invites, newest first
+	GetInvites(ctx context.Context) ([]models.Invite, error)
+	// GetInvite returns a usable invite by hash
+	GetInvite(ctx context.Context, hash string) (models.Invite, error)
+	// UseInvite counts a use of an invite, it fails if the invite isn't usable anymore
+	UseInvite(ctx context.Context, id uint) error
+	// ReleaseInvite gives back a use of an invite
+	ReleaseInvite(ctx context.Context, id uint) error
+	// RevokeInvite revokes an invite
+	RevokeInvite(ctx context.Context, id uint) error
+}
+
+// inviteRepo is a repository for invite codes
+type inviteRepo struct {
+	db gorm.DB
+}
+
+// CreateInvite stores a new invite
+func (i *inviteRepo) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	err := i.db.WithContext(ctx).Create(invite).Error
+	if err != nil {
+		return duplicate(err, "invite already exists")
+	}
+	return nil
+}
+
+// GetInvites returns all invites, newest first
+func (i *inviteRepo) GetInvites(ctx context.Context) ([]models.Invite, error) {
+	var invites []models.Invite
+	err := i.db.WithContext(ctx).Order("id DESC").Find(&invites).Error
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// GetInvite returns a usable invite by hash
+func (i *inviteRepo) GetInvite(ctx context.Context, hash string) (models.Invite, error) {
+	var invite models.Invite
+	err := usable(i.db.WithContext(ctx).Where("hash = ?", hash)).First(&invite).Error
+	if err != nil {
+		return models.Invite{}, err
+	}
+	return invite, nil
+}
+
+// UseInvite counts a use of an invite, it fails if the invite isn't usable anymore
+func (i *inviteRepo) UseInvite(ctx context.Context, id uint) error {
+	result := usable(i.db.WithContext(ctx).Model(&models.Invite{}).Where("id = ?", id)).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return conflict("invite is no longer valid")
+	}
+	return nil
+}
+
+// ReleaseInvite gives back a use of an invite
+func (i *inviteRepo) ReleaseInvite(ctx context.Context, id uint) error {
+	err := i.db.WithContext(ctx).
+		Model(&models.Invite{}).
+		Where("id = ? AND uses > 0", id).
+		UpdateColumn("uses", gorm.Expr("uses - 1")).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RevokeInvite revokes an invite
+func (i *inviteRepo) RevokeInvite(ctx context.Context, id uint) error {
+	result := i.db.WithContext(ctx).
+		Model(&models.Invite{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return notFound("invite not found")
+	}
+	return nil
+}
+
+// usable restricts a query to invites which are not revoked, expired or used up
+func usable(db *gorm.DB) *gorm.DB {
+	return db.
+		Where("revoked_at IS NULL").
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("max_uses = 0 OR uses < max_uses")
+}
+
+// NewInviteRepo initializes a new invite repository
+func NewInviteRepo(db *gorm.DB) InviteRepo {
+	return &inviteRepo{
+		db: *db,
+	}
+}
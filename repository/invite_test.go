@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+)
+
+func TestInvites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		expired := sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+		invites := []models.Invite{
+			{Hash: "once", Role: "user", MaxUses: 1, CreatedBy: "admin"},
+			{Hash: "expired", Role: "user", ExpiresAt: expired, CreatedBy: "admin"},
+			{Hash: "unlimited", Role: "user", CreatedBy: "admin"},
+		}
+		for i := range invites {
+			err := r.Invites.CreateInvite(ctx, &invites[i])
+			if err != nil {
+				t.Fatalf("CreateInvite(%s) = %v", invites[i].Hash, err)
+			}
+		}
+
+		all, err := r.Invites.GetInvites(ctx)
+		if err != nil || len(all) != 3 || all[0].Hash != "unlimited" {
+			t.Errorf("GetInvites() = %d invites, %v, want 3 newest first", len(all), err)
+		}
+		_, err = r.Invites.GetInvite(ctx, "expired")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetInvite(expired) = %v, want ErrNotFound", err)
+		}
+
+		// the uses are counted up to the maximum and can be given back
+		once := invites[0]
+		err = r.Invites.UseInvite(ctx, once.ID)
+		if err != nil {
+			t.Fatalf("UseInvite() = %v", err)
+		}
+		err = r.Invites.UseInvite(ctx, once.ID)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("UseInvite(used up) = %v, want ErrConflict", err)
+		}
+		_, err = r.Invites.GetInvite(ctx, "once")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetInvite(used up) = %v, want ErrNotFound", err)
+		}
+		err = r.Invites.ReleaseInvite(ctx, once.ID)
+		if err != nil {
+			t.Fatalf("ReleaseInvite() = %v", err)
+		}
+		invite, err := r.Invites.GetInvite(ctx, "once")
+		if err != nil || invite.Uses != 0 {
+			t.Errorf("GetInvite(released) = %d uses, %v, want 0", invite.Uses, err)
+		}
+		err = r.Invites.UseInvite(ctx, invites[1].ID)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("UseInvite(expired) = %v, want ErrConflict", err)
+		}
+
+		unlimited := invites[2]
+		err = r.Invites.RevokeInvite(ctx, unlimited.ID)
+		if err != nil {
+			t.Fatalf("RevokeInvite() = %v", err)
+		}
+		err = r.Invites.RevokeInvite(ctx, unlimited.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("RevokeInvite(again) = %v, want ErrNotFound", err)
+		}
+		err = r.Invites.UseInvite(ctx, unlimited.ID)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("UseInvite(revoked) = %v, want ErrConflict", err)
+		}
+	})
+}
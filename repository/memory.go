@@ -0,0 +1,1037 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+	"github.com/mrinjamul/gnote/utils"
+	"gorm.io/gorm"
+)
+
+// memoryStore holds the records of the in-memory repositories. They share
+// it, so that a user deletion removes its notes, tokens and memberships at
+// once like the transaction of the GORM repository.
+type memoryStore struct {
+	mu          sync.RWMutex
+	notes       map[uint64]models.Note
+	users       map[uint]models.User
+	redirects   map[string]models.UsernameRedirect
+	tokens      map[uint]models.UserToken
+	invites     map[uint]models.Invite
+	identities  map[uint]models.Identity
+	orgs        map[uint]models.Organization
+	memberships map[uint]models.Membership
+	orgInvites  map[uint]models.OrgInvite
+	noteID      uint64
+	userID      uint
+	// lastID is the last id given to the records of the other tables
+	lastID uint
+}
+
+// memoryNoteRepo is a thread-safe note repository kept in memory
+type memoryNoteRepo struct {
+	store *memoryStore
+}
+
+// memoryUserRepo is a thread-safe user repository kept in memory
+type memoryUserRepo struct {
+	store *memoryStore
+}
+
+// memoryTokenRepo is a thread-safe token repository kept in memory
+type memoryTokenRepo struct {
+	store *memoryStore
+}
+
+// memoryInviteRepo is a thread-safe invite repository kept in memory
+type memoryInviteRepo struct {
+	store *memoryStore
+}
+
+// memoryIdentityRepo is a thread-safe identity repository kept in memory
+type memoryIdentityRepo struct {
+	store *memoryStore
+}
+
+// memoryOrgRepo is a thread-safe organization repository kept in memory
+type memoryOrgRepo struct {
+	store *memoryStore
+}
+
+// NewMemoryRepos initializes the repositories on one store kept in memory,
+// for the demo mode and the tests. The data is lost when the process exits.
+func NewMemoryRepos() Repos {
+	store := &memoryStore{
+		notes:       make(map[uint64]models.Note),
+		users:       make(map[uint]models.User),
+		redirects:   make(map[string]models.UsernameRedirect),
+		tokens:      make(map[uint]models.UserToken),
+		invites:     make(map[uint]models.Invite),
+		identities:  make(map[uint]models.Identity),
+		orgs:        make(map[uint]models.Organization),
+		memberships: make(map[uint]models.Membership),
+		orgInvites:  make(map[uint]models.OrgInvite),
+	}
+	return Repos{
+		Notes:      &memoryNoteRepo{store: store},
+		Users:      &memoryUserRepo{store: store},
+		Tokens:     &memoryTokenRepo{store: store},
+		Invites:    &memoryInviteRepo{store: store},
+		Identities: &memoryIdentityRepo{store: store},
+		Orgs:       &memoryOrgRepo{store: store},
+	}
+}
+
+// nextID returns the id of a new record
+func (s *memoryStore) nextID() uint {
+	s.lastID++
+	return s.lastID
+}
+
+// copyNote returns a copy of the note which doesn't share its pointers
+func copyNote(note models.Note) models.Note {
+	if note.UserID != nil {
+		userID := *note.UserID
+		note.UserID = &userID
+	}
+	if note.OrgID != nil {
+		orgID := *note.OrgID
+		note.OrgID = &orgID
+	}
+	note.User = nil
+	return note
+}
+
+// sortedNotes returns copies of the notes matching the filter, by id
+func (s *memoryStore) sortedNotes(match func(note models.Note) bool) []models.Note {
+	notes := []models.Note{}
+	for _, note := range s.notes {
+		if match(note) {
+			notes = append(notes, copyNote(note))
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].ID < notes[j].ID })
+	return notes
+}
+
+// userByUsername returns the user with the username
+func (s *memoryStore) userByUsername(username string) (models.User, bool) {
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, true
+		}
+	}
+	return models.User{}, false
+}
+
+// usernameReserved checks if the username still redirects to another user
+// than the given one
+func (s *memoryStore) usernameReserved(username string, id uint) bool {
+	redirect, ok := s.redirects[username]
+	return ok && redirect.UserID != id && redirect.ExpiresAt.After(time.Now())
+}
+
+// noteBytes returns the size in bytes of a note
+func noteBytes(note models.Note) int64 {
+	return int64(len(note.Title) + len(note.Content))
+}
+
+// Create creates a new note
+func (repo *memoryNoteRepo) Create(ctx context.Context, note *models.Note) error {
+	err := validateNote(note)
+	if err != nil {
+		return err
+	}
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+	repo.store.noteID++
+	now := time.Now()
+	note.ID = repo.store.noteID
+	note.CreatedAt = now
+	note.UpdatedAt = now
+	repo.store.notes[note.ID] = copyNote(*note)
+	return nil
+}
+
+// Read reads a note
+func (repo *memoryNoteRepo) Read(ctx context.Context, note *models.Note) error {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	stored, ok := repo.store.notes[note.ID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	*note = copyNote(stored)
+	return nil
+}
+
+// ReadByUser reads all personal notes of a user
+func (repo *memoryNoteRepo) ReadByUser(ctx context.Context, userID uint) ([]models.Note, error) {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	return repo.store.sortedNotes(func(note models.Note) bool {
+		return note.UserID != nil && *note.UserID == userID && note.OrgID == nil
+	}), nil
+}
+
+// ReadByOrg reads all notes of an organization
+func (repo *memoryNoteRepo) ReadByOrg(ctx context.Context, orgID uint) ([]models.Note, error) {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	return repo.store.sortedNotes(func(note models.Note) bool {
+		return note.OrgID != nil && *note.OrgID == orgID
+	}), nil
+}
+
+// ReadInOrg reads a note of an organization
+func (repo *memoryNoteRepo) ReadInOrg(ctx context.Context, orgID uint, id uint64) (models.Note, error) {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	note, ok := repo.store.notes[id]
+	if !ok || note.OrgID == nil || *note.OrgID != orgID {
+		return models.Note{}, gorm.ErrRecordNotFound
+	}
+	return copyNote(note), nil
+}
+
+// ReadAll reads all notes
+func (repo *memoryNoteRepo) ReadAll(ctx context.Context) ([]models.Note, error) {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	return repo.store.sortedNotes(func(note models.Note) bool { return true }), nil
+}
+
+// Update updates a note, it is created when missing like GORM's Save
+func (repo *memoryNoteRepo) Update(ctx context.Context, note models.Note) (models.Note, error) {
+	err := validateNote(&note)
+	if err != nil {
+		return note, err
+	}
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+	now := time.Now()
+	if note.ID == 0 {
+		repo.store.noteID++
+		note.ID = repo.store.noteID
+	} else if note.ID > repo.store.noteID {
+		repo.store.noteID = note.ID
+	}
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = now
+	}
+	note.UpdatedAt = now
+	repo.store.notes[note.ID] = copyNote(note)
+	return copyNote(note), nil
+}
+
+// Delete deletes a note
+func (repo *memoryNoteRepo) Delete(ctx context.Context, note *models.Note) error {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+	delete(repo.store.notes, note.ID)
+	return nil
+}
+
+// DeleteAllByUser deletes all personal notes of a user
+func (repo *memoryNoteRepo) DeleteAllByUser(ctx context.Context, userID uint) error {
+	repo.store.mu.Lock()
+	defer repo.store.mu.Unlock()
+	for id, note := range repo.store.notes {
+		if note.UserID != nil && *note.UserID == userID && note.OrgID == nil {
+			delete(repo.store.notes, id)
+		}
+	}
+	return nil
+}
+
+// UsageByUserName returns the storage used by the notes of a user
+func (repo *memoryNoteRepo) UsageByUserName(ctx context.Context, username string) (models.Usage, error) {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	var usage models.Usage
+	user, ok := repo.store.userByUsername(username)
+	if !ok {
+		return usage, nil
+	}
+	for _, note := range repo.store.notes {
+		if note.UserID != nil && *note.UserID == user.ID {
+			usage.Notes++
+			usage.Bytes += noteBytes(note)
+		}
+	}
+	return usage, nil
+}
+
+// UsageByUser returns the storage used by each user, largest first
+func (repo *memoryNoteRepo) UsageByUser(ctx context.Context, limit int) ([]models.Usage, error) {
+	repo.store.mu.RLock()
+	defer repo.store.mu.RUnlock()
+	byUser := make(map[uint]*models.Usage)
+	for _, note := range repo.store.notes {
+		if note.UserID == nil {
+			continue
+		}
+		user, ok := repo.store.users[*note.UserID]
+		if !ok {
+			continue
+		}
+		usage, ok := byUser[user.ID]
+		if !ok {
+			usage = &models.Usage{Username: user.Username}
+			byUser[user.ID] = usage
+		}
+		usage.Notes++
+		usage.Bytes += noteBytes(note)
+	}
+	usages := make([]models.Usage, 0, len(byUser))
+	for _, usage := range byUser {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Bytes != usages[j].Bytes {
+			return usages[i].Bytes > usages[j].Bytes
+		}
+		return usages[i].Username < usages[j].Username
+	})
+	if limit >= 0 && len(usages) > limit {
+		usages = usages[:limit]
+	}
+	return usages, nil
+}
+
+// VerifyPassword verifies the password
+func (repo *memoryNoteRepo) VerifyPassword(ctx context.Context, username, password string) (bool, error) {
+	repo.store.mu.RLock()
+	user, _ := repo.store.userByUsername(username)
+	repo.store.mu.RUnlock()
+	return utils.VerifyHash(password, user.Password), nil
+}
+
+// CreateUser creates a new user
+func (u *memoryUserRepo) CreateUser(ctx context.Context, user *models.User) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	for _, existing := range u.store.users {
+		if existing.Username == user.Username || existing.Email == user.Email {
+			return conflict("user already exists")
+		}
+	}
+	// old usernames of renamed users are reserved while they redirect
+	if u.store.usernameReserved(user.Username, 0) {
+		return conflict("user already exists")
+	}
+	// drop the notes left behind by a former owner of the username so that
+	// they are not inherited
+	for id, note := range u.store.notes {
+		if note.Username == user.Username && note.UserID == nil && note.OrgID == nil {
+			delete(u.store.notes, id)
+		}
+	}
+	u.store.userID++
+	now := time.Now()
+	user.ID = u.store.userID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	u.store.users[user.ID] = *user
+	return nil
+}
+
+// GetUser returns a user by id
+func (u *memoryUserRepo) GetUser(ctx context.Context, id int) (models.User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	user, ok := u.store.users[uint(id)]
+	if !ok {
+		return models.User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+// GetUsers returns all users
+func (u *memoryUserRepo) GetUsers(ctx context.Context) ([]models.User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	return u.sortedUsers(func(user models.User) bool { return true }), nil
+}
+
+// AdminExists checks if there is at least one admin
+func (u *memoryUserRepo) AdminExists(ctx context.Context) (bool, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	for _, user := range u.store.users {
+		if user.Role == "admin" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SearchUsers returns a page of users matching the query and the total count
+func (u *memoryUserRepo) SearchUsers(ctx context.Context, query string, offset, limit int) ([]models.User, int64, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	query = strings.ToLower(query)
+	users := u.sortedUsers(func(user models.User) bool {
+		for _, field := range []string{user.Username, user.Email, user.FirstName, user.LastName} {
+			if strings.Contains(strings.ToLower(field), query) {
+				return true
+			}
+		}
+		return false
+	})
+	total := int64(len(users))
+	if offset > len(users) {
+		offset = len(users)
+	}
+	users = users[offset:]
+	if limit >= 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users, total, nil
+}
+
+// RecentUsers returns the users created since the given time, newest first
+func (u *memoryUserRepo) RecentUsers(ctx context.Context, since time.Time, limit int) ([]models.User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	users := u.sortedUsers(func(user models.User) bool {
+		return !user.CreatedAt.Before(since)
+	})
+	sort.SliceStable(users, func(i, j int) bool { return users[i].CreatedAt.After(users[j].CreatedAt) })
+	if limit >= 0 && len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+// GetUserByUsername returns a user by username
+func (u *memoryUserRepo) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	user, ok := u.store.userByUsername(username)
+	if !ok {
+		return models.User{}, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+// GetUserByEmail returns a user by email
+func (u *memoryUserRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	for _, user := range u.store.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return models.User{}, gorm.ErrRecordNotFound
+}
+
+// UpdateUser updates an existing user
+func (u *memoryUserRepo) UpdateUser(ctx context.Context, user *models.User) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	if _, ok := u.store.users[user.ID]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	user.UpdatedAt = time.Now()
+	u.store.users[user.ID] = *user
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user
+func (u *memoryUserRepo) UpdatePassword(ctx context.Context, id uint, hash string) error {
+	return u.update(id, func(user *models.User) {
+		user.Password = hash
+	})
+}
+
+// ScheduleDeletion schedules the deletion of a user at the given time
+func (u *memoryUserRepo) ScheduleDeletion(ctx context.Context, id uint, at time.Time) error {
+	return u.update(id, func(user *models.User) {
+		user.DeletionDueAt.Time = at
+		user.DeletionDueAt.Valid = true
+	})
+}
+
+// RestoreUser cancels the scheduled deletion of a user
+func (u *memoryUserRepo) RestoreUser(ctx context.Context, id uint) error {
+	return u.update(id, func(user *models.User) {
+		user.DeletionDueAt.Time = time.Time{}
+		user.DeletionDueAt.Valid = false
+	})
+}
+
+// GetUsersDueForDeletion returns the users whose deletion is due
+func (u *memoryUserRepo) GetUsersDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	return u.sortedUsers(func(user models.User) bool {
+		return user.DeletionDueAt.Valid && !user.DeletionDueAt.Time.After(now)
+	}), nil
+}
+
+// ChangeUsername renames a user along with the author name of its notes,
+// the old username redirects to the user until the given time
+func (u *memoryUserRepo) ChangeUsername(ctx context.Context, id uint, username string, redirectUntil time.Time) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	user, ok := u.store.users[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if _, taken := u.store.userByUsername(username); taken || u.store.usernameReserved(username, id) {
+		return conflict("username is already taken")
+	}
+	// a former username of the user or an expired redirect is reused
+	delete(u.store.redirects, username)
+	oldUsername := user.Username
+	user.Username = username
+	user.UpdatedAt = time.Now()
+	u.store.users[id] = user
+	for noteID, note := range u.store.notes {
+		if note.UserID != nil && *note.UserID == id {
+			note.Username = username
+			u.store.notes[noteID] = note
+		}
+	}
+	u.store.redirects[oldUsername] = models.UsernameRedirect{
+		Username:  oldUsername,
+		UserID:    id,
+		ExpiresAt: redirectUntil,
+		CreatedAt: time.Now(),
+	}
+	return nil
+}
+
+// GetUsernameRedirect returns the active redirect of an old username
+func (u *memoryUserRepo) GetUsernameRedirect(ctx context.Context, username string) (models.UsernameRedirect, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	redirect, ok := u.store.redirects[username]
+	if !ok || !redirect.ExpiresAt.After(time.Now()) {
+		return models.UsernameRedirect{}, gorm.ErrRecordNotFound
+	}
+	return redirect, nil
+}
+
+// UsernameAvailable checks if the username is neither taken nor reserved by
+// a redirect
+func (u *memoryUserRepo) UsernameAvailable(ctx context.Context, username string) (bool, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+	_, taken := u.store.userByUsername(username)
+	return !taken && !u.store.usernameReserved(username, 0), nil
+}
+
+// DeleteUser deletes a user with its personal notes, tokens, identities and
+// memberships, the notes shared in organizations stay without their author
+func (u *memoryUserRepo) DeleteUser(ctx context.Context, id uint) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	if _, ok := u.store.users[id]; !ok {
+		return gorm.ErrRecordNotFound
+	}
+	for noteID, note := range u.store.notes {
+		if note.UserID == nil || *note.UserID != id {
+			continue
+		}
+		if note.OrgID == nil {
+			delete(u.store.notes, noteID)
+			continue
+		}
+		note.UserID = nil
+		note.Username = ""
+		u.store.notes[noteID] = note
+	}
+	for username, redirect := range u.store.redirects {
+		if redirect.UserID == id {
+			delete(u.store.redirects, username)
+		}
+	}
+	for tokenID, token := range u.store.tokens {
+		if token.UserID == id {
+			delete(u.store.tokens, tokenID)
+		}
+	}
+	for identityID, identity := range u.store.identities {
+		if identity.UserID == id {
+			delete(u.store.identities, identityID)
+		}
+	}
+	for membershipID, membership := range u.store.memberships {
+		if membership.UserID == id {
+			delete(u.store.memberships, membershipID)
+		}
+	}
+	delete(u.store.users, id)
+	return nil
+}
+
+// update applies the change to a stored user
+func (u *memoryUserRepo) update(id uint, change func(user *models.User)) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	user, ok := u.store.users[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	change(&user)
+	user.UpdatedAt = time.Now()
+	u.store.users[id] = user
+	return nil
+}
+
+// sortedUsers returns the users matching the filter, by id
+func (u *memoryUserRepo) sortedUsers(match func(user models.User) bool) []models.User {
+	users := []models.User{}
+	for _, user := range u.store.users {
+		if match(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// CreateToken stores a new token
+func (t *memoryTokenRepo) CreateToken(ctx context.Context, token *models.UserToken) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	for _, existing := range t.store.tokens {
+		if existing.Hash == token.Hash {
+			return conflict("token already exists")
+		}
+	}
+	token.ID = t.store.nextID()
+	token.CreatedAt = time.Now()
+	t.store.tokens[token.ID] = *token
+	return nil
+}
+
+// GetToken returns an unused, unexpired token by hash and purpose
+func (t *memoryTokenRepo) GetToken(ctx context.Context, hash, purpose string) (models.UserToken, error) {
+	t.store.mu.RLock()
+	defer t.store.mu.RUnlock()
+	now := time.Now()
+	for _, token := range t.store.tokens {
+		if token.Hash == hash && token.Purpose == purpose && !token.UsedAt.Valid && token.ExpiresAt.After(now) {
+			return token, nil
+		}
+	}
+	return models.UserToken{}, gorm.ErrRecordNotFound
+}
+
+// UseToken marks a token as used, it fails if the token was already used
+func (t *memoryTokenRepo) UseToken(ctx context.Context, id uint) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	token, ok := t.store.tokens[id]
+	if !ok || token.UsedAt.Valid {
+		return conflict("token already used")
+	}
+	token.UsedAt.Time = time.Now()
+	token.UsedAt.Valid = true
+	t.store.tokens[id] = token
+	return nil
+}
+
+// DeleteTokens deletes all tokens of a user for a purpose
+func (t *memoryTokenRepo) DeleteTokens(ctx context.Context, userID uint, purpose string) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+	for id, token := range t.store.tokens {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(t.store.tokens, id)
+		}
+	}
+	return nil
+}
+
+// CreateInvite stores a new invite
+func (i *memoryInviteRepo) CreateInvite(ctx context.Context, invite *models.Invite) error {
+	i.store.mu.Lock()
+	defer i.store.mu.Unlock()
+	for _, existing := range i.store.invites {
+		if existing.Hash == invite.Hash {
+			return conflict("invite already exists")
+		}
+	}
+	invite.ID = i.store.nextID()
+	invite.CreatedAt = time.Now()
+	i.store.invites[invite.ID] = *invite
+	return nil
+}
+
+// GetInvites returns all invites, newest first
+func (i *memoryInviteRepo) GetInvites(ctx context.Context) ([]models.Invite, error) {
+	i.store.mu.RLock()
+	defer i.store.mu.RUnlock()
+	invites := make([]models.Invite, 0, len(i.store.invites))
+	for _, invite := range i.store.invites {
+		invites = append(invites, invite)
+	}
+	sort.Slice(invites, func(a, b int) bool { return invites[a].ID > invites[b].ID })
+	return invites, nil
+}
+
+// GetInvite returns a usable invite by hash
+func (i *memoryInviteRepo) GetInvite(ctx context.Context, hash string) (models.Invite, error) {
+	i.store.mu.RLock()
+	defer i.store.mu.RUnlock()
+	for _, invite := range i.store.invites {
+		if invite.Hash == hash && inviteUsable(invite) {
+			return invite, nil
+		}
+	}
+	return models.Invite{}, gorm.ErrRecordNotFound
+}
+
+// UseInvite counts a use of an invite, it fails if the invite isn't usable anymore
+func (i *memoryInviteRepo) UseInvite(ctx context.Context, id uint) error {
+	i.store.mu.Lock()
+	defer i.store.mu.Unlock()
+	invite, ok := i.store.invites[id]
+	if !ok || !inviteUsable(invite) {
+		return conflict("invite is no longer valid")
+	}
+	invite.Uses++
+	i.store.invites[id] = invite
+	return nil
+}
+
+// ReleaseInvite gives back a use of an invite
+func (i *memoryInviteRepo) ReleaseInvite(ctx context.Context, id uint) error {
+	i.store.mu.Lock()
+	defer i.store.mu.Unlock()
+	invite, ok := i.store.invites[id]
+	if ok && invite.Uses > 0 {
+		invite.Uses--
+		i.store.invites[id] = invite
+	}
+	return nil
+}
+
+// RevokeInvite revokes an invite
+func (i *memoryInviteRepo) RevokeInvite(ctx context.Context, id uint) error {
+	i.store.mu.Lock()
+	defer i.store.mu.Unlock()
+	invite, ok := i.store.invites[id]
+	if !ok || invite.RevokedAt.Valid {
+		return notFound("invite not found")
+	}
+	invite.RevokedAt.Time = time.Now()
+	invite.RevokedAt.Valid = true
+	i.store.invites[id] = invite
+	return nil
+}
+
+// inviteUsable checks that an invite is not revoked, expired or used up
+func inviteUsable(invite models.Invite) bool {
+	return !invite.RevokedAt.Valid &&
+		(!invite.ExpiresAt.Valid || invite.ExpiresAt.Time.After(time.Now())) &&
+		(invite.MaxUses == 0 || invite.Uses < invite.MaxUses)
+}
+
+// CreateIdentity links a new external identity to a user
+func (i *memoryIdentityRepo) CreateIdentity(ctx context.Context, identity *models.Identity) error {
+	i.store.mu.Lock()
+	defer i.store.mu.Unlock()
+	for _, existing := range i.store.identities {
+		if existing.Issuer == identity.Issuer && existing.Subject == identity.Subject {
+			return conflict("identity already exists")
+		}
+	}
+	identity.ID = i.store.nextID()
+	identity.CreatedAt = time.Now()
+	i.store.identities[identity.ID] = *identity
+	return nil
+}
+
+// GetIdentity returns an identity by issuer and subject
+func (i *memoryIdentityRepo) GetIdentity(ctx context.Context, issuer, subject string) (models.Identity, error) {
+	i.store.mu.RLock()
+	defer i.store.mu.RUnlock()
+	for _, identity := range i.store.identities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return models.Identity{}, gorm.ErrRecordNotFound
+}
+
+// CreateOrg creates an organization owned by the given user
+func (o *memoryOrgRepo) CreateOrg(ctx context.Context, org *models.Organization, ownerID uint) error {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	if _, ok := o.store.orgByName(org.Name); ok {
+		return conflict("organization already exists")
+	}
+	now := time.Now()
+	org.ID = o.store.nextID()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+	o.store.orgs[org.ID] = *org
+	membership := models.Membership{
+		ID:        o.store.nextID(),
+		OrgID:     org.ID,
+		UserID:    ownerID,
+		Role:      models.OrgRoleOwner,
+		CreatedAt: now,
+	}
+	o.store.memberships[membership.ID] = membership
+	return nil
+}
+
+// GetOrg returns an organization by name
+func (o *memoryOrgRepo) GetOrg(ctx context.Context, name string) (models.Organization, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	org, ok := o.store.orgByName(name)
+	if !ok {
+		return models.Organization{}, gorm.ErrRecordNotFound
+	}
+	return org, nil
+}
+
+// GetWorkspaces returns the organizations of a user with the user's role
+func (o *memoryOrgRepo) GetWorkspaces(ctx context.Context, userID uint) ([]models.Workspace, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	workspaces := []models.Workspace{}
+	for _, membership := range o.store.memberships {
+		org, ok := o.store.orgs[membership.OrgID]
+		if !ok || membership.UserID != userID {
+			continue
+		}
+		workspaces = append(workspaces, models.Workspace{
+			Name:        org.Name,
+			DisplayName: org.DisplayName,
+			Role:        membership.Role,
+		})
+	}
+	sort.Slice(workspaces, func(i, j int) bool { return workspaces[i].Name < workspaces[j].Name })
+	return workspaces, nil
+}
+
+// DeleteOrg deletes an organization with its notes, members and invites
+func (o *memoryOrgRepo) DeleteOrg(ctx context.Context, id uint) error {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	for noteID, note := range o.store.notes {
+		if note.OrgID != nil && *note.OrgID == id {
+			delete(o.store.notes, noteID)
+		}
+	}
+	for inviteID, invite := range o.store.orgInvites {
+		if invite.OrgID == id {
+			delete(o.store.orgInvites, inviteID)
+		}
+	}
+	for membershipID, membership := range o.store.memberships {
+		if membership.OrgID == id {
+			delete(o.store.memberships, membershipID)
+		}
+	}
+	delete(o.store.orgs, id)
+	return nil
+}
+
+// GetMembership returns the membership of a user in an organization
+func (o *memoryOrgRepo) GetMembership(ctx context.Context, orgID, userID uint) (models.Membership, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	membership, ok := o.store.membership(orgID, userID)
+	if !ok {
+		return models.Membership{}, gorm.ErrRecordNotFound
+	}
+	return membership, nil
+}
+
+// GetMembers returns the members of an organization
+func (o *memoryOrgRepo) GetMembers(ctx context.Context, orgID uint) ([]models.Member, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	members := []models.Member{}
+	for _, membership := range o.store.memberships {
+		user, ok := o.store.users[membership.UserID]
+		if !ok || membership.OrgID != orgID {
+			continue
+		}
+		members = append(members, models.Member{
+			Username: user.Username,
+			Role:     membership.Role,
+			JoinedAt: membership.CreatedAt,
+		})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Username < members[j].Username })
+	return members, nil
+}
+
+// SaveMembership creates or updates a membership
+func (o *memoryOrgRepo) SaveMembership(ctx context.Context, membership *models.Membership) error {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	existing, ok := o.store.membership(membership.OrgID, membership.UserID)
+	if ok && existing.ID != membership.ID {
+		return conflict("already a member")
+	}
+	if membership.ID == 0 {
+		membership.ID = o.store.nextID()
+	}
+	if membership.CreatedAt.IsZero() {
+		membership.CreatedAt = time.Now()
+	}
+	o.store.memberships[membership.ID] = *membership
+	return nil
+}
+
+// DeleteMembership removes a user from an organization
+func (o *memoryOrgRepo) DeleteMembership(ctx context.Context, orgID, userID uint) error {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	if membership, ok := o.store.membership(orgID, userID); ok {
+		delete(o.store.memberships, membership.ID)
+	}
+	return nil
+}
+
+// CountOwners returns the number of owners of an organization
+func (o *memoryOrgRepo) CountOwners(ctx context.Context, orgID uint) (int64, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	return o.store.countOwners(orgID), nil
+}
+
+// GetSoleOwnedOrgs returns the organizations whose only owner is the user
+func (o *memoryOrgRepo) GetSoleOwnedOrgs(ctx context.Context, userID uint) ([]models.Organization, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	orgs := []models.Organization{}
+	for _, membership := range o.store.memberships {
+		org, ok := o.store.orgs[membership.OrgID]
+		if !ok || membership.UserID != userID || membership.Role != models.OrgRoleOwner {
+			continue
+		}
+		if o.store.countOwners(org.ID) == 1 {
+			orgs = append(orgs, org)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].ID < orgs[j].ID })
+	return orgs, nil
+}
+
+// CreateOrgInvite stores a new invite
+func (o *memoryOrgRepo) CreateOrgInvite(ctx context.Context, invite *models.OrgInvite) error {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	for _, existing := range o.store.orgInvites {
+		if existing.Hash == invite.Hash {
+			return conflict("invite already exists")
+		}
+	}
+	invite.ID = o.store.nextID()
+	invite.CreatedAt = time.Now()
+	o.store.orgInvites[invite.ID] = *invite
+	return nil
+}
+
+// GetOrgInvites returns the pending invites of an organization
+func (o *memoryOrgRepo) GetOrgInvites(ctx context.Context, orgID uint) ([]models.OrgInvite, error) {
+	o.store.mu.RLock()
+	defer o.store.mu.RUnlock()
+	invites := []models.OrgInvite{}
+	for _, invite := range o.store.orgInvites {
+		if invite.OrgID == orgID && orgInvitePending(invite) {
+			invites = append(invites, invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].ID > invites[j].ID })
+	return invites, nil
+}
+
+// AcceptOrgInvite adds the user to the organization of a pending invite, the
+// invite can only be accepted once
+func (o *memoryOrgRepo) AcceptOrgInvite(ctx context.Context, orgID uint, hash string, userID uint, username string) (models.Membership, error) {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	var invite models.OrgInvite
+	found := false
+	for _, existing := range o.store.orgInvites {
+		if existing.OrgID == orgID && existing.Hash == hash && orgInvitePending(existing) {
+			invite, found = existing, true
+			break
+		}
+	}
+	if !found {
+		return models.Membership{}, invalid("code", "invalid invite code")
+	}
+	if _, ok := o.store.membership(orgID, userID); ok {
+		return models.Membership{}, conflict("already a member")
+	}
+	invite.AcceptedAt.Time = time.Now()
+	invite.AcceptedAt.Valid = true
+	invite.AcceptedBy = username
+	o.store.orgInvites[invite.ID] = invite
+	membership := models.Membership{
+		ID:        o.store.nextID(),
+		OrgID:     orgID,
+		UserID:    userID,
+		Role:      invite.Role,
+		CreatedAt: time.Now(),
+	}
+	o.store.memberships[membership.ID] = membership
+	return membership, nil
+}
+
+// DeleteOrgInvite deletes a pending invite
+func (o *memoryOrgRepo) DeleteOrgInvite(ctx context.Context, orgID, id uint) error {
+	o.store.mu.Lock()
+	defer o.store.mu.Unlock()
+	invite, ok := o.store.orgInvites[id]
+	if !ok || invite.OrgID != orgID || invite.AcceptedAt.Valid {
+		return notFound("invite not found")
+	}
+	delete(o.store.orgInvites, id)
+	return nil
+}
+
+// orgByName returns the organization with the name
+func (s *memoryStore) orgByName(name string) (models.Organization, bool) {
+	for _, org := range s.orgs {
+		if org.Name == name {
+			return org, true
+		}
+	}
+	return models.Organization{}, false
+}
+
+// membership returns the membership of a user in an organization
+func (s *memoryStore) membership(orgID, userID uint) (models.Membership, bool) {
+	for _, membership := range s.memberships {
+		if membership.OrgID == orgID && membership.UserID == userID {
+			return membership, true
+		}
+	}
+	return models.Membership{}, false
+}
+
+// countOwners returns the number of owners of an organization
+func (s *memoryStore) countOwners(orgID uint) int64 {
+	var count int64
+	for _, membership := range s.memberships {
+		if membership.OrgID == orgID && membership.Role == models.OrgRoleOwner {
+			count++
+		}
+	}
+	return count
+}
+
+// orgInvitePending checks that an invite is neither accepted nor expired
+func orgInvitePending(invite models.OrgInvite) bool {
+	return !invite.AcceptedAt.Valid && invite.ExpiresAt.After(time.Now())
+}
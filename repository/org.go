@@ -0,0 +1,279 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+	"gorm.io/gorm"
+)
+
+// OrgRepo is a repository for organizations, their members and invites
+type OrgRepo interface {
+	// CreateOrg creates an organization owned by the given user
+	CreateOrg(ctx context.Context, org *models.Organization, ownerID uint) error
+	// GetOrg returns an organization by name
+	GetOrg(ctx context.Context, name string) (models.Organization, error)
+	// GetWorkspaces returns the organizations of a user with the user's role
+	GetWorkspaces(ctx context.Context, userID uint) ([]models.Workspace, error)
+	// DeleteOrg deletes an organization with its notes, members and invites
+	DeleteOrg(ctx context.Context, id uint) error
+	// GetMembership returns the membership of a user in an organization
+	GetMembership(ctx context.Context, orgID, userID uint) (models.Membership, error)
+	// GetMembers returns the members of an organization
+	GetMembers(ctx context.Context, orgID uint) ([]models.Member, error)
+	// SaveMembership creates or updates a membership
+	SaveMembership(ctx context.Context, membership *models.Membership) error
+	// DeleteMembership removes a user from an organization
+	DeleteMembership(ctx context.Context, orgID, userID uint) error
+	// CountOwners returns the number of owners of an organization
+	CountOwners(ctx context.Context, orgID uint) (int64, error)
+	// GetSoleOwnedOrgs returns the organizations whose only owner is the user
+	GetSoleOwnedOrgs(ctx context.Context, userID uint) ([]models.Organization, error)
+	// CreateOrgInvite stores a new invite
+	CreateOrgInvite(ctx context.Context, invite *models.OrgInvite) error
+	// GetOrgInvites returns the pending invites of an organization
+	GetOrgInvites(ctx context.Context, orgID uint) ([]models.OrgInvite, error)
+	// AcceptOrgInvite adds the user to the organization of a pending invite
+	AcceptOrgInvite(ctx context.Context, orgID uint, hash string, userID uint, username string) (models.Membership, error)
+	// DeleteOrgInvite deletes a pending invite
+	DeleteOrgInvite(ctx context.Context, orgID, id uint) error
+}
+
+// orgRepo is a repository for organizations, their members and invites
+type orgRepo struct {
+	db gorm.DB
+}
+
+// CreateOrg creates an organization owned by the given user
+func (o *orgRepo) CreateOrg(ctx context.Context, org *models.Organization, ownerID uint) error {
+	return o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var exists bool
+		err := tx.
+			Model(models.Organization{}).
+			Select("count(*) > 0").
+			Where("name = ?", org.Name).
+			Find(&exists).Error
+		if err != nil {
+			return err
+		}
+		if exists {
+			return conflict("organization already exists")
+		}
+		err = tx.Create(org).Error
+		if err != nil {
+			return duplicate(err, "organization already exists")
+		}
+		return tx.Create(&models.Membership{
+			OrgID:  org.ID,
+			UserID: ownerID,
+			Role:   models.OrgRoleOwner,
+		}).Error
+	})
+}
+
+// GetOrg returns an organization by name
+func (o *orgRepo) GetOrg(ctx context.Context, name string) (models.Organization, error) {
+	var org models.Organization
+	err := o.db.WithContext(ctx).Where("name = ?", name).First(&org).Error
+	if err != nil {
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+// GetWorkspaces returns the organizations of a user with the user's role
+func (o *orgRepo) GetWorkspaces(ctx context.Context, userID uint) ([]models.Workspace, error) {
+	var workspaces []models.Workspace
+	err := o.db.WithContext(ctx).
+		Model(&models.Membership{}).
+		Select("organizations.name, organizations.display_name, memberships.role").
+		Joins("JOIN organizations ON organizations.id = memberships.org_id").
+		Where("memberships.user_id = ?", userID).
+		Order("organizations.name").
+		Scan(&workspaces).Error
+	if err != nil {
+		return nil, err
+	}
+	return workspaces, nil
+}
+
+// DeleteOrg deletes an organization with its notes, members and invites
+func (o *orgRepo) DeleteOrg(ctx context.Context, id uint) error {
+	return o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("org_id = ?", id).Delete(&models.Note{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("org_id = ?", id).Delete(&models.OrgInvite{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("org_id = ?", id).Delete(&models.Membership{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.Organization{}, id).Error
+	})
+}
+
+// GetMembership returns the membership of a user in an organization
+func (o *orgRepo) GetMembership(ctx context.Context, orgID, userID uint) (models.Membership, error) {
+	var membership models.Membership
+	err := o.db.WithContext(ctx).Where("org_id = ? AND user_id = ?", orgID, userID).First(&membership).Error
+	if err != nil {
+		return models.Membership{}, err
+	}
+	return membership, nil
+}
+
+// GetMembers returns the members of an organization
+func (o *orgRepo) GetMembers(ctx context.Context, orgID uint) ([]models.Member, error) {
+	var members []models.Member
+	err := o.db.WithContext(ctx).
+		Model(&models.Membership{}).
+		Select("users.username, memberships.role, memberships.created_at AS joined_at").
+		Joins("JOIN users ON users.id = memberships.user_id").
+		Where("memberships.org_id = ?", orgID).
+		Order("users.username").
+		Scan(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SaveMembership creates or updates a membership
+func (o *orgRepo) SaveMembership(ctx context.Context, membership *models.Membership) error {
+	err := o.db.WithContext(ctx).Save(membership).Error
+	if err != nil {
+		return duplicate(err, "already a member")
+	}
+	return nil
+}
+
+// DeleteMembership removes a user from an organization
+func (o *orgRepo) DeleteMembership(ctx context.Context, orgID, userID uint) error {
+	err := o.db.WithContext(ctx).Where("org_id = ? AND user_id = ?", orgID, userID).Delete(&models.Membership{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CountOwners returns the number of owners of an organization
+func (o *orgRepo) CountOwners(ctx context.Context, orgID uint) (int64, error) {
+	var count int64
+	err := o.db.WithContext(ctx).
+		Model(&models.Membership{}).
+		Where("org_id = ? AND role = ?", orgID, models.OrgRoleOwner).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetSoleOwnedOrgs returns the organizations whose only owner is the user
+func (o *orgRepo) GetSoleOwnedOrgs(ctx context.Context, userID uint) ([]models.Organization, error) {
+	var orgs []models.Organization
+	owners := o.db.WithContext(ctx).
+		Model(&models.Membership{}).
+		Select("org_id").
+		Where("role = ?", models.OrgRoleOwner).
+		Group("org_id").
+		Having("COUNT(*) = 1")
+	err := o.db.WithContext(ctx).
+		Joins("JOIN memberships ON memberships.org_id = organizations.id").
+		Where("memberships.user_id = ? AND memberships.role = ?", userID, models.OrgRoleOwner).
+		Where("organizations.id IN (?)", owners).
+		Find(&orgs).Error
+	if err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// CreateOrgInvite stores a new invite
+func (o *orgRepo) CreateOrgInvite(ctx context.Context, invite *models.OrgInvite) error {
+	err := o.db.WithContext(ctx).Create(invite).Error
+	if err != nil {
+		return duplicate(err, "invite already exists")
+	}
+	return nil
+}
+
+// GetOrgInvites returns the pending invites of an organization
+func (o *orgRepo) GetOrgInvites(ctx context.Context, orgID uint) ([]models.OrgInvite, error) {
+	var invites []models.OrgInvite
+	err := o.db.WithContext(ctx).
+		Where("org_id = ? AND accepted_at IS NULL AND expires_at > ?", orgID, time.Now()).
+		Order("id DESC").
+		Find(&invites).Error
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
+}
+
+// AcceptOrgInvite adds the user to the organization of a pending invite, the
+// invite can only be accepted once
+func (o *orgRepo) AcceptOrgInvite(ctx context.Context, orgID uint, hash string, userID uint, username string) (models.Membership, error) {
+	var membership models.Membership
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var invite models.OrgInvite
+		err := tx.
+			Where("org_id = ? AND hash = ?", orgID, hash).
+			Where("accepted_at IS NULL AND expires_at > ?", time.Now()).
+			First(&invite).Error
+		if err != nil {
+			return invalid("code", "invalid invite code")
+		}
+		result := tx.
+			Model(&models.OrgInvite{}).
+			Where("id = ? AND accepted_at IS NULL", invite.ID).
+			Updates(map[string]interface{}{"accepted_at": time.Now(), "accepted_by": username})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return invalid("code", "invalid invite code")
+		}
+
+		err = tx.Where("org_id = ? AND user_id = ?", orgID, userID).First(&membership).Error
+		if err == nil {
+			return conflict("already a member")
+		}
+		membership = models.Membership{
+			OrgID:  orgID,
+			UserID: userID,
+			Role:   invite.Role,
+		}
+		return duplicate(tx.Create(&membership).Error, "already a member")
+	})
+	if err != nil {
+		return models.Membership{}, err
+	}
+	return membership, nil
+}
+
+// DeleteOrgInvite deletes a pending invite
+func (o *orgRepo) DeleteOrgInvite(ctx context.Context, orgID, id uint) error {
+	result := o.db.WithContext(ctx).
+		Where("org_id = ? AND id = ? AND accepted_at IS NULL", orgID, id).
+		Delete(&models.OrgInvite{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return notFound("invite not found")
+	}
+	return nil
+}
+
+// NewOrgRepo initializes a new organization repository
+func NewOrgRepo(db *gorm.DB) OrgRepo {
+	return &orgRepo{
+		db: *db,
+	}
+}
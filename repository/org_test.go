@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+)
+
+// createOrg creates an organization owned by the user, failing the test on
+// error
+func createOrg(t *testing.T, r Repos, name string, owner models.User) models.Organization {
+	t.Helper()
+	org := models.Organization{Name: name}
+	err := r.Orgs.CreateOrg(context.Background(), &org, owner.ID)
+	if err != nil {
+		t.Fatalf("CreateOrg(%s) = %v", name, err)
+	}
+	return org
+}
+
+func TestOrgMembers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		bob := createUser(t, r, "bob")
+		org := createOrg(t, r, "acme", bob)
+
+		err := r.Orgs.CreateOrg(ctx, &models.Organization{Name: "acme"}, alice.ID)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("CreateOrg(same name) = %v, want ErrConflict", err)
+		}
+		got, err := r.Orgs.GetOrg(ctx, "acme")
+		if err != nil || got.ID != org.ID {
+			t.Errorf("GetOrg() = %d, %v, want %d", got.ID, err, org.ID)
+		}
+		_, err = r.Orgs.GetMembership(ctx, org.ID, alice.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMembership(non-member) = %v, want ErrNotFound", err)
+		}
+
+		membership := models.Membership{OrgID: org.ID, UserID: alice.ID, Role: models.OrgRoleViewer}
+		err = r.Orgs.SaveMembership(ctx, &membership)
+		if err != nil {
+			t.Fatalf("SaveMembership() = %v", err)
+		}
+		membership.Role = models.OrgRoleEditor
+		err = r.Orgs.SaveMembership(ctx, &membership)
+		if err != nil {
+			t.Fatalf("SaveMembership(update) = %v", err)
+		}
+
+		// the members are listed with the usernames of the users
+		members, err := r.Orgs.GetMembers(ctx, org.ID)
+		want := []models.Member{
+			{Username: "alice", Role: models.OrgRoleEditor},
+			{Username: "bob", Role: models.OrgRoleOwner},
+		}
+		if err != nil || len(members) != len(want) {
+			t.Fatalf("GetMembers() = %+v, %v, want %+v", members, err, want)
+		}
+		for i := range want {
+			if members[i].Username != want[i].Username || members[i].Role != want[i].Role {
+				t.Errorf("GetMembers()[%d] = %+v, want %+v", i, members[i], want[i])
+			}
+		}
+		workspaces, err := r.Orgs.GetWorkspaces(ctx, alice.ID)
+		if err != nil || len(workspaces) != 1 || workspaces[0].Name != "acme" || workspaces[0].Role != models.OrgRoleEditor {
+			t.Errorf("GetWorkspaces() = %+v, %v, want acme as editor", workspaces, err)
+		}
+
+		owners, err := r.Orgs.CountOwners(ctx, org.ID)
+		if err != nil || owners != 1 {
+			t.Errorf("CountOwners() = %d, %v, want 1", owners, err)
+		}
+		sole, err := r.Orgs.GetSoleOwnedOrgs(ctx, bob.ID)
+		if err != nil || len(sole) != 1 || sole[0].ID != org.ID {
+			t.Errorf("GetSoleOwnedOrgs(bob) = %+v, %v, want acme", sole, err)
+		}
+		membership.Role = models.OrgRoleOwner
+		err = r.Orgs.SaveMembership(ctx, &membership)
+		if err != nil {
+			t.Fatalf("SaveMembership(owner) = %v", err)
+		}
+		sole, err = r.Orgs.GetSoleOwnedOrgs(ctx, bob.ID)
+		if err != nil || len(sole) != 0 {
+			t.Errorf("GetSoleOwnedOrgs(bob) with two owners = %+v, %v, want none", sole, err)
+		}
+
+		err = r.Orgs.DeleteMembership(ctx, org.ID, alice.ID)
+		if err != nil {
+			t.Fatalf("DeleteMembership() = %v", err)
+		}
+		_, err = r.Orgs.GetMembership(ctx, org.ID, alice.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMembership() after DeleteMembership = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestOrgInvites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		bob := createUser(t, r, "bob")
+		org := createOrg(t, r, "acme", bob)
+		other := createOrg(t, r, "other", bob)
+
+		invites := []models.OrgInvite{
+			{OrgID: org.ID, Hash: "pending", Role: models.OrgRoleEditor, CreatedBy: "bob", ExpiresAt: time.Now().Add(time.Hour)},
+			{OrgID: org.ID, Hash: "expired", Role: models.OrgRoleEditor, CreatedBy: "bob", ExpiresAt: time.Now().Add(-time.Hour)},
+			{OrgID: org.ID, Hash: "revoked", Role: models.OrgRoleViewer, CreatedBy: "bob", ExpiresAt: time.Now().Add(time.Hour)},
+		}
+		for i := range invites {
+			err := r.Orgs.CreateOrgInvite(ctx, &invites[i])
+			if err != nil {
+				t.Fatalf("CreateOrgInvite(%s) = %v", invites[i].Hash, err)
+			}
+		}
+		err := r.Orgs.DeleteOrgInvite(ctx, other.ID, invites[2].ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteOrgInvite(other organization) = %v, want ErrNotFound", err)
+		}
+		err = r.Orgs.DeleteOrgInvite(ctx, org.ID, invites[2].ID)
+		if err != nil {
+			t.Fatalf("DeleteOrgInvite() = %v", err)
+		}
+		pending, err := r.Orgs.GetOrgInvites(ctx, org.ID)
+		if err != nil || len(pending) != 1 || pending[0].Hash != "pending" {
+			t.Errorf("GetOrgInvites() = %+v, %v, want the pending invite", pending, err)
+		}
+
+		tests := []struct {
+			orgID   uint
+			hash    string
+			wantErr error
+		}{
+			{other.ID, "pending", ErrValidation},
+			{org.ID, "expired", ErrValidation},
+			{org.ID, "revoked", ErrValidation},
+			{org.ID, "pending", nil},
+			// invites are accepted once
+			{org.ID, "pending", ErrValidation},
+		}
+		for _, tt := range tests {
+			_, err := r.Orgs.AcceptOrgInvite(ctx, tt.orgID, tt.hash, alice.ID, alice.Username)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AcceptOrgInvite(%d, %s) = %v, want %v", tt.orgID, tt.hash, err, tt.wantErr)
+			}
+		}
+		membership, err := r.Orgs.GetMembership(ctx, org.ID, alice.ID)
+		if err != nil || membership.Role != models.OrgRoleEditor {
+			t.Errorf("GetMembership() after AcceptOrgInvite = %q, %v, want editor", membership.Role, err)
+		}
+
+		// members can't accept another invite
+		again := models.OrgInvite{OrgID: org.ID, Hash: "again", Role: models.OrgRoleViewer, CreatedBy: "bob", ExpiresAt: time.Now().Add(time.Hour)}
+		err = r.Orgs.CreateOrgInvite(ctx, &again)
+		if err != nil {
+			t.Fatalf("CreateOrgInvite() = %v", err)
+		}
+		_, err = r.Orgs.AcceptOrgInvite(ctx, org.ID, "again", alice.ID, alice.Username)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("AcceptOrgInvite(member) = %v, want ErrConflict", err)
+		}
+	})
+}
+
+func TestOrgDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		bob := createUser(t, r, "bob")
+		org := createOrg(t, r, "acme", bob)
+		shared := createNote(t, r, bob, &org.ID, "shared", "")
+		personal := createNote(t, r, bob, nil, "personal", "")
+
+		err := r.Orgs.DeleteOrg(ctx, org.ID)
+		if err != nil {
+			t.Fatalf("DeleteOrg() = %v", err)
+		}
+		_, err = r.Orgs.GetOrg(ctx, "acme")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetOrg() after DeleteOrg = %v, want ErrNotFound", err)
+		}
+		_, err = r.Orgs.GetMembership(ctx, org.ID, bob.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetMembership() after DeleteOrg = %v, want ErrNotFound", err)
+		}
+		err = r.Notes.Read(ctx, &models.Note{ID: shared.ID})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read(shared) after DeleteOrg = %v, want ErrNotFound", err)
+		}
+		err = r.Notes.Read(ctx, &models.Note{ID: personal.ID})
+		if err != nil {
+			t.Errorf("Read(personal) after DeleteOrg = %v", err)
+		}
+	})
+}
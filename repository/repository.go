@@ -1,30 +1,48 @@
 package repository
 
 import (
-	"github.com/gin-gonic/gin"
+	"context"
+	"strings"
+
 	"github.com/mrinjamul/gnote/models"
 	"github.com/mrinjamul/gnote/utils"
 	"gorm.io/gorm"
 )
 
 type NoteRepo interface {
-	Create(ctx *gin.Context, note *models.Note) error
-	Read(ctx *gin.Context, note *models.Note) error
-	ReadByUserName(ctx *gin.Context, user string) ([]models.Note, error)
-	ReadAll(ctx *gin.Context) ([]models.Note, error)
-	Update(ctx *gin.Context, note models.Note) (models.Note, error)
-	Delete(ctx *gin.Context, note *models.Note) error
-	DeleteAllByUserName(ctx *gin.Context, username string) error
-	VerifyPassword(ctx *gin.Context, username, password string) (bool, error)
+	Create(ctx context.Context, note *models.Note) error
+	Read(ctx context.Context, note *models.Note) error
+	ReadByUser(ctx context.Context, userID uint) ([]models.Note, error)
+	ReadByOrg(ctx context.Context, orgID uint) ([]models.Note, error)
+	ReadInOrg(ctx context.Context, orgID uint, id uint64) (models.Note, error)
+	ReadAll(ctx context.Context) ([]models.Note, error)
+	Update(ctx context.Context, note models.Note) (models.Note, error)
+	Delete(ctx context.Context, note *models.Note) error
+	DeleteAllByUser(ctx context.Context, userID uint) error
+	UsageByUserName(ctx context.Context, username string) (models.Usage, error)
+	UsageByUser(ctx context.Context, limit int) ([]models.Usage, error)
+	VerifyPassword(ctx context.Context, username, password string) (bool, error)
 }
 
 type noteRepo struct {
 	db gorm.DB
 }
 
+// validateNote checks a note before it is saved
+func validateNote(note *models.Note) error {
+	if strings.TrimSpace(note.Title) == "" && strings.TrimSpace(note.Content) == "" {
+		return invalid("content", "a note needs a title or a content")
+	}
+	return nil
+}
+
 // Create creates a new note
-func (repo *noteRepo) Create(ctx *gin.Context, note *models.Note) error {
-	result := repo.db.Create(note)
+func (repo *noteRepo) Create(ctx context.Context, note *models.Note) error {
+	err := validateNote(note)
+	if err != nil {
+		return err
+	}
+	result := repo.db.WithContext(ctx).Create(note)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -32,28 +50,48 @@ func (repo *noteRepo) Create(ctx *gin.Context, note *models.Note) error {
 }
 
 // Read reads a note
-func (repo *noteRepo) Read(ctx *gin.Context, note *models.Note) error {
-	result := repo.db.First(&note)
+func (repo *noteRepo) Read(ctx context.Context, note *models.Note) error {
+	result := repo.db.WithContext(ctx).First(note, "id = ?", note.ID)
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
 
-// ReadByUserName reads all notes by user name
-func (repo *noteRepo) ReadByUserName(ctx *gin.Context, user string) ([]models.Note, error) {
+// ReadByUser reads all personal notes of a user
+func (repo *noteRepo) ReadByUser(ctx context.Context, userID uint) ([]models.Note, error) {
+	var notes []models.Note
+	result := repo.db.WithContext(ctx).Find(&notes, "user_id = ? AND org_id IS NULL", userID)
+	if result.Error != nil {
+		return notes, result.Error
+	}
+	return notes, nil
+}
+
+// ReadByOrg reads all notes of an organization
+func (repo *noteRepo) ReadByOrg(ctx context.Context, orgID uint) ([]models.Note, error) {
 	var notes []models.Note
-	result := repo.db.Find(&notes, "username = ?", user)
+	result := repo.db.WithContext(ctx).Find(&notes, "org_id = ?", orgID)
 	if result.Error != nil {
 		return notes, result.Error
 	}
 	return notes, nil
 }
 
+// ReadInOrg reads a note of an organization
+func (repo *noteRepo) ReadInOrg(ctx context.Context, orgID uint, id uint64) (models.Note, error) {
+	var note models.Note
+	result := repo.db.WithContext(ctx).First(&note, "id = ? AND org_id = ?", id, orgID)
+	if result.Error != nil {
+		return note, result.Error
+	}
+	return note, nil
+}
+
 // ReadAll reads all notes
-func (repo *noteRepo) ReadAll(ctx *gin.Context) ([]models.Note, error) {
+func (repo *noteRepo) ReadAll(ctx context.Context) ([]models.Note, error) {
 	var notes []models.Note
-	result := repo.db.Find(&notes)
+	result := repo.db.WithContext(ctx).Find(&notes)
 	if result.Error != nil {
 		return notes, result.Error
 	}
@@ -61,9 +99,13 @@ func (repo *noteRepo) ReadAll(ctx *gin.Context) ([]models.Note, error) {
 }
 
 // Update updates a note
-func (repo *noteRepo) Update(ctx *gin.Context, note models.Note) (models.Note, error) {
+func (repo *noteRepo) Update(ctx context.Context, note models.Note) (models.Note, error) {
+	err := validateNote(&note)
+	if err != nil {
+		return note, err
+	}
 	// Save notes
-	err := repo.db.Save(&note).Error
+	err = repo.db.WithContext(ctx).Save(&note).Error
 	if err != nil {
 		return note, err
 	}
@@ -71,23 +113,23 @@ func (repo *noteRepo) Update(ctx *gin.Context, note models.Note) (models.Note, e
 }
 
 // Delete deletes a note
-func (repo *noteRepo) Delete(ctx *gin.Context, note *models.Note) error {
-	result := repo.db.Delete(note)
+func (repo *noteRepo) Delete(ctx context.Context, note *models.Note) error {
+	result := repo.db.WithContext(ctx).Delete(note)
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
 
-// 	DeleteAllByUserName deletes all notes by user name
-func (repo noteRepo) DeleteAllByUserName(ctx *gin.Context, username string) error {
+// DeleteAllByUser deletes all personal notes of a user
+func (repo noteRepo) DeleteAllByUser(ctx context.Context, userID uint) error {
 	var notes []models.Note
-	notes, err := repo.ReadByUserName(ctx, username)
+	notes, err := repo.ReadByUser(ctx, userID)
 	if err != nil {
 		return err
 	}
 	for _, note := range notes {
-		err = repo.db.Delete(&note).Error
+		err = repo.db.WithContext(ctx).Delete(&note).Error
 		if err != nil {
 			return err
 		}
@@ -95,10 +137,50 @@ func (repo noteRepo) DeleteAllByUserName(ctx *gin.Context, username string) erro
 	return nil
 }
 
+// UsageByUserName returns the storage used by the notes of a user
+func (repo *noteRepo) UsageByUserName(ctx context.Context, username string) (models.Usage, error) {
+	var usage models.Usage
+	err := repo.db.WithContext(ctx).
+		Model(&models.Note{}).
+		Select("COUNT(*) AS notes, COALESCE(SUM("+repo.octetLength("title")+" + "+repo.octetLength("content")+"), 0) AS bytes").
+		Where("user_id = (?)", repo.db.Model(&models.User{}).Select("id").Where("username = ?", username)).
+		Scan(&usage).Error
+	if err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// UsageByUser returns the storage used by each user, largest first
+func (repo *noteRepo) UsageByUser(ctx context.Context, limit int) ([]models.Usage, error) {
+	var usage []models.Usage
+	err := repo.db.WithContext(ctx).
+		Model(&models.Note{}).
+		Select("users.username, COUNT(*) AS notes, COALESCE(SUM(" + repo.octetLength("notes.title") + " + " + repo.octetLength("notes.content") + "), 0) AS bytes").
+		Joins("JOIN users ON users.id = notes.user_id").
+		Group("users.username").
+		Order("bytes DESC").
+		Limit(limit).
+		Scan(&usage).Error
+	if err != nil {
+		return usage, err
+	}
+	return usage, nil
+}
+
+// octetLength returns the SQL size in bytes of a text column, SQLite has no
+// OCTET_LENGTH but counts the bytes of blobs
+func (repo *noteRepo) octetLength(column string) string {
+	if repo.db.Dialector.Name() == "sqlite" {
+		return "LENGTH(CAST(" + column + " AS BLOB))"
+	}
+	return "OCTET_LENGTH(" + column + ")"
+}
+
 // VerifyPassword verifies the password
-func (repo *noteRepo) VerifyPassword(ctx *gin.Context, username, password string) (bool, error) {
+func (repo *noteRepo) VerifyPassword(ctx context.Context, username, password string) (bool, error) {
 	var user models.User
-	err := repo.db.Find(&user, "username = ?", username).Error
+	err := repo.db.WithContext(ctx).Find(&user, "username = ?", username).Error
 	ok := utils.VerifyHash(password, user.Password)
 	if err != nil {
 		return false, err
@@ -112,3 +194,25 @@ func NewNoteRepo(db *gorm.DB) NoteRepo {
 		db: *db,
 	}
 }
+
+// Repos are the repositories of one store
+type Repos struct {
+	Notes      NoteRepo
+	Users      UserRepo
+	Tokens     TokenRepo
+	Invites    InviteRepo
+	Identities IdentityRepo
+	Orgs       OrgRepo
+}
+
+// NewRepos initializes the repositories on the database
+func NewRepos(db *gorm.DB) Repos {
+	return Repos{
+		Notes:      NewNoteRepo(db),
+		Users:      NewUserRepo(db),
+		Tokens:     NewTokenRepo(db),
+		Invites:    NewInviteRepo(db),
+		Identities: NewIdentityRepo(db),
+		Orgs:       NewOrgRepo(db),
+	}
+}
@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrinjamul/gnote/database"
+	"github.com/mrinjamul/gnote/models"
+)
+
+// stores are the stores the repositories are tested on, each test runs on
+// all of them so that the memory repositories behave like the GORM ones
+var stores = []struct {
+	name string
+	open func(t *testing.T) Repos
+}{
+	{"memory", openMemory},
+	{"sqlite", openSQLite},
+}
+
+// openMemory opens the repositories kept in memory
+func openMemory(t *testing.T) Repos {
+	return NewMemoryRepos()
+}
+
+// openSQLite opens the GORM repositories on a migrated SQLite database kept
+// in memory
+func openSQLite(t *testing.T) Repos {
+	t.Helper()
+	db, err := database.GetMemoryDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRepos(db)
+}
+
+// forEachStore runs the test on a fresh store of each kind
+func forEachStore(t *testing.T, test func(t *testing.T, r Repos)) {
+	for _, store := range stores {
+		t.Run(store.name, func(t *testing.T) {
+			test(t, store.open(t))
+		})
+	}
+}
+
+// createUser creates a user with the username, failing the test on error
+func createUser(t *testing.T, r Repos, username string) models.User {
+	t.Helper()
+	user := models.User{Username: username, Email: username + "@example.com", Role: "user", Level: 1}
+	err := r.Users.CreateUser(context.Background(), &user)
+	if err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	return user
+}
+
+// createNote creates a note of the user, in the organization unless nil
+func createNote(t *testing.T, r Repos, user models.User, orgID *uint, title, content string) models.Note {
+	t.Helper()
+	note := models.Note{Title: title, Content: content, UserID: &user.ID, Username: user.Username, OrgID: orgID}
+	err := r.Notes.Create(context.Background(), &note)
+	if err != nil {
+		t.Fatalf("Create(%s): %v", title, err)
+	}
+	return note
+}
+
+// noteIDs returns the ids of the notes
+func noteIDs(notes []models.Note) []uint64 {
+	ids := []uint64{}
+	for _, note := range notes {
+		ids = append(ids, note.ID)
+	}
+	return ids
+}
+
+func equalIDs(got, want []uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNoteCreateValidates(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		user := createUser(t, r, "alice")
+		tests := []struct {
+			name    string
+			title   string
+			content string
+			wantErr error
+		}{
+			{"title", "title", "", nil},
+			{"content", "", "content", nil},
+			{"empty", "", "", ErrValidation},
+			{"blank", "  ", "\n", ErrValidation},
+		}
+		for _, tt := range tests {
+			note := models.Note{Title: tt.title, Content: tt.content, UserID: &user.ID}
+			err := r.Notes.Create(context.Background(), &note)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: Create() = %v, want %v", tt.name, err, tt.wantErr)
+			}
+			if tt.wantErr == nil && note.ID == 0 {
+				t.Errorf("%s: Create() didn't set the id", tt.name)
+			}
+		}
+	})
+}
+
+func TestNoteReads(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		bob := createUser(t, r, "bob")
+		orgID, otherOrgID := uint(7), uint(8)
+		first := createNote(t, r, alice, nil, "first", "")
+		second := createNote(t, r, alice, nil, "second", "")
+		shared := createNote(t, r, alice, &orgID, "shared", "")
+		createNote(t, r, bob, nil, "bob", "")
+
+		note := models.Note{ID: second.ID}
+		err := r.Notes.Read(ctx, &note)
+		if err != nil || note.Title != "second" {
+			t.Errorf("Read() = %q, %v, want second", note.Title, err)
+		}
+		err = r.Notes.Read(ctx, &models.Note{ID: 1000})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read(missing) = %v, want ErrNotFound", err)
+		}
+
+		// the personal notes leave out the ones of organizations
+		notes, err := r.Notes.ReadByUser(ctx, alice.ID)
+		if err != nil || !equalIDs(noteIDs(notes), []uint64{first.ID, second.ID}) {
+			t.Errorf("ReadByUser() = %v, %v, want %v", noteIDs(notes), err, []uint64{first.ID, second.ID})
+		}
+		notes, err = r.Notes.ReadByOrg(ctx, orgID)
+		if err != nil || !equalIDs(noteIDs(notes), []uint64{shared.ID}) {
+			t.Errorf("ReadByOrg() = %v, %v, want %v", noteIDs(notes), err, []uint64{shared.ID})
+		}
+
+		note, err = r.Notes.ReadInOrg(ctx, orgID, shared.ID)
+		if err != nil || note.ID != shared.ID {
+			t.Errorf("ReadInOrg() = %d, %v, want %d", note.ID, err, shared.ID)
+		}
+		for _, tt := range []struct {
+			orgID uint
+			id    uint64
+		}{
+			{otherOrgID, shared.ID},
+			{orgID, first.ID},
+		} {
+			_, err = r.Notes.ReadInOrg(ctx, tt.orgID, tt.id)
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("ReadInOrg(%d, %d) = %v, want ErrNotFound", tt.orgID, tt.id, err)
+			}
+		}
+
+		notes, err = r.Notes.ReadAll(ctx)
+		if err != nil || len(notes) != 4 {
+			t.Errorf("ReadAll() = %d notes, %v, want 4", len(notes), err)
+		}
+	})
+}
+
+func TestNoteUpdateAndDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		orgID := uint(7)
+		note := createNote(t, r, alice, nil, "title", "content")
+		createNote(t, r, alice, nil, "other", "")
+		shared := createNote(t, r, alice, &orgID, "shared", "")
+
+		note.Title = "changed"
+		updated, err := r.Notes.Update(ctx, note)
+		if err != nil || updated.Title != "changed" {
+			t.Fatalf("Update() = %q, %v, want changed", updated.Title, err)
+		}
+		stored := models.Note{ID: note.ID}
+		err = r.Notes.Read(ctx, &stored)
+		if err != nil || stored.Title != "changed" || stored.Content != "content" {
+			t.Errorf("Read() after Update = %q %q, %v", stored.Title, stored.Content, err)
+		}
+		note.Title, note.Content = "", ""
+		_, err = r.Notes.Update(ctx, note)
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf("Update(empty) = %v, want ErrValidation", err)
+		}
+
+		err = r.Notes.Delete(ctx, &stored)
+		if err != nil {
+			t.Fatalf("Delete() = %v", err)
+		}
+		err = r.Notes.Read(ctx, &models.Note{ID: note.ID})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read() after Delete = %v, want ErrNotFound", err)
+		}
+
+		// the notes shared in organizations are kept
+		err = r.Notes.DeleteAllByUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("DeleteAllByUser() = %v", err)
+		}
+		notes, err := r.Notes.ReadAll(ctx)
+		if err != nil || !equalIDs(noteIDs(notes), []uint64{shared.ID}) {
+			t.Errorf("ReadAll() after DeleteAllByUser = %v, %v, want %v", noteIDs(notes), err, []uint64{shared.ID})
+		}
+	})
+}
+
+func TestNoteUsage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		bob := createUser(t, r, "bob")
+		createUser(t, r, "carol")
+		// the sizes are in bytes, not in characters
+		createNote(t, r, alice, nil, "héllo", "wörld")
+		createNote(t, r, alice, nil, "a", "")
+		createNote(t, r, bob, nil, "abc", "de")
+
+		tests := []struct {
+			username string
+			want     models.Usage
+		}{
+			{"alice", models.Usage{Notes: 2, Bytes: 13}},
+			{"bob", models.Usage{Notes: 1, Bytes: 5}},
+			{"carol", models.Usage{}},
+			{"nobody", models.Usage{}},
+		}
+		for _, tt := range tests {
+			got, err := r.Notes.UsageByUserName(ctx, tt.username)
+			if err != nil || got != tt.want {
+				t.Errorf("UsageByUserName(%s) = %+v, %v, want %+v", tt.username, got, err, tt.want)
+			}
+		}
+
+		usage, err := r.Notes.UsageByUser(ctx, 10)
+		want := []models.Usage{
+			{Username: "alice", Notes: 2, Bytes: 13},
+			{Username: "bob", Notes: 1, Bytes: 5},
+		}
+		if err != nil || len(usage) != len(want) {
+			t.Fatalf("UsageByUser() = %+v, %v, want %+v", usage, err, want)
+		}
+		for i := range want {
+			if usage[i] != want[i] {
+				t.Errorf("UsageByUser()[%d] = %+v, want %+v", i, usage[i], want[i])
+			}
+		}
+		usage, err = r.Notes.UsageByUser(ctx, 1)
+		if err != nil || len(usage) != 1 || usage[0].Username != "alice" {
+			t.Errorf("UsageByUser(1) = %+v, %v, want alice only", usage, err)
+		}
+	})
+}
+
+func TestUserCreateConflicts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		createUser(t, r, "alice")
+		tests := []struct {
+			name string
+			user models.User
+		}{
+			{"username", models.User{Username: "alice", Email: "other@example.com"}},
+			{"email", models.User{Username: "other", Email: "alice@example.com"}},
+		}
+		for _, tt := range tests {
+			err := r.Users.CreateUser(ctx, &tt.user)
+			if !errors.Is(err, ErrConflict) {
+				t.Errorf("CreateUser(same %s) = %v, want ErrConflict", tt.name, err)
+			}
+		}
+
+		user, err := r.Users.GetUserByUsername(ctx, "alice")
+		if err != nil || user.Email != "alice@example.com" {
+			t.Errorf("GetUserByUsername() = %q, %v", user.Email, err)
+		}
+		_, err = r.Users.GetUserByEmail(ctx, "nobody@example.com")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUserByEmail(missing) = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestUserSearch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		createUser(t, r, "alice")
+		createUser(t, r, "alina")
+		createUser(t, r, "bob")
+
+		tests := []struct {
+			query string
+			want  int64
+		}{
+			{"", 3},
+			{"ALI", 2},
+			{"bob", 1},
+			// the wildcards of LIKE are matched literally
+			{"%", 0},
+			{"_", 0},
+		}
+		for _, tt := range tests {
+			users, total, err := r.Users.SearchUsers(ctx, tt.query, 0, 10)
+			if err != nil || total != tt.want || int64(len(users)) != tt.want {
+				t.Errorf("SearchUsers(%q) = %d users, total %d, %v, want %d", tt.query, len(users), total, err, tt.want)
+			}
+		}
+
+		users, total, err := r.Users.SearchUsers(ctx, "", 1, 1)
+		if err != nil || total != 3 || len(users) != 1 || users[0].Username != "alina" {
+			t.Errorf("SearchUsers(page 2) = %v, total %d, %v, want alina of 3", users, total, err)
+		}
+	})
+}
+
+func TestUserChangeUsername(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		createUser(t, r, "bob")
+		note := createNote(t, r, alice, nil, "note", "")
+		until := time.Now().Add(time.Hour)
+
+		err := r.Users.ChangeUsername(ctx, alice.ID, "bob", until)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("ChangeUsername(taken) = %v, want ErrConflict", err)
+		}
+		err = r.Users.ChangeUsername(ctx, alice.ID, "alicia", until)
+		if err != nil {
+			t.Fatalf("ChangeUsername() = %v", err)
+		}
+
+		stored := models.Note{ID: note.ID}
+		err = r.Notes.Read(context.Background(), &stored)
+		if err != nil || stored.Username != "alicia" {
+			t.Errorf("note username = %q, %v, want alicia", stored.Username, err)
+		}
+		redirect, err := r.Users.GetUsernameRedirect(ctx, "alice")
+		if err != nil || redirect.UserID != alice.ID {
+			t.Errorf("GetUsernameRedirect() = %d, %v, want %d", redirect.UserID, err, alice.ID)
+		}
+		// the old username is reserved while it redirects
+		err = r.Users.CreateUser(ctx, &models.User{Username: "alice", Email: "new@example.com"})
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("CreateUser(redirecting username) = %v, want ErrConflict", err)
+		}
+		for username, want := range map[string]bool{"alice": false, "alicia": false, "bob": false, "carol": true} {
+			available, err := r.Users.UsernameAvailable(ctx, username)
+			if err != nil || available != want {
+				t.Errorf("UsernameAvailable(%s) = %v, %v, want %v", username, available, err, want)
+			}
+		}
+	})
+}
+
+func TestUserDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		orgID := uint(7)
+		personal := createNote(t, r, alice, nil, "personal", "")
+		shared := createNote(t, r, alice, &orgID, "shared", "")
+
+		err := r.Users.DeleteUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("DeleteUser() = %v", err)
+		}
+		_, err = r.Users.GetUser(ctx, int(alice.ID))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetUser() after DeleteUser = %v, want ErrNotFound", err)
+		}
+		err = r.Notes.Read(ctx, &models.Note{ID: personal.ID})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read(personal) after DeleteUser = %v, want ErrNotFound", err)
+		}
+		// the notes shared in organizations stay without their author
+		stored := models.Note{ID: shared.ID}
+		err = r.Notes.Read(ctx, &stored)
+		if err != nil || stored.UserID != nil || stored.Username != "" {
+			t.Errorf("Read(shared) after DeleteUser = %v %q, %v, want no author", stored.UserID, stored.Username, err)
+		}
+		err = r.Users.DeleteUser(ctx, alice.ID)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteUser(again) = %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+	"gorm.io/gorm"
+)
+
+// TokenRepo is a repository for single-use user tokens
+type TokenRepo interface {
+	// CreateToken stores a new token
+	CreateToken(ctx context.Context, token *models.UserToken) error
+	// GetToken returns an unused, unexpired token by hash and purpose
+	GetToken(ctx context.Context, hash, purpose string) (models.UserToken, error)
+	// UseToken marks a token as used, it fails if the token was already used
+	UseToken(ctx context.Context, id uint) error
+	// DeleteTokens deletes all tokens of a user for a purpose
+	DeleteTokens(ctx context.Context, userID uint, purpose string) error
+}
+
+// tokenRepo is a repository for single-use user tokens
+type tokenRepo struct {
+	db gorm.DB
+}
+
+// CreateToken stores a new token
+func (t *tokenRepo) CreateToken(ctx context.Context, token *models.UserToken) error {
+	err := t.db.WithContext(ctx).Create(token).Error
+	if err != nil {
+		return duplicate(err, "token already exists")
+	}
+	return nil
+}
+
+// GetToken returns an unused, unexpired token by hash and purpose
+func (t *tokenRepo) GetToken(ctx context.Context, hash, purpose string) (models.UserToken, error) {
+	var token models.UserToken
+	err := t.db.WithContext(ctx).
+		Where("hash = ? AND purpose = ?", hash, purpose).
+		Where("used_at IS NULL AND expires_at > ?", time.Now()).
+		First(&token).Error
+	if err != nil {
+		return models.UserToken{}, err
+	}
+	return token, nil
+}
+
+// UseToken marks a token as used, it fails if the token was already used
+func (t *tokenRepo) UseToken(ctx context.Context, id uint) error {
+	result := t.db.WithContext(ctx).
+		Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return conflict("token already used")
+	}
+	return nil
+}
+
+// DeleteTokens deletes all tokens of a user for a purpose
+func (t *tokenRepo) DeleteTokens(ctx context.Context, userID uint, purpose string) error {
+	err := t.db.WithContext(ctx).
+		Where("user_id = ? AND purpose = ?", userID, purpose).
+		Delete(&models.UserToken{}).Error
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// NewTokenRepo initializes a new token repository
+func NewTokenRepo(db *gorm.DB) TokenRepo {
+	return &tokenRepo{
+		db: *db,
+	}
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mrinjamul/gnote/models"
+)
+
+func TestTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, r Repos) {
+		ctx := context.Background()
+		alice := createUser(t, r, "alice")
+		tokens := []models.UserToken{
+			{UserID: alice.ID, Purpose: "reset", Hash: "valid", ExpiresAt: time.Now().Add(time.Hour)},
+			{UserID: alice.ID, Purpose: "reset", Hash: "expired", ExpiresAt: time.Now().Add(-time.Hour)},
+			{UserID: alice.ID, Purpose: "verify", Hash: "other", ExpiresAt: time.Now().Add(time.Hour)},
+		}
+		for i := range tokens {
+			err := r.Tokens.CreateToken(ctx, &tokens[i])
+			if err != nil {
+				t.Fatalf("CreateToken(%s) = %v", tokens[i].Hash, err)
+			}
+		}
+
+		tests := []struct {
+			hash    string
+			purpose string
+			wantErr error
+		}{
+			{"valid", "reset", nil},
+			{"valid", "verify", ErrNotFound},
+			{"expired", "reset", ErrNotFound},
+			{"missing", "reset", ErrNotFound},
+		}
+		for _, tt := range tests {
+			_, err := r.Tokens.GetToken(ctx, tt.hash, tt.purpose)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("GetToken(%s, %s) = %v, want %v", tt.hash, tt.purpose, err, tt.wantErr)
+			}
+		}
+
+		// tokens are used once
+		err := r.Tokens.UseToken(ctx, tokens[0].ID)
+		if err != nil {
+			t.Fatalf("UseToken() = %v", err)
+		}
+		err = r.Tokens.UseToken(ctx, tokens[0].ID)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("UseToken(again) = %v, want ErrConflict", err)
+		}
+		_, err = r.Tokens.GetToken(ctx, "valid", "reset")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetToken(used) = %v, want ErrNotFound", err)
+		}
+
+		err = r.Tokens.DeleteTokens(ctx, alice.ID, "verify")
+		if err != nil {
+			t.Fatalf("DeleteTokens() = %v", err)
+		}
+		_, err = r.Tokens.GetToken(ctx, "other", "verify")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetToken(deleted) = %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -1,9 +1,8 @@
 package repository
 
 import (
-	"database/sql"
-	"errors"
-	"strconv"
+	"context"
+	"strings"
 	"time"
 
 	"github.com/mrinjamul/gnote/models"
@@ -13,19 +12,39 @@ import (
 // UserRepo is a repository for users
 type UserRepo interface {
 	// CreateUser creates a new user
-	CreateUser(user *models.User) error
+	CreateUser(ctx context.Context, user *models.User) error
 	// GetUserByID returns a user by id
-	GetUser(id int) (models.User, error)
+	GetUser(ctx context.Context, id int) (models.User, error)
 	// GetUsers returns all users
-	GetUsers() ([]models.User, error)
+	GetUsers(ctx context.Context) ([]models.User, error)
+	// SearchUsers returns a page of users matching the query and the total count
+	SearchUsers(ctx context.Context, query string, offset, limit int) ([]models.User, int64, error)
+	// AdminExists checks if there is at least one admin
+	AdminExists(ctx context.Context) (bool, error)
+	// RecentUsers returns the users created since the given time, newest first
+	RecentUsers(ctx context.Context, since time.Time, limit int) ([]models.User, error)
 	// GetUserByUsername returns a user by username
-	GetUserByUsername(username string) (models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (models.User, error)
 	// GetUserByEmail returns a user by email
-	GetUserByEmail(email string) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
 	// UpdateUser updates an existing user
-	UpdateUser(user *models.User) error
-	// DeleteUser deletes an existing user
-	DeleteUser(id uint) error
+	UpdateUser(ctx context.Context, user *models.User) error
+	// UpdatePassword replaces the password hash of a user
+	UpdatePassword(ctx context.Context, id uint, hash string) error
+	// ScheduleDeletion schedules the deletion of a user at the given time
+	ScheduleDeletion(ctx context.Context, id uint, at time.Time) error
+	// RestoreUser cancels the scheduled deletion of a user
+	RestoreUser(ctx context.Context, id uint) error
+	// GetUsersDueForDeletion returns the users whose deletion is due
+	GetUsersDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error)
+	// ChangeUsername renames a user, the old username redirects to it until the given time
+	ChangeUsername(ctx context.Context, id uint, username string, redirectUntil time.Time) error
+	// GetUsernameRedirect returns the active redirect of an old username
+	GetUsernameRedirect(ctx context.Context, username string) (models.UsernameRedirect, error)
+	// UsernameAvailable checks if the username is neither taken nor reserved by a redirect
+	UsernameAvailable(ctx context.Context, username string) (bool, error)
+	// DeleteUser deletes a user with its notes, tokens, identities and memberships
+	DeleteUser(ctx context.Context, id uint) error
 }
 
 // userRepo is a repository for users
@@ -34,10 +53,10 @@ type userRepo struct {
 }
 
 // CreateUser creates a new user
-func (u *userRepo) CreateUser(user *models.User) error {
+func (u *userRepo) CreateUser(ctx context.Context, user *models.User) error {
 	// check if user already exists
 	var exists bool
-	err := u.db.
+	err := u.db.WithContext(ctx).
 		Model(models.User{}).
 		Select("count(*) > 0").
 		Where("username = ?", user.Username).
@@ -46,9 +65,9 @@ func (u *userRepo) CreateUser(user *models.User) error {
 		return err
 	}
 	if exists {
-		return errors.New("user already exists")
+		return conflict("user already exists")
 	}
-	err = u.db.
+	err = u.db.WithContext(ctx).
 		Model(models.User{}).
 		Select("count(*) > 0").
 		Where("email = ?", user.Email).
@@ -57,21 +76,32 @@ func (u *userRepo) CreateUser(user *models.User) error {
 		return err
 	}
 	if exists {
-		return errors.New("user already exists")
+		return conflict("user already exists")
 	}
-
-	// create user
-	err = u.db.Create(&user).Error
+	// old usernames of renamed users are reserved while they redirect
+	reserved, err := u.usernameReserved(u.db.WithContext(ctx), user.Username, 0)
 	if err != nil {
 		return err
 	}
-	return nil
+	if reserved {
+		return conflict("user already exists")
+	}
+
+	// create user, dropping the notes left behind by a former owner of the
+	// username so that they are not inherited
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("username = ? AND user_id IS NULL AND org_id IS NULL", user.Username).Delete(&models.Note{}).Error
+		if err != nil {
+			return err
+		}
+		return duplicate(tx.Create(&user).Error, "user already exists")
+	})
 }
 
 // GetUser returns a user by id
-func (u *userRepo) GetUser(id int) (models.User, error) {
+func (u *userRepo) GetUser(ctx context.Context, id int) (models.User, error) {
 	var user models.User
-	err := u.db.Where("id = ?", id).First(&user).Error
+	err := u.db.WithContext(ctx).Where("id = ?", id).First(&user).Error
 	if err != nil {
 		return models.User{}, err
 	}
@@ -79,9 +109,69 @@ func (u *userRepo) GetUser(id int) (models.User, error) {
 }
 
 // GetUsers returns all users
-func (u *userRepo) GetUsers() ([]models.User, error) {
+func (u *userRepo) GetUsers(ctx context.Context) ([]models.User, error) {
 	var users []models.User
-	err := u.db.Find(&users).Error
+	err := u.db.WithContext(ctx).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// AdminExists checks if there is at least one admin
+func (u *userRepo) AdminExists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := u.db.WithContext(ctx).
+		Model(models.User{}).
+		Select("count(*) > 0").
+		Where("role = ?", "admin").
+		Find(&exists).Error
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SearchUsers returns a page of users matching the query and the total count
+func (u *userRepo) SearchUsers(ctx context.Context, query string, offset, limit int) ([]models.User, int64, error) {
+	var users []models.User
+	var total int64
+	db := u.db.WithContext(ctx).Model(&models.User{})
+	if query != "" {
+		pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+		db = db.Where(
+			`LOWER(username) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\' OR `+
+				`LOWER(first_name) LIKE ? ESCAPE '\' OR LOWER(last_name) LIKE ? ESCAPE '\'`,
+			pattern, pattern, pattern, pattern,
+		)
+	}
+	err := db.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = db.Order("id").Offset(offset).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, with \ as escape
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike returns the text matched literally in a LIKE pattern
+func escapeLike(text string) string {
+	return likeEscaper.Replace(text)
+}
+
+// RecentUsers returns the users created since the given time, newest first
+func (u *userRepo) RecentUsers(ctx context.Context, since time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := u.db.WithContext(ctx).
+		Where("created_at >= ?", since).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&users).Error
 	if err != nil {
 		return nil, err
 	}
@@ -89,9 +179,9 @@ func (u *userRepo) GetUsers() ([]models.User, error) {
 }
 
 // GetUserByUsername returns a user by username
-func (u *userRepo) GetUserByUsername(username string) (models.User, error) {
+func (u *userRepo) GetUserByUsername(ctx context.Context, username string) (models.User, error) {
 	var user models.User
-	err := u.db.Where("username = ?", username).First(&user).Error
+	err := u.db.WithContext(ctx).Where("username = ?", username).First(&user).Error
 	if err != nil {
 		return models.User{}, err
 	}
@@ -99,9 +189,9 @@ func (u *userRepo) GetUserByUsername(username string) (models.User, error) {
 }
 
 // GetUserByEmail returns a user by email
-func (u *userRepo) GetUserByEmail(email string) (models.User, error) {
+func (u *userRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
 	var user models.User
-	err := u.db.Where("email = ?", email).First(&user).Error
+	err := u.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
 	if err != nil {
 		return models.User{}, err
 	}
@@ -109,34 +199,207 @@ func (u *userRepo) GetUserByEmail(email string) (models.User, error) {
 }
 
 // UpdateUser updates an existing user
-func (u *userRepo) UpdateUser(user *models.User) error {
-	err := u.db.Save(&user).Error
+func (u *userRepo) UpdateUser(ctx context.Context, user *models.User) error {
+	err := u.db.WithContext(ctx).Save(&user).Error
+	if err != nil {
+		return duplicate(err, "user already exists")
+	}
+	return nil
+}
+
+// UpdatePassword replaces the password hash of a user
+func (u *userRepo) UpdatePassword(ctx context.Context, id uint, hash string) error {
+	err := u.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("password", hash).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// DeleteUser deletes an existing user
-func (u *userRepo) DeleteUser(id uint) error {
-	// int to string conversion
-	idStr := strconv.Itoa(int(id))
-	user := models.User{
-		ID:       id,
-		Username: idStr,
-		Email:    idStr + "@localhost",
-		DeletedAt: sql.NullTime{
-			Time:  time.Now(),
-			Valid: true,
-		},
+// ScheduleDeletion schedules the deletion of a user at the given time
+func (u *userRepo) ScheduleDeletion(ctx context.Context, id uint, at time.Time) error {
+	err := u.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("deletion_due_at", at).Error
+	if err != nil {
+		return err
 	}
-	err := u.db.Save(&user).Error
+	return nil
+}
+
+// RestoreUser cancels the scheduled deletion of a user
+func (u *userRepo) RestoreUser(ctx context.Context, id uint) error {
+	err := u.db.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("deletion_due_at", nil).Error
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// GetUsersDueForDeletion returns the users whose deletion is due
+func (u *userRepo) GetUsersDueForDeletion(ctx context.Context, now time.Time) ([]models.User, error) {
+	var users []models.User
+	err := u.db.WithContext(ctx).
+		Where("deletion_due_at IS NOT NULL AND deletion_due_at <= ?", now).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ChangeUsername renames a user along with the author name of its notes,
+// the old username redirects to the user until the given time
+func (u *userRepo) ChangeUsername(ctx context.Context, id uint, username string, redirectUntil time.Time) error {
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Where("id = ?", id).First(&user).Error
+		if err != nil {
+			return err
+		}
+		var exists bool
+		err = tx.
+			Model(models.User{}).
+			Select("count(*) > 0").
+			Where("username = ?", username).
+			Find(&exists).Error
+		if err != nil {
+			return err
+		}
+		if exists {
+			return conflict("username is already taken")
+		}
+		reserved, err := u.usernameReserved(tx, username, id)
+		if err != nil {
+			return err
+		}
+		if reserved {
+			return conflict("username is already taken")
+		}
+		// a former username of the user or an expired redirect is reused
+		err = tx.Where("username = ?", username).Delete(&models.UsernameRedirect{}).Error
+		if err != nil {
+			return err
+		}
+		oldUsername := user.Username
+		err = tx.Model(&user).Update("username", username).Error
+		if err != nil {
+			return duplicate(err, "username is already taken")
+		}
+		err = tx.
+			Model(&models.Note{}).
+			Where("user_id = ?", id).
+			Update("username", username).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.UsernameRedirect{
+			Username:  oldUsername,
+			UserID:    id,
+			ExpiresAt: redirectUntil,
+		}).Error
+	})
+}
+
+// GetUsernameRedirect returns the active redirect of an old username
+func (u *userRepo) GetUsernameRedirect(ctx context.Context, username string) (models.UsernameRedirect, error) {
+	var redirect models.UsernameRedirect
+	err := u.db.WithContext(ctx).
+		Where("username = ? AND expires_at > ?", username, time.Now()).
+		First(&redirect).Error
+	if err != nil {
+		return models.UsernameRedirect{}, err
+	}
+	return redirect, nil
+}
+
+// UsernameAvailable checks if the username is neither taken nor reserved by
+// a redirect, the deleted users keep their username
+func (u *userRepo) UsernameAvailable(ctx context.Context, username string) (bool, error) {
+	db := u.db.WithContext(ctx)
+	var taken bool
+	err := db.Unscoped().
+		Model(models.User{}).
+		Select("count(*) > 0").
+		Where("username = ?", username).
+		Find(&taken).Error
+	if err != nil {
+		return false, err
+	}
+	if taken {
+		return false, nil
+	}
+	reserved, err := u.usernameReserved(db, username, 0)
+	if err != nil {
+		return false, err
+	}
+	return !reserved, nil
+}
+
+// usernameReserved checks if the username still redirects to another user
+// than the given one
+func (u *userRepo) usernameReserved(db *gorm.DB, username string, id uint) (bool, error) {
+	var reserved bool
+	err := db.
+		Model(models.UsernameRedirect{}).
+		Select("count(*) > 0").
+		Where("username = ? AND user_id <> ? AND expires_at > ?", username, id, time.Now()).
+		Find(&reserved).Error
+	if err != nil {
+		return false, err
+	}
+	return reserved, nil
+}
+
+// DeleteUser deletes a user with its notes, tokens, identities and
+// memberships in one transaction. The row is removed so the username can be
+// registered again without inheriting anything.
+func (u *userRepo) DeleteUser(ctx context.Context, id uint) error {
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Where("id = ?", id).First(&user).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ? AND org_id IS NULL", id).Delete(&models.Note{}).Error
+		if err != nil {
+			return err
+		}
+		// notes shared in organizations stay, without their author
+		err = tx.
+			Model(&models.Note{}).
+			Where("user_id = ? AND org_id IS NOT NULL", id).
+			Updates(map[string]interface{}{"user_id": nil, "username": ""}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&models.UserToken{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&models.Identity{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&models.Membership{}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", id).Delete(&models.UsernameRedirect{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, id).Error
+	})
+}
+
 // NewUserRepo initializes a new user repository
 func NewUserRepo(db *gorm.DB) UserRepo {
 	return &userRepo{
@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// spanKey holds the span of a query in its statement
+const spanKey = "tracing:span"
+
+// GORMPlugin starts a span for each query of a database, in the trace of the
+// context given with WithContext
+type GORMPlugin struct{}
+
+// Name names the plugin for gorm
+func (GORMPlugin) Name() string {
+	return "tracing"
+}
+
+// Initialize registers the callbacks starting and ending the spans
+func (GORMPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, op := range []struct {
+		name   string
+		before func(string, func(*gorm.DB)) error
+		after  func(string, func(*gorm.DB)) error
+	}{
+		{"create", cb.Create().Before("gorm:create").Register, cb.Create().After("gorm:create").Register},
+		{"query", cb.Query().Before("gorm:query").Register, cb.Query().After("gorm:query").Register},
+		{"update", cb.Update().Before("gorm:update").Register, cb.Update().After("gorm:update").Register},
+		{"delete", cb.Delete().Before("gorm:delete").Register, cb.Delete().After("gorm:delete").Register},
+		{"row", cb.Row().Before("gorm:row").Register, cb.Row().After("gorm:row").Register},
+		{"raw", cb.Raw().Before("gorm:raw").Register, cb.Raw().After("gorm:raw").Register},
+	} {
+		err := op.before("tracing:before_"+op.name, startSpan(op.name))
+		if err != nil {
+			return err
+		}
+		err = op.after("tracing:after_"+op.name, endSpan)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startSpan starts the span of a query of the operation
+func startSpan(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		// the trace of a gin request is in the context of its request
+		if c, ok := ctx.(*gin.Context); ok {
+			ctx = context.Background()
+			if c != nil && c.Request != nil {
+				ctx = c.Request.Context()
+			}
+		}
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		_, span := Tracer().Start(ctx, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(db.Dialector.Name()),
+				semconv.DBOperationKey.String(operation),
+			),
+		)
+		db.InstanceSet(spanKey, span)
+	}
+}
+
+// endSpan ends the span of a query with its statement and error
+func endSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+	// the statement has placeholders, the values stay out of the trace
+	span.SetAttributes(
+		semconv.DBSQLTableKey.String(db.Statement.Table),
+		semconv.DBStatementKey.String(db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+}
@@ -8,12 +8,14 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/mrinjamul/gnote/models"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // var ApiURL = "https://note.mrinjamul.in"
@@ -22,13 +24,86 @@ var ApiURL = "https://gnote.up.railway.app"
 
 // Response is the response from the API
 type Response struct {
-	Status  string        `json:"status"`
-	Message string        `json:"message"`
-	Note    models.Note   `json:"note"`
-	Notes   []models.Note `json:"notes"`
-	Error   string        `json:"error"`
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Note    models.Note        `json:"note"`
+	Notes   []models.Note      `json:"notes"`
+	Token   string             `json:"token"`
+	Device  DeviceAuth         `json:"device"`
+	User    models.User        `json:"user"`
+	Users   []models.User      `json:"users"`
+	Total   int64              `json:"total"`
+	Usage   models.Usage       `json:"usage"`
+	Code    string             `json:"code"`
+	Invite  models.Invite      `json:"invite"`
+	Invites []models.Invite    `json:"invites"`
+	Org     models.Workspace   `json:"org"`
+	Orgs    []models.Workspace `json:"orgs"`
+	Members []models.Member    `json:"members"`
+	Error   APIError           `json:"error"`
+	// RequestID is added by the server to the errors without envelope
+	RequestID string `json:"request_id"`
 }
 
+// Err returns the error of the response
+func (r Response) Err() error {
+	err := r.Error
+	if err.RequestID == "" {
+		err.RequestID = r.RequestID
+	}
+	return err
+}
+
+// APIError is the error of a response. The API answers either a message or
+// an envelope with a code, a message, details and the request id.
+type APIError struct {
+	Code      string          `json:"code"`
+	Message   string          `json:"message"`
+	Details   json.RawMessage `json:"details"`
+	RequestID string          `json:"request_id"`
+}
+
+// UnmarshalJSON reads both forms of the errors
+func (e *APIError) UnmarshalJSON(data []byte) error {
+	var message string
+	if json.Unmarshal(data, &message) == nil {
+		*e = APIError{Message: message}
+		return nil
+	}
+	// envelope has the fields of APIError without its methods
+	type envelope APIError
+	return json.Unmarshal(data, (*envelope)(e))
+}
+
+// Error returns the message of the error with its request id, which helps
+// finding it in the logs of the server
+func (e APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.Code
+	}
+	if message == "" {
+		message = "unknown error"
+	}
+	if e.RequestID != "" {
+		message += " (request id " + e.RequestID + ")"
+	}
+	return message
+}
+
+// DeviceAuth is a single sign-on device authorization
+type DeviceAuth struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// ErrSlowDown is returned when the single sign-on is polled too often
+var ErrSlowDown = errors.New("slow_down")
+
 // HomeDir returns the home directory of the current user
 func HomeDir() string {
 	if h := os.Getenv("HOME"); h != "" {
@@ -101,6 +176,23 @@ func SaveToken(token string) error {
 	return nil
 }
 
+// SaveOrg saves the active workspace to the config file, empty for personal notes
+func SaveOrg(org string) error {
+	config, err := GetConfig()
+	if err != nil {
+		return err
+	}
+	config.Org = org
+	// Marshal the config to json
+	jsonStr, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	// Write the json to the config file
+	configFile := filepath.Join(HomeDir(), ".gnote", "config.json")
+	return ioutil.WriteFile(configFile, jsonStr, os.ModePerm)
+}
+
 // createIfNotExist creates a file if it doesn't exist
 func createIfNotExist(file string, path string) {
 	// Check if directory exists
@@ -142,9 +234,16 @@ func CLILogin(username, password string) ([]byte, error) {
 	return body, nil
 }
 
-// CLISignup signs up to the API
-func CLISignup(username, password string) ([]byte, error) {
-	jsonStr := []byte(`{"username":"` + username + `", "password":"` + password + `"}`)
+// CLISignup signs up to the API, invite is the invite code if any
+func CLISignup(username, password, invite string) ([]byte, error) {
+	jsonStr, err := json.Marshal(map[string]string{
+		"username": username,
+		"password": password,
+		"invite":   invite,
+	})
+	if err != nil {
+		return nil, err
+	}
 	body, err := sendRequest("POST", "/auth/signup", jsonStr, "")
 	if err != nil {
 		return nil, err
@@ -152,6 +251,56 @@ func CLISignup(username, password string) ([]byte, error) {
 	return body, nil
 }
 
+// CLISSOStart starts the single sign-on device flow
+func CLISSOStart() (DeviceAuth, error) {
+	var resp Response
+	body, err := sendRequest("POST", "/auth/oidc/device", nil, "")
+	if err != nil {
+		return DeviceAuth{}, err
+	}
+	// Parse json body
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return DeviceAuth{}, err
+	}
+	if resp.Status == "success" {
+		return resp.Device, nil
+	}
+	return DeviceAuth{}, resp.Err()
+}
+
+// CLISSOToken polls the token of the single sign-on device flow,
+// the token is empty while the sign in is pending. The invite code is used
+// when the sign in creates the account.
+func CLISSOToken(deviceCode, invite string) (string, error) {
+	var resp Response
+	jsonStr, err := json.Marshal(map[string]string{
+		"device_code": deviceCode,
+		"invite":      invite,
+	})
+	if err != nil {
+		return "", err
+	}
+	body, err := sendRequest("POST", "/auth/oidc/device/token", jsonStr, "")
+	if err != nil {
+		return "", err
+	}
+	// Parse json body
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resp.Status == "success":
+		return resp.Token, nil
+	case resp.Error.Message == "authorization_pending":
+		return "", nil
+	case resp.Error.Message == "slow_down":
+		return "", ErrSlowDown
+	}
+	return "", resp.Err()
+}
+
 // sendRequest sends a request to the API
 func sendRequest(method, path string, jsonData []byte, token string) ([]byte, error) {
 	// Create a new request
@@ -169,8 +318,8 @@ func sendRequest(method, path string, jsonData []byte, token string) ([]byte, er
 	// req.URL.RawQuery = q.Encode()
 	// Set Bearer authorization
 	req.Header.Set("Authorization", "Bearer "+token)
-	// Send the request
-	client := &http.Client{}
+	// Send the request, with the W3C trace context of its span
+	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -184,11 +333,47 @@ func sendRequest(method, path string, jsonData []byte, token string) ([]byte, er
 	return body, nil
 }
 
-// CreateNote creates a note
-func CreateNote(title, content string, token string) (models.Note, error) {
+// ChangePassword changes the password of the user and returns the new token
+func ChangePassword(currentPassword, password string, token string) (string, error) {
+	jsonStr, err := json.Marshal(map[string]string{
+		"current_password": currentPassword,
+		"password":         password,
+	})
+	if err != nil {
+		return "", err
+	}
+	body, err := sendRequest("POST", "/user/me/password", jsonStr, token)
+	if err != nil {
+		return "", err
+	}
+	var resp Response
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Status != "success" {
+		if resp.Message == "bad password" {
+			return "", errors.New("the new password is too weak")
+		}
+		return "", resp.Err()
+	}
+	return resp.Token, nil
+}
+
+// notesPath returns the notes endpoint of the workspace, personal notes
+// when org is empty
+func notesPath(org string) string {
+	if org == "" {
+		return "/api/notes"
+	}
+	return "/api/orgs/" + url.PathEscape(org) + "/notes"
+}
+
+// CreateNote creates a note in the workspace
+func CreateNote(org, title, content string, token string) (models.Note, error) {
 	var resp Response
 	jsonStr := []byte(`{"title":"` + title + `", "content":"` + content + `"}`)
-	body, err := sendRequest("POST", "/api/notes", jsonStr, token)
+	body, err := sendRequest("POST", notesPath(org), jsonStr, token)
 	if err != nil {
 		return models.Note{}, err
 	}
@@ -200,13 +385,13 @@ func CreateNote(title, content string, token string) (models.Note, error) {
 	if resp.Status == "success" || resp.Message == "success" {
 		return resp.Note, nil
 	}
-	return models.Note{}, errors.New(resp.Error)
+	return models.Note{}, resp.Err()
 }
 
-// GetNotes gets all notes
-func GetNotes(token string) ([]models.Note, error) {
+// GetNotes gets all notes of the workspace
+func GetNotes(org string, token string) ([]models.Note, error) {
 	var resp Response
-	body, err := sendRequest("GET", "/api/notes", nil, token)
+	body, err := sendRequest("GET", notesPath(org), nil, token)
 	if err != nil {
 		return []models.Note{}, err
 	}
@@ -218,14 +403,14 @@ func GetNotes(token string) ([]models.Note, error) {
 	if resp.Status == "success" || resp.Message == "success" {
 		return resp.Notes, nil
 	}
-	return []models.Note{}, errors.New(resp.Error)
+	return []models.Note{}, resp.Err()
 }
 
-// GetNote gets a note
-func GetNote(id string, token string) (models.Note, error) {
+// GetNote gets a note of the workspace
+func GetNote(org, id string, token string) (models.Note, error) {
 	var resp Response
 	jsonStr := []byte(`{"id":"` + id + `"}`)
-	body, err := sendRequest("GET", "/api/notes/"+id, jsonStr, token)
+	body, err := sendRequest("GET", notesPath(org)+"/"+id, jsonStr, token)
 	if err != nil {
 		return models.Note{}, err
 	}
@@ -237,14 +422,14 @@ func GetNote(id string, token string) (models.Note, error) {
 	if resp.Status == "success" || resp.Message == "success" {
 		return resp.Note, nil
 	}
-	return models.Note{}, errors.New(resp.Error)
+	return models.Note{}, resp.Err()
 }
 
-// UpdateNote updates a note
-func UpdateNote(id, title, content string, token string) (models.Note, error) {
+// UpdateNote updates a note of the workspace
+func UpdateNote(org, id, title, content string, token string) (models.Note, error) {
 	var resp Response
 	jsonStr := []byte(`{"title":"` + title + `", "content":"` + content + `"}`)
-	body, err := sendRequest("PUT", "/api/notes/"+id, jsonStr, token)
+	body, err := sendRequest("PUT", notesPath(org)+"/"+id, jsonStr, token)
 	if err != nil {
 		return models.Note{}, err
 	}
@@ -256,14 +441,14 @@ func UpdateNote(id, title, content string, token string) (models.Note, error) {
 	if resp.Status == "success" || resp.Message == "success" {
 		return resp.Note, nil
 	}
-	return models.Note{}, errors.New(resp.Error)
+	return models.Note{}, resp.Err()
 }
 
-// DeleteNote deletes a note
-func DeleteNote(id string, token string) (models.Note, error) {
+// DeleteNote deletes a note of the workspace
+func DeleteNote(org, id string, token string) (models.Note, error) {
 	var resp Response
 	jsonStr := []byte(`{"id":"` + id + `"}`)
-	body, err := sendRequest("DELETE", "/api/notes/"+id, jsonStr, token)
+	body, err := sendRequest("DELETE", notesPath(org)+"/"+id, jsonStr, token)
 	if err != nil {
 		return models.Note{}, err
 	}
@@ -275,7 +460,227 @@ func DeleteNote(id string, token string) (models.Note, error) {
 	if resp.Status == "success" || resp.Message == "success" {
 		return resp.Note, nil
 	}
-	return models.Note{}, errors.New(resp.Error)
+	return models.Note{}, resp.Err()
+}
+
+// adminRequest sends a request to the admin API
+func adminRequest(method, path string, jsonData []byte, token string) (Response, error) {
+	var resp Response
+	body, err := sendRequest(method, "/admin"+path, jsonData, token)
+	if err != nil {
+		return resp, err
+	}
+	// Parse json body
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Status == "success" {
+		return resp, nil
+	}
+	return resp, resp.Err()
+}
+
+// AdminListUsers lists the users matching the query
+func AdminListUsers(query string, token string) ([]models.User, int64, error) {
+	resp, err := adminRequest("GET", "/users?q="+url.QueryEscape(query), nil, token)
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Users, resp.Total, nil
+}
+
+// AdminGetUser gets a user and its storage usage
+func AdminGetUser(username string, token string) (models.User, models.Usage, error) {
+	resp, err := adminRequest("GET", "/users/"+url.PathEscape(username), nil, token)
+	if err != nil {
+		return models.User{}, models.Usage{}, err
+	}
+	return resp.User, resp.Usage, nil
+}
+
+// AdminUserAction runs an action (suspend, unsuspend, reset, unlock) on a user
+func AdminUserAction(username, action string, token string) (string, error) {
+	resp, err := adminRequest("POST", "/users/"+url.PathEscape(username)+"/"+action, nil, token)
+	if err != nil {
+		return "", err
+	}
+	return resp.Message, nil
+}
+
+// AdminUpdateUser changes the role and the level of a user, empty values are kept
+func AdminUpdateUser(username, role string, level int, token string) (models.User, error) {
+	data := map[string]interface{}{}
+	if role != "" {
+		data["role"] = role
+	}
+	if level != 0 {
+		data["level"] = level
+	}
+	jsonStr, err := json.Marshal(data)
+	if err != nil {
+		return models.User{}, err
+	}
+	resp, err := adminRequest("PATCH", "/users/"+url.PathEscape(username), jsonStr, token)
+	if err != nil {
+		return models.User{}, err
+	}
+	return resp.User, nil
+}
+
+// AdminCreateInvite creates an invite code, expiresIn is a duration like 72h
+func AdminCreateInvite(maxUses int, expiresIn, role string, level int, token string) (string, models.Invite, error) {
+	jsonStr, err := json.Marshal(map[string]interface{}{
+		"max_uses":   maxUses,
+		"expires_in": expiresIn,
+		"role":       role,
+		"level":      level,
+	})
+	if err != nil {
+		return "", models.Invite{}, err
+	}
+	resp, err := adminRequest("POST", "/invites", jsonStr, token)
+	if err != nil {
+		return "", models.Invite{}, err
+	}
+	return resp.Code, resp.Invite, nil
+}
+
+// AdminListInvites lists the invite codes
+func AdminListInvites(token string) ([]models.Invite, error) {
+	resp, err := adminRequest("GET", "/invites", nil, token)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Invites, nil
+}
+
+// AdminRevokeInvite revokes an invite code
+func AdminRevokeInvite(id string, token string) error {
+	_, err := adminRequest("DELETE", "/invites/"+url.PathEscape(id), nil, token)
+	return err
+}
+
+// orgRequest sends a request to the organizations API
+func orgRequest(method, path string, data interface{}, token string) (Response, error) {
+	var resp Response
+	var jsonStr []byte
+	if data != nil {
+		var err error
+		jsonStr, err = json.Marshal(data)
+		if err != nil {
+			return resp, err
+		}
+	}
+	body, err := sendRequest(method, "/api/orgs"+path, jsonStr, token)
+	if err != nil {
+		return resp, err
+	}
+	// Parse json body
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Status == "success" {
+		return resp, nil
+	}
+	return resp, resp.Err()
+}
+
+// CreateOrg creates an organization
+func CreateOrg(name, displayName string, token string) (models.Workspace, error) {
+	resp, err := orgRequest("POST", "", map[string]string{
+		"name":         name,
+		"display_name": displayName,
+	}, token)
+	if err != nil {
+		return models.Workspace{}, err
+	}
+	return resp.Org, nil
+}
+
+// GetOrgs gets the organizations of the user
+func GetOrgs(token string) ([]models.Workspace, error) {
+	resp, err := orgRequest("GET", "", nil, token)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Orgs, nil
+}
+
+// GetOrgMembers gets the members of an organization
+func GetOrgMembers(org string, token string) ([]models.Member, error) {
+	resp, err := orgRequest("GET", "/"+url.PathEscape(org)+"/members", nil, token)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Members, nil
+}
+
+// InviteToOrg creates an invite code to an organization, mailed when email is set
+func InviteToOrg(org, role, email string, token string) (string, error) {
+	resp, err := orgRequest("POST", "/"+url.PathEscape(org)+"/invites", map[string]string{
+		"role":  role,
+		"email": email,
+	}, token)
+	if err != nil {
+		return "", err
+	}
+	return resp.Code, nil
+}
+
+// JoinOrg accepts an invite to an organization
+func JoinOrg(org, code string, token string) (models.Workspace, error) {
+	resp, err := orgRequest("POST", "/"+url.PathEscape(org)+"/join", map[string]string{
+		"code": code,
+	}, token)
+	if err != nil {
+		return models.Workspace{}, err
+	}
+	return resp.Org, nil
+}
+
+// RemoveOrgMember removes a member from an organization
+func RemoveOrgMember(org, username string, token string) error {
+	_, err := orgRequest("DELETE", "/"+url.PathEscape(org)+"/members/"+url.PathEscape(username), nil, token)
+	return err
+}
+
+// PrintInvite prints an invite code
+func PrintInvite(invite models.Invite) {
+	var printableData string
+	printableData += "[" + strconv.Itoa(int(invite.ID)) + "]" + "\t" + invite.Hint + "-...\t" + invite.Role + " level " + strconv.Itoa(invite.Level) + "\n"
+	uses := strconv.Itoa(invite.Uses)
+	if invite.MaxUses > 0 {
+		uses += "/" + strconv.Itoa(invite.MaxUses)
+	}
+	printableData += "Uses: " + uses + "\n"
+	if invite.ExpiresAt.Valid {
+		printableData += "Expires on: " + invite.ExpiresAt.Time.String() + "\n"
+	}
+	if invite.RevokedAt.Valid {
+		printableData += "Revoked on: " + invite.RevokedAt.Time.String() + "\n"
+	}
+	printableData += "Created by: " + invite.CreatedBy + " on " + invite.CreatedAt.String() + "\n"
+	fmt.Println(printableData)
+}
+
+// PrintUser prints a user as seen by admins
+func PrintUser(user models.User) {
+	var printableData string
+	printableData += "[" + strconv.Itoa(int(user.ID)) + "]" + "\t" + user.Username + " <" + user.Email + ">\n"
+	printableData += "Role: " + user.Role + "\tLevel: " + strconv.Itoa(user.Level) + "\n"
+	if user.SuspendedAt.Valid {
+		printableData += "Suspended on: " + user.SuspendedAt.Time.String() + "\n"
+	}
+	if user.DeletionDueAt.Valid {
+		printableData += "Deletion due on: " + user.DeletionDueAt.Time.String() + "\n"
+	}
+	if user.ResetRequired {
+		printableData += "Password reset required\n"
+	}
+	printableData += "Created on: " + user.CreatedAt.String() + "\n"
+	fmt.Println(printableData)
 }
 
 func PrintNote(note models.Note) {
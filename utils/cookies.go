@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"net/http"
+
+	"github.com/mrinjamul/gnote/config"
+)
+
+var (
+	// CookieSameSite is the SameSite attribute of the cookies set by the server
+	CookieSameSite = http.SameSiteLaxMode
+	// SecureCookies restricts the cookies set by the server to HTTPS
+	SecureCookies bool
+)
+
+// ConfigureCookies sets the attributes of the cookies set by the server
+func ConfigureCookies(cfg config.Security) {
+	SecureCookies = cfg.CookieSecure
+	switch cfg.CookieSameSite {
+	case "strict":
+		CookieSameSite = http.SameSiteStrictMode
+	case "none":
+		CookieSameSite = http.SameSiteNoneMode
+	default:
+		CookieSameSite = http.SameSiteLaxMode
+	}
+}
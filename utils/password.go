@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/mrinjamul/gnote/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// HashArgon2id hashes passwords with Argon2id
+	HashArgon2id = "argon2id"
+	// HashBcrypt hashes passwords with bcrypt
+	HashBcrypt = "bcrypt"
+)
+
+// argon2Params are the cost parameters of an Argon2id hash
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+var (
+	// passwordHash is the algorithm of new password hashes
+	passwordHash = HashArgon2id
+	// bcryptCost is the cost of new bcrypt hashes
+	bcryptCost = bcrypt.DefaultCost
+	// argon2Cost is the cost of new Argon2id hashes, memory is in KiB
+	argon2Cost = argon2Params{memory: 64 * 1024, time: 3, threads: 4}
+	// argon2Slots bounds the Argon2id hashes computed at once, each one
+	// takes its memory cost, so a burst of logins can't exhaust the memory
+	argon2Slots = make(chan struct{}, runtime.NumCPU())
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ConfigurePasswords sets the algorithm and the cost of new password hashes
+func ConfigurePasswords(cfg config.Password) {
+	passwordHash = cfg.Hash
+	bcryptCost = cfg.BcryptCost
+	argon2Cost.memory = cfg.Argon2Memory
+	argon2Cost.time = cfg.Argon2Time
+	concurrency := cfg.HashConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	argon2Slots = make(chan struct{}, concurrency)
+}
+
+// argon2Key computes an Argon2id key once a slot is free
+func argon2Key(password, salt []byte, params argon2Params, keyLength uint32) []byte {
+	slots := argon2Slots
+	slots <- struct{}{}
+	defer func() { <-slots }()
+	return argon2.IDKey(password, salt, params.time, params.memory, params.threads, keyLength)
+}
+
+// HashAndSalt generates a hashed password with the configured algorithm
+func HashAndSalt(password string) (string, error) {
+	if passwordHash == HashBcrypt {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashedPassword), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2Key([]byte(password), salt, argon2Cost, argon2KeyLength)
+	// encode in the PHC string format
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argon2Cost.memory, argon2Cost.time, argon2Cost.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyHash verifies the hashed password
+func VerifyHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false
+		}
+		other := argon2Key([]byte(password), salt, params, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return err == nil
+}
+
+// NeedsRehash checks if the hash uses another algorithm or a lower cost than
+// the configured one, so it can be replaced after a successful login
+func NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if passwordHash != HashArgon2id {
+			return true
+		}
+		params, _, _, err := decodeArgon2(hash)
+		if err != nil {
+			return true
+		}
+		return params.memory < argon2Cost.memory ||
+			params.time < argon2Cost.time ||
+			params.threads < argon2Cost.threads
+	}
+	if passwordHash != HashBcrypt {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < bcryptCost
+}
+
+// decodeArgon2 decodes an Argon2id hash in the PHC string format
+func decodeArgon2(hash string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2id version")
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil || params.memory == 0 || params.time == 0 || params.threads == 0 {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	if len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mrinjamul/gnote/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// configurePasswords sets the password hashing for the test, the previous
+// settings are restored after it
+func configurePasswords(t *testing.T, cfg config.Password) {
+	t.Helper()
+	hash, cost, params, slots := passwordHash, bcryptCost, argon2Cost, argon2Slots
+	t.Cleanup(func() {
+		passwordHash, bcryptCost, argon2Cost, argon2Slots = hash, cost, params, slots
+	})
+	ConfigurePasswords(cfg)
+}
+
+// cheapArgon2 keeps the Argon2id hashes of the tests fast
+var cheapArgon2 = config.Password{Hash: HashArgon2id, BcryptCost: bcrypt.MinCost, Argon2Memory: 1024, Argon2Time: 1}
+
+func TestHashAndSaltArgon2id(t *testing.T) {
+	configurePasswords(t, config.Password{Hash: HashArgon2id, BcryptCost: bcrypt.MinCost, Argon2Memory: 8 * 1024, Argon2Time: 2})
+
+	hash, err := HashAndSalt("Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=8192,t=2,p=4$") {
+		t.Errorf("HashAndSalt() = %q, want an Argon2id hash with the configured cost", hash)
+	}
+	params, salt, key, err := decodeArgon2(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != (argon2Params{memory: 8 * 1024, time: 2, threads: 4}) || len(salt) != argon2SaltLength || len(key) != argon2KeyLength {
+		t.Errorf("decoded %+v with %d bytes of salt and %d of key", params, len(salt), len(key))
+	}
+
+	other, err := HashAndSalt("Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash {
+		t.Error("two hashes of the same password are equal, want different salts")
+	}
+	if !VerifyHash("Passw0rd!", hash) || VerifyHash("passw0rd!", hash) {
+		t.Error("VerifyHash() doesn't tell the password apart")
+	}
+}
+
+func TestVerifyHashBcrypt(t *testing.T) {
+	configurePasswords(t, config.Password{Hash: HashBcrypt, BcryptCost: bcrypt.MinCost})
+
+	hash, err := HashAndSalt("Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$2a$") {
+		t.Errorf("HashAndSalt() = %q, want a bcrypt hash", hash)
+	}
+	if !VerifyHash("Passw0rd!", hash) || VerifyHash("other", hash) {
+		t.Error("VerifyHash() doesn't tell the password apart")
+	}
+	if VerifyHash("Passw0rd!", "$argon2id$v=19$broken") {
+		t.Error("VerifyHash() accepts a broken Argon2id hash")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	configurePasswords(t, cheapArgon2)
+	argon2Hash, err := HashAndSalt("Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("Passw0rd!"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.Password
+		hash string
+		want bool
+	}{
+		{"argon2id at the configured cost", cheapArgon2, argon2Hash, false},
+		{"argon2id below the configured memory", config.Password{Hash: HashArgon2id, Argon2Memory: 2048, Argon2Time: 1}, argon2Hash, true},
+		{"argon2id below the configured time", config.Password{Hash: HashArgon2id, Argon2Memory: 1024, Argon2Time: 2}, argon2Hash, true},
+		{"argon2id above the configured cost", config.Password{Hash: HashArgon2id, Argon2Memory: 512, Argon2Time: 1}, argon2Hash, false},
+		{"bcrypt when argon2id is configured", cheapArgon2, string(bcryptHash), true},
+		{"argon2id when bcrypt is configured", config.Password{Hash: HashBcrypt, BcryptCost: bcrypt.MinCost}, argon2Hash, true},
+		{"bcrypt at the configured cost", config.Password{Hash: HashBcrypt, BcryptCost: bcrypt.MinCost}, string(bcryptHash), false},
+		{"bcrypt below the configured cost", config.Password{Hash: HashBcrypt, BcryptCost: bcrypt.MinCost + 1}, string(bcryptHash), true},
+		{"broken argon2id", cheapArgon2, "$argon2id$v=19$broken", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configurePasswords(t, tt.cfg)
+			if got := NeedsRehash(tt.hash); got != tt.want {
+				t.Errorf("NeedsRehash() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
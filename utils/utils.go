@@ -1,6 +1,12 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
@@ -9,7 +15,6 @@ import (
 	"unicode"
 
 	"github.com/mrinjamul/gnote/models"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // GetEnv gets the environment variable
@@ -31,23 +36,63 @@ func ToMaxAge(expire time.Time) int {
 	return int(maxAge)
 }
 
-// HashAndSalt generates a hashed password
-func HashAndSalt(password string) (string, error) {
-	// Generate a hashed password with bcypt
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// GenerateSignedToken generates a random token signed for the given purpose.
+// It returns the token to hand out and the hash to be stored.
+func GenerateSignedToken(secret, purpose string) (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	token := payload + "." + signToken(secret, purpose, payload)
+	return token, HashToken(token), nil
+}
+
+// VerifySignedToken verifies the signature of a token for the given purpose
+// and returns the hash to look it up
+func VerifySignedToken(secret, purpose, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return "", errors.New("malformed token")
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(signToken(secret, purpose, parts[0]))) {
+		return "", errors.New("invalid token signature")
 	}
-	return string(hashedPassword), nil
+	return HashToken(token), nil
+}
+
+// HashToken returns the hash under which a token is stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-// VerifyHash verifies the hashed password
-func VerifyHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// GenerateInviteCode generates a random invite code easy to type,
+// like ABCD-EFGH-IJKL-MNOP
+func GenerateInviteCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
 	if err != nil {
-		return err == nil
+		return "", err
 	}
-	return true
+	code := base32.StdEncoding.EncodeToString(b)
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// NormalizeInviteCode removes the separators and the case of an invite code
+func NormalizeInviteCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	return code
+}
+
+// signToken signs the payload of a token for the given purpose
+func signToken(secret, purpose, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose + ":" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
 }
 
 // ValidateUser the user informations